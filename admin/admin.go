@@ -0,0 +1,226 @@
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/alert"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/login"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+)
+
+// tokenCookie 保存管理员 token 的 cookie 名称
+const tokenCookie = "wechatbot_admin_token"
+
+// Register 在内置 http 服务上注册管理后台和登录二维码页面, 未配置 admin_token 时都不注册
+func Register() {
+	if config.LoadConfig().AdminToken == "" {
+		logger.Info("admin console disabled, set admin_token to enable it")
+		return
+	}
+	server.HandleFunc("/admin", authorized(dashboard))
+	server.HandleFunc("/admin/config", authorized(saveConfig))
+	server.HandleFunc("/admin/login", loginPage)
+	server.HandleFunc("/admin/qrcode", authorized(login.QrCodePage))
+	server.HandleFunc("/admin/qrcode.png", authorized(login.QrCodeImage))
+}
+
+// authorized 校验管理员 token, token 可以放在 Authorization: Bearer 请求头或登录后的 cookie 中
+func authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if header := r.Header.Get("Authorization"); len(header) > len("Bearer ") && header[:len("Bearer ")] == "Bearer " {
+			token = header[len("Bearer "):]
+		} else if cookie, err := r.Cookie(tokenCookie); err == nil {
+			token = cookie.Value
+		}
+		if !validToken(token) {
+			http.Redirect(w, r, "/admin/login", http.StatusFound)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func validToken(token string) bool {
+	return config.LoadConfig().ValidAdminToken(token)
+}
+
+// loginPage 输入管理员 token 登录
+func loginPage(w http.ResponseWriter, r *http.Request) {
+	data := map[string]string{}
+	if r.Method == http.MethodPost {
+		token := r.PostFormValue("token")
+		if validToken(token) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    token,
+				Path:     "/admin",
+				HttpOnly: true,
+				Secure:   r.TLS != nil || strings.HasPrefix(config.LoadConfig().PublicURL, "https://"),
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, "/admin", http.StatusFound)
+			return
+		}
+		data["Error"] = "token 错误"
+	}
+	render(w, "login", data)
+}
+
+// dashboardData 管理后台首页的数据
+type dashboardData struct {
+	Accounts         []accountStatus
+	Version          string
+	Uptime           string
+	MessagesReceived uint64
+	RepliesSent      uint64
+	LastError        string
+	Conversations    []stats.Conversation
+	Messages         []stats.MessageRecord
+	Usage            []modelUsage
+	Hourly           []hourlyBar
+	ConfigFile       string
+	ConfigContent    string
+	ConfigErrors     string
+	ConfigSaved      bool
+}
+
+// accountStatus 一个微信账号的登录状态, 单账号模式下账号名称为空
+type accountStatus struct {
+	Account  string
+	Status   string
+	NickName string
+	QrCode   bool
+	// 掉线后重新登录成功的次数和累计的离线时长
+	Reconnects int
+	Downtime   string
+}
+
+type modelUsage struct {
+	Model string
+	stats.TokenUsage
+	// 柱状图宽度百分比
+	Percent int
+}
+
+type hourlyBar struct {
+	Hour    string
+	Count   uint64
+	Percent int
+}
+
+func dashboard(w http.ResponseWriter, r *http.Request) {
+	// 配置文件中的密钥打码后展示, 提交时再还原
+	content, err := config.ReadMaskedConfigFile()
+	if err != nil {
+		logger.Warning(fmt.Sprintf("read config file error: %v", err))
+	}
+	data := buildDashboard()
+	data.ConfigContent = string(content)
+	data.ConfigSaved = r.URL.Query().Get("saved") == "1"
+	render(w, "dashboard", data)
+}
+
+func buildDashboard() dashboardData {
+	cfg := config.LoadConfig()
+	data := dashboardData{
+		Version:          stats.Version,
+		Uptime:           alert.HumanDuration(stats.Uptime()),
+		MessagesReceived: stats.MessagesReceived(),
+		RepliesSent:      stats.RepliesSent(),
+		LastError:        stats.LastError(),
+		Conversations:    stats.ActiveConversations(time.Now().Add(-cfg.SessionTimeout.Duration)),
+		Messages:         stats.RecentMessages(),
+		ConfigFile:       config.ConfigFile(),
+	}
+
+	for _, state := range login.All() {
+		_, hasQrCode := state.Current()
+		reconnects, downtime := state.Reconnects()
+		data.Accounts = append(data.Accounts, accountStatus{
+			Account:    state.Account(),
+			Status:     state.Status(),
+			NickName:   state.NickName(),
+			QrCode:     hasQrCode,
+			Reconnects: reconnects,
+			Downtime:   alert.HumanDuration(downtime),
+		})
+	}
+
+	var maxTokens uint64
+	for model, usage := range stats.Usage() {
+		data.Usage = append(data.Usage, modelUsage{Model: model, TokenUsage: usage})
+		if total := usage.PromptTokens + usage.CompletionTokens; total > maxTokens {
+			maxTokens = total
+		}
+	}
+	sort.Slice(data.Usage, func(i, j int) bool { return data.Usage[i].Model < data.Usage[j].Model })
+	for i := range data.Usage {
+		data.Usage[i].Percent = percent(data.Usage[i].PromptTokens+data.Usage[i].CompletionTokens, maxTokens)
+	}
+
+	hourly := stats.HourlyMessages()
+	var maxCount uint64
+	for _, count := range hourly {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+	now := time.Now().Truncate(time.Hour)
+	for i, count := range hourly {
+		data.Hourly = append(data.Hourly, hourlyBar{
+			Hour:    now.Add(time.Duration(i-23) * time.Hour).Format("15:04"),
+			Count:   count,
+			Percent: percent(count, maxCount),
+		})
+	}
+	return data
+}
+
+func percent(value, max uint64) int {
+	if max == 0 {
+		return 0
+	}
+	return int(value * 100 / max)
+}
+
+// saveConfig 校验并保存配置文件, 校验通过后立即生效
+func saveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+	submitted := r.PostFormValue("content")
+	content, err := config.UnmaskConfigContent([]byte(submitted))
+	if err == nil {
+		_, err = config.Reload(content)
+	}
+	if err != nil {
+		data := buildDashboard()
+		data.ConfigContent = submitted
+		data.ConfigErrors = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, "dashboard", data)
+		return
+	}
+	logger.Info(fmt.Sprintf("config file %s updated from admin console", config.ConfigFile()))
+	http.Redirect(w, r, "/admin?saved=1", http.StatusFound)
+}
+
+func render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		logger.Warning(fmt.Sprintf("render admin page %s error: %v", name, err))
+	}
+}
+
+var templates = template.Must(template.New("admin").Parse(layout))
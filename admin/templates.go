@@ -0,0 +1,92 @@
+package admin
+
+// layout 管理后台页面模板
+const layout = `
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>wechatbot admin</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 1100px; padding: 16px; color: #222; }
+section { border: 1px solid #ddd; border-radius: 6px; margin-bottom: 16px; padding: 12px 16px; }
+table { border-collapse: collapse; width: 100%; font-size: 14px; }
+th, td { border-bottom: 1px solid #eee; padding: 4px 8px; text-align: left; vertical-align: top; }
+.bar { background: #4a90d9; height: 12px; }
+.status-online { color: #2a2; } .status-offline { color: #d22; } .status-waiting_scan, .status-reconnecting { color: #d80; }
+.error { color: #d22; white-space: pre-wrap; }
+textarea { width: 100%; height: 360px; font-family: monospace; }
+pre { white-space: pre-wrap; margin: 0; }
+</style>
+</head>
+<body>{{end}}
+
+{{define "login"}}{{template "head"}}
+<h2>wechatbot admin</h2>
+<form method="post" action="/admin/login">
+<input type="password" name="token" placeholder="admin token" autofocus>
+<button type="submit">登录</button>
+</form>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+</body></html>{{end}}
+
+{{define "dashboard"}}{{template "head"}}
+<h2>wechatbot admin</h2>
+
+<section>
+<h3>状态</h3>
+<table>
+{{range .Accounts}}<tr><th>登录状态{{with .Account}} {{.}}{{end}}</th><td class="status-{{.Status}}">{{.Status}}{{with .NickName}} ({{.}}){{end}}{{if .Reconnects}}，已重连 {{.Reconnects}} 次，累计离线 {{.Downtime}}{{end}}</td></tr>
+{{end}}<tr><th>版本</th><td>{{.Version}}</td></tr>
+<tr><th>运行时长</th><td>{{.Uptime}}</td></tr>
+<tr><th>收到消息 / 发出回复</th><td>{{.MessagesReceived}} / {{.RepliesSent}}</td></tr>
+<tr><th>最近错误</th><td class="error">{{.LastError}}</td></tr>
+</table>
+{{range .Accounts}}{{if .QrCode}}<p>请使用微信扫码登录{{with .Account}}账号 {{.}}{{end}}：</p><img src="/admin/qrcode.png?account={{.Account}}" alt="login qrcode" width="256" height="256">{{end}}{{end}}
+</section>
+
+<section>
+<h3>活跃会话 ({{len .Conversations}})</h3>
+<table>
+<tr><th>会话</th><th>类型</th><th>消息数</th><th>最近活跃</th></tr>
+{{range .Conversations}}<tr><td>{{.Name}}</td><td>{{if .IsGroup}}群聊{{else}}私聊{{end}}</td><td>{{.Messages}}</td><td>{{.LastActive.Format "01-02 15:04:05"}}</td></tr>
+{{end}}
+</table>
+</section>
+
+<section>
+<h3>用量</h3>
+<table>
+<tr><th>模型</th><th>请求数</th><th>prompt tokens</th><th>completion tokens</th><th>图片</th><th style="width: 30%"></th></tr>
+{{range .Usage}}<tr><td>{{.Model}}</td><td>{{.Requests}}</td><td>{{.PromptTokens}}</td><td>{{.CompletionTokens}}</td><td>{{.Images}}</td><td><div class="bar" style="width: {{.Percent}}%"></div></td></tr>
+{{end}}
+</table>
+<h4>最近 24 小时消息数</h4>
+<table>
+{{range .Hourly}}<tr><td style="width: 60px">{{.Hour}}</td><td style="width: 60px">{{.Count}}</td><td><div class="bar" style="width: {{.Percent}}%"></div></td></tr>
+{{end}}
+</table>
+</section>
+
+<section>
+<h3>最近消息</h3>
+<table>
+<tr><th>时间</th><th>会话</th><th>发送者</th><th>消息</th><th>回复</th></tr>
+{{range .Messages}}<tr><td>{{.Time.Format "01-02 15:04:05"}}</td><td>{{.Conversation}}</td><td>{{.Sender}}</td><td><pre>{{.Content}}</pre></td><td><pre>{{.Reply}}</pre></td></tr>
+{{end}}
+</table>
+</section>
+
+<section>
+<h3>配置 {{.ConfigFile}}</h3>
+{{if .ConfigSaved}}<p class="status-online">配置已保存并生效</p>{{end}}
+{{with .ConfigErrors}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="/admin/config">
+<textarea name="content">{{.ConfigContent}}</textarea>
+<p>保存前会校验配置，校验通过后立即生效。环境变量和命令行参数仍然优先于配置文件；api key 池、会话超时和 http 服务的修改需要重启。</p>
+<button type="submit">校验并保存</button>
+</form>
+</section>
+</body></html>{{end}}
+`
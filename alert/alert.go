@@ -0,0 +1,174 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/notifier"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+)
+
+// 告警事件
+const (
+	EventStarted        = "started"
+	EventLoggedOut      = "logged_out"
+	EventReconnected    = "reconnected"
+	EventReloginStopped = "relogin_stopped"
+	EventDead           = "dead"
+	EventPanic          = "panic"
+	EventQuotaExhausted = "quota_exhausted"
+	EventKeyRevoked     = "key_revoked"
+	EventLoginQrCode    = "login_qrcode"
+	EventUsageReport    = "usage_report"
+)
+
+// Data 告警模板中可以使用的变量
+type Data struct {
+	// 事件名称
+	Event string
+	// 实例名称, 默认为主机名, 个人微信账号的告警带上账号名称, 如 host/account
+	Instance string
+	// 相关的个人微信账号, 单账号模式下为空
+	Account string
+	// 主机名
+	Hostname string
+	// 版本号
+	Version string
+	// 启动时间
+	StartedAt time.Time
+	// 已运行时长, 如 1d2h3m
+	Uptime string
+	// 告警时间
+	Time time.Time
+	// 最近一次错误
+	LastError string
+	// 收到的消息数
+	MessagesReceived uint64
+	// 发出的回复数
+	RepliesSent uint64
+	// 相关的 api key, 已打码
+	Key string
+	// 暂停使用直到该时间
+	Until time.Time
+	// 登录二维码的内容
+	LoginURL string
+	// 登录二维码页面的外部访问地址, 未配置 public_url 时为空
+	QrCodePage string
+	// 用量报告的内容
+	Report string
+	// 本次掉线的离线时长, 如 3m
+	Downtime string
+	// 掉线后重新登录成功的累计次数
+	Reconnects int
+}
+
+// Send 渲染事件对应的告警模板, 发送到所有告警渠道. data 中未设置的公共变量会自动填充
+func Send(ctx context.Context, event string, data Data) error {
+	message, err := Render(event, data)
+	if err != nil {
+		return err
+	}
+	return notifier.New(config.LoadConfig().NotifierConfigs()).Notify(ctx, message)
+}
+
+// SendImage 渲染告警模板, 连同图片发送到所有告警渠道, 不支持图片的渠道只发送文本
+func SendImage(ctx context.Context, event string, data Data, filename string, image []byte) error {
+	message, err := Render(event, data)
+	if err != nil {
+		return err
+	}
+	return notifier.New(config.LoadConfig().NotifierConfigs()).NotifyImage(ctx, message, filename, image)
+}
+
+// SendImageAsync 异步发送带图片的告警, 失败时只记录日志
+func SendImageAsync(event string, data Data, filename string, image []byte) {
+	go func() {
+		if err := SendImage(context.Background(), event, data, filename, image); err != nil {
+			logger.Info(fmt.Sprintf("调用告警失败: %s, event: %s", err.Error(), event))
+		}
+	}()
+}
+
+// SendAsync 异步发送告警, 失败时只记录日志
+func SendAsync(event string, data Data) {
+	go func() {
+		if err := Send(context.Background(), event, data); err != nil {
+			logger.Info(fmt.Sprintf("调用告警失败: %s, event: %s", err.Error(), event))
+		}
+	}()
+}
+
+// Render 渲染事件对应的告警模板
+func Render(event string, data Data) (string, error) {
+	cfg := config.LoadConfig()
+	text, ok := cfg.AlertTemplates[event]
+	if !ok {
+		return "", fmt.Errorf("unknown alert event %q", event)
+	}
+	tmpl, err := template.New(event).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse alert template %q err: %v", event, err)
+	}
+
+	fill(&data, event, cfg)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render alert template %q err: %v", event, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// fill 填充公共变量
+func fill(data *Data, event string, cfg *config.Configuration) {
+	hostname, _ := os.Hostname()
+	data.Event = event
+	data.Hostname = hostname
+	data.Instance = cfg.InstanceName
+	if data.Instance == "" {
+		data.Instance = hostname
+	}
+	if data.Account != "" {
+		data.Instance += "/" + data.Account
+	}
+	data.Version = stats.Version
+	data.StartedAt = stats.StartedAt
+	data.Uptime = HumanDuration(stats.Uptime())
+	data.Time = time.Now()
+	data.MessagesReceived = stats.MessagesReceived()
+	data.RepliesSent = stats.RepliesSent()
+	if data.LastError == "" {
+		data.LastError = stats.LastError()
+	}
+}
+
+// HumanDuration 将时长格式化为 1d2h3m 的形式, 不足一分钟时精确到秒
+func HumanDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+
+	var b strings.Builder
+	if days > 0 {
+		fmt.Fprintf(&b, "%dd", days)
+	}
+	if hours > 0 {
+		fmt.Fprintf(&b, "%dh", hours)
+	}
+	if minutes > 0 || b.Len() == 0 {
+		fmt.Fprintf(&b, "%dm", minutes)
+	}
+	return b.String()
+}
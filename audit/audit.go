@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
+)
+
+// Record 一条会话审计记录, 对应一条收到的消息, 追加写入 audit_file, 每行一条
+type Record struct {
+	Time time.Time `json:"time"`
+	// 会话 id, 私聊为用户 id, 群聊为群 id
+	ConversationID string `json:"conversation_id"`
+	// 会话名称, 私聊为用户昵称, 群聊为群昵称
+	Conversation string `json:"conversation,omitempty"`
+	UserID       string `json:"user_id,omitempty"`
+	User         string `json:"user,omitempty"`
+	IsGroup      bool   `json:"is_group,omitempty"`
+	// 消息类型, 如 text, picture
+	MessageType string `json:"message_type"`
+	// 收到的消息原文
+	Message string `json:"message,omitempty"`
+	// 是否满足触发条件需要回复
+	Triggered bool `json:"triggered"`
+
+	// 以下字段在请求模型后填充
+	Profile     string  `json:"profile,omitempty"`
+	Persona     string  `json:"persona,omitempty"`
+	Model       string  `json:"model,omitempty"`
+	Temperature float64 `json:"temperature,omitempty"`
+	MaxTokens   uint    `json:"max_tokens,omitempty"`
+	// 系统提示词
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// 发送给模型的用户部分 prompt, 包含上下文, 不含系统提示词, 回放时与新的系统提示词拼接
+	Prompt string `json:"prompt,omitempty"`
+	// 模型返回的原始文本
+	Response         string `json:"response,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+	// 模型请求耗时, 单位毫秒
+	LatencyMs int64 `json:"latency_ms,omitempty"`
+	// 实际发送给用户的回复
+	Reply string `json:"reply,omitempty"`
+	// 处理过程中的错误
+	Error string `json:"error,omitempty"`
+	// privacy_level 为 strict 时消息正文, prompt, 模型回复和回复只记录长度, 这样的记录不能回放
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// SetSettings 记录会话生效的模型参数
+func (r *Record) SetSettings(settings config.Settings) {
+	r.Profile = settings.Profile
+	r.Persona = settings.Persona
+	r.Model = settings.Model
+	r.Temperature = settings.Temperature
+	r.MaxTokens = settings.MaxTokens
+	r.SystemPrompt = settings.SystemPrompt
+}
+
+var fileLock sync.Mutex
+
+// Write 追加一条审计记录, err 不为空时记录错误. 未配置 audit_file 时不记录.
+// 用户昵称和 id 按 privacy_level 脱敏. 消息正文在 strict 级别下只记录长度, 其他级别保留原文以便回放
+func Write(record *Record, err error) {
+	path := config.LoadConfig().AuditFile
+	if path == "" || record == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	redacted := *record
+	redacted.UserID = redact.Identity(record.UserID)
+	redacted.User = redact.Identity(record.User)
+	if !record.IsGroup {
+		redacted.ConversationID = redact.Identity(record.ConversationID)
+		redacted.Conversation = redact.Identity(record.Conversation)
+	}
+	if redact.Level() == redact.LevelStrict {
+		redacted.Message = redact.Body(record.Message)
+		redacted.Prompt = redact.Body(record.Prompt)
+		redacted.Response = redact.Body(record.Response)
+		redacted.Reply = redact.Body(record.Reply)
+		redacted.Error = redact.Text(record.Error)
+		redacted.Redacted = true
+	}
+
+	line, marshalErr := json.Marshal(redacted)
+	if marshalErr != nil {
+		logger.Warning(fmt.Sprintf("marshal audit record error: %v", marshalErr))
+		return
+	}
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	f, openErr := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if openErr != nil {
+		logger.Warning(fmt.Sprintf("open audit file error: %v", openErr))
+		return
+	}
+	defer f.Close()
+	if _, writeErr := f.Write(append(line, '\n')); writeErr != nil {
+		logger.Warning(fmt.Sprintf("write audit record error: %v", writeErr))
+	}
+}
+
+// Load 读取审计文件中的全部记录, 无法解析的行会被跳过
+func Load(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			logger.Warning(fmt.Sprintf("skip malformed audit record at %s:%d: %v", path, lineNumber, err))
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
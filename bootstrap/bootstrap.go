@@ -3,110 +3,137 @@ package bootstrap
 import (
 	"context"
 	"fmt"
+	"github.com/coolseven/wechatbot-chatgpt/admin"
+	"github.com/coolseven/wechatbot-chatgpt/alert"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/channel/api"
+	"github.com/coolseven/wechatbot-chatgpt/channel/dingtalk"
+	"github.com/coolseven/wechatbot-chatgpt/channel/feishu"
+	"github.com/coolseven/wechatbot-chatgpt/channel/telegram"
+	"github.com/coolseven/wechatbot-chatgpt/channel/wecom"
 	"github.com/coolseven/wechatbot-chatgpt/config"
 	"github.com/coolseven/wechatbot-chatgpt/handlers"
+	"github.com/coolseven/wechatbot-chatgpt/health"
+	"github.com/coolseven/wechatbot-chatgpt/login"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
-	"github.com/coolseven/wechatbot-chatgpt/pkg/wechat_notify_http_client"
-	"github.com/eatmoreapple/openwechat"
-	"io"
-	"time"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/metrics"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 )
 
 func Run() {
-	//bot := openwechat.DefaultBot()
-	bot := openwechat.DefaultBot(openwechat.Desktop) // 桌面模式，上面登录不上的可以尝试切换这种模式
+	// 按配置初始化日志, 之后的日志按级别过滤, 按隐私级别脱敏, 并写入日志文件
+	if err := config.LoadConfig().ConfigureLogging(); err != nil {
+		logger.Fatal(fmt.Sprintf("init logger error: %v", err))
+	}
 
-	// 注册消息处理函数
+	// 注册消息处理函数, 各渠道共用
 	handler, err := handlers.NewHandler()
 	if err != nil {
-		logger.Danger("register error: %v", err)
-		return
+		logger.Fatal(fmt.Sprintf("register error: %v", err))
 	}
-	bot.MessageHandler = handler
 
-	// 注册登陆二维码回调
-	bot.UUIDCallback = handlers.QrCodeCallBack
-
-	// 创建热存储容器对象
-	reloadStorage := openwechat.NewFileHotReloadStorage("storage.json")
-	defer func(reloadStorage io.ReadWriteCloser) {
-		_ = reloadStorage.Close()
-	}(reloadStorage)
+	// 注册内置 http 服务的页面和接口, 包括通过 http 接收消息的渠道
+	health.Register()
+	server.Handle("/metrics", metrics.Handler())
+	admin.Register()
+	api.Register(handler)
+	if err = wecom.Register(handler); err != nil {
+		logger.Fatal(fmt.Sprintf("register wecom app channel error: %v", err))
+	}
+	dingtalk.Register(handler)
+	feishu.Register(handler)
+	if err = telegram.Register(handler); err != nil {
+		logger.Fatal(fmt.Sprintf("register telegram channel error: %v", err))
+	}
 
-	// 设置设备id
-	bot.SetDeviceId(config.LoadConfig().DeviceId)
+	if !config.LoadConfig().WechatEnabled {
+		server.Start(config.LoadConfig().HttpAddr)
+		runWithoutWechat()
+		return
+	}
+	runWechat(handler)
+}
 
-	// 登录模式的区别: https://openwechat.readthedocs.io/zh/latest/bot.html
+// runWithoutWechat 不登录个人微信, 只运行通过 http 接收消息的渠道, 直到收到退出信号
+func runWithoutWechat() {
+	usage.StartScheduler()
+	sendAlert(alert.EventStarted, alert.Data{})
+	logger.Info("service started without wechat...")
 
-	// 执行热登录
-	//err = bot.HotLogin(reloadStorage)
-	//if err != nil {
-	//	logger.Warning(fmt.Sprintf("login error: %v ", err))
-	//	return
-	//}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	sendAlert(alert.EventDead, alert.Data{})
+}
 
-	logger.Info("login in...")
+// runWechat 登录全部个人微信账号并阻塞, 直到全部账号退出或进程退出. 各账号独立运行, 单个账号登录失败或 panic 不影响其他账号
+func runWechat(handler channel.Handler) {
+	accounts := config.LoadConfig().Accounts()
 
-	// 执行免扫码登录
-	err = bot.PushLogin(reloadStorage, openwechat.NewRetryLoginOption())
-	if err != nil {
-		logger.Warning(fmt.Sprintf("login error: %v ", err))
-		return
+	// 登陆二维码会打印到控制台, 发送到告警渠道, 并在二维码页面展示.
+	// 配置了 admin_token 时页面在管理后台中, 否则为带随机 token 的 /qrcode, 需要扫码时在控制台打印地址
+	for _, account := range accounts {
+		login.For(account.Name)
 	}
-
-	// 定时检测 bot 的在线状态, 当离线时, 通过企业微信进行告警
-	startedAt := time.Now()
-	go func() {
-		notified := false
-		wechatWorkClient := wechat_notify_http_client.NewWechatNotifyHttpClient(config.LoadConfig().WechatWorkSendKey)
-		for {
-			if notified {
-				return
-			}
-			time.Sleep(time.Second * 30)
-			lifeSpanInHours := time.Now().Sub(startedAt).Hours()
-			if bot.Alive() {
-				logger.Info(fmt.Sprintf("service has been alive for %f hours", lifeSpanInHours))
-				continue
-			} else {
-				err := wechatWorkClient.SendNotifyAsPlainText(context.Background(), fmt.Sprintf("coolseven@aliyun, wechat-gpt is dead after %f hours", lifeSpanInHours))
-				if err != nil {
-					logger.Info(fmt.Sprintf("调用企业微信告警失败: %s", err.Error()))
-				}
-				notified = true
-				return
-			}
-		}
-	}()
+	if cfg := config.LoadConfig(); cfg.HttpAddr != "" && cfg.AdminToken == "" {
+		server.HandleFunc("/qrcode", login.TokenProtected(login.QrCodePage))
+		server.HandleFunc("/qrcode.png", login.TokenProtected(login.QrCodeImage))
+	}
+	server.Start(config.LoadConfig().HttpAddr)
 
 	defer func() {
-		wechatWorkClient := wechat_notify_http_client.NewWechatNotifyHttpClient(config.LoadConfig().WechatWorkSendKey)
-		lifeSpanInHours := time.Now().Sub(startedAt).Hours()
 		if panicErr := recover(); panicErr != nil {
-			_ = wechatWorkClient.SendNotifyAsPlainText(context.Background(), fmt.Sprintf("coolseven@aliyun, wechat-gpt has panicErr after %f hours", lifeSpanInHours))
-			logger.Danger(fmt.Sprintf("service panic: %v", panicErr))
+			sendAlert(alert.EventPanic, alert.Data{LastError: fmt.Sprintf("%v", panicErr)})
+			sendAlert(alert.EventDead, alert.Data{})
+			logger.Fatal(fmt.Sprintf("service panic: %v", panicErr))
 		}
 
-		err := wechatWorkClient.SendNotifyAsPlainText(context.Background(), fmt.Sprintf("coolseven@aliyun, wechat-gpt is dead after %f hours", lifeSpanInHours))
-		if err != nil {
-			logger.Info(fmt.Sprintf("调用企业微信告警失败: %s , %s", err.Error(), fmt.Sprintf("coolseven@aliyun, wechat-gpt is dead after %f minutes", lifeSpanInHours)))
-		}
+		sendAlert(alert.EventDead, alert.Data{})
 	}()
 
-	// 服务启动成功通知
-	wechatWorkClient := wechat_notify_http_client.NewWechatNotifyHttpClient(config.LoadConfig().WechatWorkSendKey)
-	err = wechatWorkClient.SendNotifyAsPlainText(context.Background(), "coolseven@aliyun, wechat-gpt has started!")
-	if err != nil {
-		logger.Info(fmt.Sprintf("调用企业微信告警失败: %s, %s", err.Error(), "coolseven@aliyun, wechat-gpt has started!"))
+	// 定时发送用量报告
+	usage.StartScheduler()
+
+	var wg sync.WaitGroup
+	for _, account := range accounts {
+		wg.Add(1)
+		go func(account config.WechatAccount) {
+			defer wg.Done()
+			runAccount(account, handler)
+		}(account)
 	}
+	wg.Wait()
+}
 
-	// 阻塞主goroutine, 直到发生异常或者用户主动退出
-	logger.Info("service started...")
-	bot.Block()
+// runAccount 登录一个个人微信账号并阻塞, 掉线后自动重新登录. panic 时只告警, 不影响其他账号
+func runAccount(account config.WechatAccount, handler channel.Handler) {
+	log := logger.WithFields(logger.Fields{})
+	if account.Name != "" {
+		log = logger.WithFields(logger.Fields{"account": account.Name})
+	}
+	state := login.For(account.Name)
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			stats.SetLastError(fmt.Errorf("%v", panicErr))
+			sendAlert(alert.EventPanic, alert.Data{Account: account.Name, LastError: fmt.Sprintf("%v", panicErr)})
+			log.Error(fmt.Sprintf("wechat account panic: %v", panicErr))
+		}
+	}()
 
-	lifeSpanInHours := time.Now().Sub(startedAt).Hours()
-	err = wechatWorkClient.SendNotifyAsPlainText(context.Background(), fmt.Sprintf("coolseven@aliyun, wechat-gpt is dead after %f hours", lifeSpanInHours))
-	if err != nil {
-		logger.Info(fmt.Sprintf("调用企业微信告警失败: %s, %s", err.Error(), fmt.Sprintf("coolseven@aliyun, wechat-gpt is dead after %f hours", lifeSpanInHours)))
+	// 每个账号使用单独的存储文件, 按配置的登录方式和客户端模式登录
+	s := &supervisor{account: account, handler: handler, state: state, log: log}
+	s.run()
+}
+
+// sendAlert 发送告警, 失败时记录日志
+func sendAlert(event string, data alert.Data) {
+	if err := alert.Send(context.Background(), event, data); err != nil {
+		logger.Info(fmt.Sprintf("调用告警失败: %s, event: %s", err.Error(), event))
 	}
 }
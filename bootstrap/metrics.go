@@ -0,0 +1,10 @@
+package bootstrap
+
+import "github.com/coolseven/wechatbot-chatgpt/pkg/metrics"
+
+var (
+	wechatReconnectsTotal = metrics.NewCounter("wechatbot_wechat_reconnects_total",
+		"WeChat re-logins that succeeded after the account went offline.", "account")
+	wechatDowntimeSeconds = metrics.NewCounter("wechatbot_wechat_downtime_seconds_total",
+		"Seconds WeChat accounts spent offline before re-login succeeded.", "account")
+)
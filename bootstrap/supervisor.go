@@ -0,0 +1,260 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/alert"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/channel/wechat"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/login"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/eatmoreapple/openwechat"
+)
+
+const (
+	// 掉线后重新登录的退避时长, 每次失败后翻倍
+	reloginMinBackoff = 10 * time.Second
+	reloginMaxBackoff = 5 * time.Minute
+	// 不扫码重新登录连续失败该次数后, 按完整的登录方式登录, 包括扫码登录
+	reloginAttempts = 5
+	// 包括扫码登录的重新登录连续失败该次数后不再重试, 避免无人值守时不停地发送二维码
+	reloginScanAttempts = 3
+	// 在线时打印存活日志的间隔
+	aliveLogInterval = 30 * time.Second
+)
+
+// supervisor 守护一个个人微信账号的登录. 掉线后按退避时长自动重新登录, 每次登录都使用新的 bot, 并恢复消息处理等回调
+type supervisor struct {
+	account config.WechatAccount
+	handler channel.Handler
+	state   *login.State
+	log     *logger.Entry
+	// 当前使用的热存储容器, 每次登录重新打开
+	storage io.ReadWriteCloser
+}
+
+// errScanLogin 扫码登录不读取保存的登录信息
+var errScanLogin = errors.New("qr login does not read saved login info")
+
+// scanStorage 扫码登录使用的存储容器, 读取时总是失败, 只写入登录成功后的登录信息
+type scanStorage struct {
+	io.ReadWriteCloser
+}
+
+func (scanStorage) Read([]byte) (int, error) {
+	return 0, errScanLogin
+}
+
+// newBot 按客户端模式创建 bot, 注册消息处理、心跳和登录二维码回调
+func (s *supervisor) newBot() *openwechat.Bot {
+	mode := openwechat.Desktop // 桌面模式，网页版登录不上的可以尝试切换这种模式
+	if s.account.Mode == config.WechatModeNormal {
+		mode = openwechat.Normal
+	}
+	bot := openwechat.DefaultBot(mode)
+	bot.MessageHandler = wechat.New(bot, s.account.Name, s.account.Profile).MessageHandler(s.handler)
+
+	// 注册心跳回调, 记录最近一次成功同步的时间, 供 /healthz 判断连接是否卡死
+	printSyncCheck := bot.SyncCheckCallback
+	bot.SyncCheckCallback = func(resp openwechat.SyncCheckResponse) {
+		if resp.Success() {
+			s.state.MarkSynced()
+			stats.MarkSynced()
+		}
+		if printSyncCheck != nil {
+			printSyncCheck(resp)
+		}
+	}
+
+	// 注册登陆二维码回调
+	bot.UUIDCallback = s.state.QrCodeCallBack
+
+	// 设置设备id
+	bot.SetDeviceId(s.account.DeviceId)
+	return bot
+}
+
+// openStorage 重新打开存储文件. 存储容器读写后文件偏移量停在末尾, 每次登录都要重新打开才能读到保存的登录信息
+func (s *supervisor) openStorage() {
+	s.closeStorage()
+	s.storage = openwechat.NewFileHotReloadStorage(s.account.StorageFile)
+}
+
+func (s *supervisor) closeStorage() {
+	if s.storage != nil {
+		_ = s.storage.Close()
+	}
+}
+
+// loginWith 用 method 指定的方式登录一次
+func (s *supervisor) loginWith(bot *openwechat.Bot, method string) error {
+	switch method {
+	case config.LoginHot:
+		return bot.HotLogin(s.storage)
+	case config.LoginPush:
+		return bot.PushLogin(s.storage)
+	default:
+		// openwechat 的扫码登录不保存登录信息, 这里借用热登录: 读取登录信息失败后转为扫码登录, 登录成功后照常定时保存
+		return bot.HotLogin(scanStorage{s.storage}, openwechat.NewRetryLoginOption())
+	}
+}
+
+// login 按 methods 的顺序尝试登录, 前一种失败时使用下一种, 全部失败时返回最后一个错误
+func (s *supervisor) login(methods []string) (*openwechat.Bot, error) {
+	var err error
+	for i, method := range methods {
+		s.openStorage()
+		bot := s.newBot()
+		s.log.Info(fmt.Sprintf("trying %s login", method))
+		if err = s.loginWith(bot, method); err == nil {
+			s.log.Info(fmt.Sprintf("%s login succeeded", method))
+			s.online(bot)
+			return bot, nil
+		}
+		bot.Exit()
+		if i < len(methods)-1 {
+			s.log.Warning(fmt.Sprintf("%s login error: %v, falling back to %s login", method, err, methods[i+1]))
+		} else {
+			s.log.Warning(fmt.Sprintf("%s login error: %v", method, err))
+		}
+	}
+	return nil, err
+}
+
+// relogin 掉线后重新登录.
+// 1. 按登录方式的顺序重新登录, 但跳过扫码登录, 失败后按退避时长重试
+// 2. 连续失败 reloginAttempts 次后按完整的登录方式登录, 扫码登录的二维码通过告警渠道(如企业微信群机器人)发送
+// 3. 包括扫码登录的重新登录又失败 reloginScanAttempts 次后放弃, 返回最后一个错误
+func (s *supervisor) relogin() (*openwechat.Bot, error) {
+	var withoutScan []string
+	for _, method := range s.account.LoginStrategy {
+		if method != config.LoginQrCode {
+			withoutScan = append(withoutScan, method)
+		}
+	}
+
+	backoff := reloginMinBackoff
+	scanAttempts := 0
+	for attempt := 1; ; attempt++ {
+		s.log.Info(fmt.Sprintf("relogin attempt %d in %s", attempt, backoff))
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > reloginMaxBackoff {
+			backoff = reloginMaxBackoff
+		}
+
+		methods := withoutScan
+		if attempt > reloginAttempts || len(withoutScan) == 0 {
+			methods = s.account.LoginStrategy
+		}
+		if attempt == reloginAttempts+1 && len(withoutScan) > 0 && len(methods) > len(withoutScan) {
+			s.log.Warning(fmt.Sprintf("relogin without scanning failed %d times, falling back to qr login", reloginAttempts))
+		}
+		if len(methods) > len(withoutScan) {
+			scanAttempts++
+		}
+		bot, err := s.login(methods)
+		if err == nil {
+			return bot, nil
+		}
+		stats.SetLastError(err)
+		if scanAttempts >= reloginScanAttempts {
+			return nil, fmt.Errorf("relogin failed %d times including qr login: %v", attempt, err)
+		}
+	}
+}
+
+// loggedOut 是否为用户在手机上退出或在其他设备登录导致的掉线, 这种情况下重新登录没有意义
+func loggedOut(reason error) bool {
+	var ret openwechat.Ret
+	// 1100, 1101 为同步检查返回的已退出登录
+	return errors.As(reason, &ret) && (ret == 1100 || ret == 1101)
+}
+
+// stop 放弃自动重新登录, 通过告警渠道告警
+func (s *supervisor) stop(reason error) {
+	s.state.SetStopped()
+	stats.SetLastError(reason)
+	s.log.Warning(fmt.Sprintf("stop trying to relogin: %v", reason))
+	sendAlert(alert.EventReloginStopped, alert.Data{Account: s.account.Name, LastError: reason.Error()})
+}
+
+// online 登录成功后记录在线状态
+func (s *supervisor) online(bot *openwechat.Bot) {
+	nickName := ""
+	if self, err := bot.GetCurrentUser(); err == nil {
+		nickName = self.NickName
+	}
+	s.state.SetOnline(bot.Alive, nickName)
+	stats.MarkSynced()
+}
+
+// run 登录并守护账号, 首次登录失败, 在手机上退出登录或重新登录失败时返回
+func (s *supervisor) run() {
+	defer s.closeStorage()
+	s.log.Info(fmt.Sprintf("login in... strategy: %s, mode: %s, storage file: %s",
+		strings.Join(s.account.LoginStrategy, " -> "), s.account.Mode, s.account.StorageFile))
+	bot, err := s.login(s.account.LoginStrategy)
+	if err != nil {
+		stats.SetLastError(err)
+		s.log.Warning(fmt.Sprintf("login error: %v ", err))
+		return
+	}
+
+	// 服务启动成功通知
+	sendAlert(alert.EventStarted, alert.Data{Account: s.account.Name})
+	s.log.Info("service started...")
+
+	for {
+		// 阻塞, 直到发生异常或者用户主动退出
+		s.block(bot)
+
+		// 掉线后通过告警渠道告警, 然后自动重新登录
+		offlineAt := time.Now()
+		reason := bot.CrashReason()
+		if loggedOut(reason) {
+			s.stop(fmt.Errorf("logged out on the phone or another device: %v", reason))
+			return
+		}
+		if reason != nil {
+			stats.SetLastError(reason)
+		}
+		s.state.SetOffline()
+		s.log.Warning(fmt.Sprintf("wechat is offline: %v, trying to relogin", reason))
+		sendAlert(alert.EventLoggedOut, alert.Data{Account: s.account.Name})
+
+		if bot, err = s.relogin(); err != nil {
+			s.stop(err)
+			return
+		}
+		downtime := time.Since(offlineAt)
+		reconnects, _ := s.state.Reconnects()
+		wechatReconnectsTotal.Inc(s.account.Name)
+		wechatDowntimeSeconds.Add(downtime.Seconds(), s.account.Name)
+		s.log.Info(fmt.Sprintf("wechat is back online after %s offline, reconnected %d times", alert.HumanDuration(downtime), reconnects))
+		sendAlert(alert.EventReconnected, alert.Data{
+			Account:    s.account.Name,
+			Downtime:   alert.HumanDuration(downtime),
+			Reconnects: reconnects,
+		})
+	}
+}
+
+// block 阻塞直到 bot 掉线, 在线期间定时打印存活日志
+func (s *supervisor) block(bot *openwechat.Bot) {
+	ticker := time.NewTicker(aliveLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bot.Context().Done():
+			return
+		case <-ticker.C:
+			s.log.Info(fmt.Sprintf("service has been alive for %s", alert.HumanDuration(stats.Uptime())))
+		}
+	}
+}
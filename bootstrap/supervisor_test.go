@@ -0,0 +1,31 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/eatmoreapple/openwechat"
+)
+
+func TestLoggedOut(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason error
+		want   bool
+	}{
+		{name: "nil", reason: nil},
+		{name: "logged out on the phone", reason: openwechat.Ret(1100), want: true},
+		{name: "logged in elsewhere", reason: openwechat.Ret(1101), want: true},
+		{name: "wrapped", reason: fmt.Errorf("sync check: %w", openwechat.Ret(1101)), want: true},
+		{name: "cookie invalid", reason: openwechat.Ret(1102)},
+		{name: "network", reason: errors.New("read tcp: connection reset by peer")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loggedOut(tt.reason); got != tt.want {
+				t.Errorf("loggedOut(%v) = %v, want %v", tt.reason, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+// Package api http 对话接口, 其他服务通过 http 调用与微信用户相同的处理流程(上下文, 人设, 口令, profile).
+// 提供兼容 openai 的 /v1/chat/completions 和携带会话 id 的 /api/chat
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+)
+
+// Name 渠道名称
+const Name = "api"
+
+// idPrefix 接口的会话和用户 id 的前缀, 避免与其他渠道的会话共用上下文
+const idPrefix = "api:"
+
+// maxBodySize 请求体的最大字节数
+const maxBodySize = 1 << 20
+
+var _ channel.Channel = (*Channel)(nil)
+
+var _ channel.CompletionRecorder = (*Channel)(nil)
+
+// Channel http 对话渠道, 每个请求的回复收集到请求自己的 replies 中, 处理完成后一起返回
+type Channel struct {
+	handler channel.Handler
+}
+
+// reply 一条回复
+type reply struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	// 图片的 base64 编码
+	Image string `json:"image,omitempty"`
+}
+
+// replies 一个请求收集到的回复以及请求模型实际使用的模型和 token 用量, 存放在 Message.Raw 中
+type replies struct {
+	items            []reply
+	model            string
+	promptTokens     int
+	completionTokens int
+}
+
+// Register 在内置 http 服务上注册对话接口, 未配置 api_tokens 时不注册
+func Register(handler channel.Handler) {
+	if len(config.LoadConfig().ApiTokens) == 0 {
+		logger.Info("chat api disabled, set api_tokens to enable it")
+		return
+	}
+	a := &Channel{handler: handler}
+	server.HandleFunc("/v1/chat/completions", a.authorized(a.chatCompletions))
+	server.HandleFunc("/api/chat", a.authorized(a.chat))
+}
+
+// Name 渠道名称
+func (a *Channel) Name() string {
+	return Name
+}
+
+// SelfName 接口没有机器人昵称
+func (a *Channel) SelfName() string {
+	return ""
+}
+
+// ReplyText 收集文本回复
+func (a *Channel) ReplyText(msg *channel.Message, text string) error {
+	collected, err := collectorOf(msg)
+	if err != nil {
+		return err
+	}
+	collected.items = append(collected.items, reply{Type: "text", Text: text})
+	return nil
+}
+
+// ReplyImage 收集图片回复
+func (a *Channel) ReplyImage(msg *channel.Message, image io.Reader) error {
+	collected, err := collectorOf(msg)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(image)
+	if err != nil {
+		return err
+	}
+	collected.items = append(collected.items, reply{Type: "image", Image: base64.StdEncoding.EncodeToString(data)})
+	return nil
+}
+
+// AcceptFriend 接口没有好友申请
+func (a *Channel) AcceptFriend(msg *channel.Message) error {
+	return fmt.Errorf("%s channel has no friend requests", Name)
+}
+
+// RecordCompletion 记录请求模型实际使用的模型和 token 用量
+func (a *Channel) RecordCompletion(msg *channel.Message, model string, promptTokens, completionTokens int) {
+	collected, err := collectorOf(msg)
+	if err != nil {
+		return
+	}
+	collected.model = model
+	collected.promptTokens += promptTokens
+	collected.completionTokens += completionTokens
+}
+
+func collectorOf(msg *channel.Message) (*replies, error) {
+	collected, ok := msg.Raw.(*replies)
+	if !ok {
+		return nil, fmt.Errorf("not a %s message", Name)
+	}
+	return collected, nil
+}
+
+// authorized 校验 Authorization: Bearer 请求头中的 token, 通过后把 token 交给 next 作为默认会话
+func (a *Channel) authorized(next func(w http.ResponseWriter, r *http.Request, token string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		}
+		if !validToken(token) {
+			writeError(w, http.StatusUnauthorized, "invalid_api_key", "invalid api token")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "only POST is supported")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		next(w, r, token)
+	}
+}
+
+func validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	valid := false
+	for _, expected := range config.LoadConfig().ApiTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// tokenConversation 请求未指定会话时, 按 token 区分会话, 同一个 token 的调用方共用上下文
+func tokenConversation(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// scopedID 调用方传入的会话和用户 id 加上 token 的前缀, 不同 token 的调用方不能访问彼此的上下文, 也不能冒充其他渠道的用户.
+// 管理员和 binding 中需要写 "api:token-xxxxxxxx:id" 的完整形式
+func scopedID(token, id string) string {
+	return idPrefix + tokenConversation(token) + ":" + id
+}
+
+// dispatch 把请求转换为消息交给处理器, 返回收集到的回复. group 不为空时为群消息, 视为@机器人
+func (a *Channel) dispatch(token, conversationID, userID, user, group, content string) *replies {
+	collected := &replies{}
+	msg := &channel.Message{
+		Channel:      a,
+		ID:           fmt.Sprintf("%d", time.Now().UnixNano()),
+		Type:         channel.TypeText,
+		Content:      content,
+		Time:         time.Now(),
+		Sender:       channel.Sender{ID: scopedID(token, userID), NickName: user},
+		Conversation: channel.Conversation{ID: scopedID(token, conversationID), Name: user},
+		Raw:          collected,
+	}
+	if group != "" {
+		msg.Conversation = channel.Conversation{ID: scopedID(token, conversationID), Name: group, IsGroup: true}
+		msg.IsAt = true
+	}
+	a.handler(msg)
+	return collected
+}
+
+// chatRequest /api/chat 的请求
+type chatRequest struct {
+	// 会话 id, 同一会话共用上下文, 为空时按 token 区分
+	ConversationID string `json:"conversation_id"`
+	// 发送者 id, 为空时与会话 id 相同
+	UserID string `json:"user_id"`
+	// 发送者昵称, 用于显示和按昵称匹配 profile, 不用于识别管理员, 为空时与发送者 id 相同
+	User string `json:"user"`
+	// 群名称, 不为空时按群消息处理
+	Group   string `json:"group"`
+	Message string `json:"message"`
+}
+
+// chatResponse /api/chat 的响应
+type chatResponse struct {
+	ConversationID string  `json:"conversation_id"`
+	Replies        []reply `json:"replies"`
+}
+
+// chat 携带会话 id 的原生接口, 返回全部回复, 包括图片
+func (a *Channel) chat(w http.ResponseWriter, r *http.Request, token string) {
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "message is required")
+		return
+	}
+	if req.ConversationID == "" {
+		req.ConversationID = tokenConversation(token)
+	}
+	if req.UserID == "" {
+		req.UserID = req.ConversationID
+	}
+	if req.User == "" {
+		req.User = req.UserID
+	}
+
+	items := a.dispatch(token, req.ConversationID, req.UserID, req.User, req.Group, req.Message).items
+	if items == nil {
+		items = []reply{}
+	}
+	writeJSON(w, http.StatusOK, chatResponse{ConversationID: req.ConversationID, Replies: items})
+}
+
+// chatCompletionRequest 兼容 openai 的请求, 只使用最后一条 user 消息, 上下文由机器人按 user 维护
+type chatCompletionRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	// 用作会话 id, 为空时按 token 区分
+	User string `json:"user"`
+}
+
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text 消息文本, content 可以是字符串或 [{"type":"text","text":"..."}] 数组
+func (m chatMessage) text() string {
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// chatCompletionResponse 兼容 openai 的响应
+type chatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []chatCompletionChoice `json:"choices"`
+	Usage   chatCompletionUsage    `json:"usage"`
+}
+
+type chatCompletionChoice struct {
+	Index        int                   `json:"index"`
+	Message      chatCompletionMessage `json:"message"`
+	FinishReason string                `json:"finish_reason"`
+}
+
+type chatCompletionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatCompletionUsage 本次请求模型的 token 用量
+type chatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// chatCompletions 兼容 openai 的接口, 模型和系统提示词由机器人的 profile 和人设决定, 图片以 markdown 返回
+func (a *Channel) chatCompletions(w http.ResponseWriter, r *http.Request, token string) {
+	var req chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	if req.Stream {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "stream is not supported")
+		return
+	}
+	content := ""
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			content = req.Messages[i].text()
+			break
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "a user message is required")
+		return
+	}
+	conversationID := req.User
+	if conversationID == "" {
+		conversationID = tokenConversation(token)
+	}
+
+	collected := a.dispatch(token, conversationID, conversationID, conversationID, "", content)
+	var texts []string
+	for _, item := range collected.items {
+		if item.Type == "image" {
+			texts = append(texts, "![image](data:image/png;base64,"+item.Image+")")
+			continue
+		}
+		texts = append(texts, item.Text)
+	}
+	// 返回实际使用的模型, 口令等没有请求模型的回复返回全局配置的模型
+	model := collected.model
+	if model == "" {
+		model = config.LoadConfig().Model
+	}
+	writeJSON(w, http.StatusOK, chatCompletionResponse{
+		ID:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []chatCompletionChoice{{
+			Message:      chatCompletionMessage{Role: "assistant", Content: strings.Join(texts, "\n\n")},
+			FinishReason: "stop",
+		}},
+		Usage: chatCompletionUsage{
+			PromptTokens:     collected.promptTokens,
+			CompletionTokens: collected.completionTokens,
+			TotalTokens:      collected.promptTokens + collected.completionTokens,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeError 以 openai 的错误格式返回
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"type": errorType, "message": message},
+	})
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+)
+
+func TestMain(m *testing.M) {
+	config.SetArgs([]string{"--api-key", "sk-test"})
+	os.Exit(m.Run())
+}
+
+func TestChatScopesIDsByToken(t *testing.T) {
+	var got []*channel.Message
+	a := &Channel{}
+	a.handler = func(msg *channel.Message) {
+		got = append(got, msg)
+		_ = msg.ReplyText("ok")
+	}
+	for _, token := range []string{"token-a-0123456789", "token-b-0123456789"} {
+		r := httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"conversation_id": "c1", "user_id": "admin", "user": "admin", "message": "hi"}`))
+		a.chat(httptest.NewRecorder(), r, token)
+	}
+	if len(got) != 2 {
+		t.Fatalf("handled %d messages, want 2", len(got))
+	}
+	if got[0].Conversation.ID == got[1].Conversation.ID || got[0].Sender.ID == got[1].Sender.ID {
+		t.Errorf("different tokens share ids: %+v, %+v", got[0], got[1])
+	}
+	if want := idPrefix + tokenConversation("token-a-0123456789") + ":admin"; got[0].Sender.ID != want {
+		t.Errorf("sender id = %q, want %q", got[0].Sender.ID, want)
+	}
+}
+
+func TestChatCompletionsReturnsActualUsage(t *testing.T) {
+	a := &Channel{}
+	a.handler = func(msg *channel.Message) {
+		a.RecordCompletion(msg, "text-curie-001", 12, 34)
+		_ = msg.ReplyText("hello")
+	}
+	r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4", "messages": [{"role": "user", "content": "hi"}]}`))
+	w := httptest.NewRecorder()
+	a.chatCompletions(w, r, "token-a-0123456789")
+
+	var resp chatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %s: %v", w.Body.String(), err)
+	}
+	if resp.Model != "text-curie-001" {
+		t.Errorf("model = %q, want the model actually used", resp.Model)
+	}
+	if resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 34 || resp.Usage.TotalTokens != 46 {
+		t.Errorf("usage = %+v, want 12/34/46", resp.Usage)
+	}
+}
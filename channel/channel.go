@@ -0,0 +1,108 @@
+// Package channel 消息平台的抽象, 把各平台的消息转换为统一的 Message, 回复通过 Channel 发送,
+// 处理器只依赖本包, 不直接依赖 openwechat 等平台的 sdk
+package channel
+
+import (
+	"io"
+	"time"
+)
+
+// 消息类型
+const (
+	TypeText      = "text"
+	TypePicture   = "picture"
+	TypeEmoticon  = "emoticon"
+	TypeVoice     = "voice"
+	TypeVideo     = "video"
+	TypeLocation  = "location"
+	TypeCard      = "card"
+	TypeFriendAdd = "friend_add"
+	TypeRecalled  = "recalled"
+	TypeApp       = "app"
+	TypeSystem    = "system"
+	TypeOther     = "other"
+)
+
+// Channel 消息平台, 负责把回复发送回消息所在的会话
+type Channel interface {
+	// Name 平台名称, 如 wechat
+	Name() string
+	// SelfName 机器人在平台上的昵称, 用于去掉群消息中的 @机器人
+	SelfName() string
+	// ReplyText 回复文本消息
+	ReplyText(msg *Message, text string) error
+	// ReplyImage 回复图片消息
+	ReplyImage(msg *Message, image io.Reader) error
+	// AcceptFriend 通过好友申请, 平台不支持时返回错误
+	AcceptFriend(msg *Message) error
+}
+
+// Profiled 为账号指定了 profile 的平台, 会话没有匹配的 binding 时使用该 profile
+type Profiled interface {
+	// Profile profile 名称, 为空时不指定
+	Profile() string
+}
+
+// CompletionRecorder 需要知道回复实际使用的模型和 token 用量的平台, 每次请求模型后调用
+type CompletionRecorder interface {
+	RecordCompletion(msg *Message, model string, promptTokens, completionTokens int)
+}
+
+// Sender 消息的发送者
+type Sender struct {
+	ID       string
+	NickName string
+}
+
+// Conversation 消息所在的会话, 私聊时为发送者, 群聊时为群
+type Conversation struct {
+	ID      string
+	Name    string
+	IsGroup bool
+}
+
+// Message 统一的消息
+type Message struct {
+	// 收到消息的平台
+	Channel Channel
+	ID      string
+	// 消息类型, 如 TypeText
+	Type    string
+	Content string
+	Time    time.Time
+	Sender  Sender
+	// 消息所在的会话
+	Conversation Conversation
+	// 群消息中是否 @了机器人
+	IsAt bool
+	// 平台原始的消息, 如 *openwechat.Message, 只有平台适配器使用
+	Raw interface{}
+}
+
+// Handler 消息处理函数
+type Handler func(msg *Message)
+
+// IsText 是否为文本消息
+func (m *Message) IsText() bool {
+	return m.Type == TypeText
+}
+
+// IsGroup 是否为群消息
+func (m *Message) IsGroup() bool {
+	return m.Conversation.IsGroup
+}
+
+// ReplyText 通过消息所在的平台回复文本
+func (m *Message) ReplyText(text string) error {
+	return m.Channel.ReplyText(m, text)
+}
+
+// ReplyImage 通过消息所在的平台回复图片
+func (m *Message) ReplyImage(image io.Reader) error {
+	return m.Channel.ReplyImage(m, image)
+}
+
+// AcceptFriend 通过好友申请
+func (m *Message) AcceptFriend() error {
+	return m.Channel.AcceptFriend(m)
+}
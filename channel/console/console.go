@@ -0,0 +1,233 @@
+// Package console 终端渠道, 从标准输入读取消息, 回复输出到标准输出, 用于不登录微信时本地调试提示词和演示
+package console
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/handlers"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+)
+
+// Name 渠道名称
+const Name = "console"
+
+// help 终端中可用的命令
+const help = `commands:
+  :user <name>      switch the sender, e.g. :user bob
+  :group <name>     chat in a group, mention the bot with @<bot name>
+  :private          back to the private chat with the bot
+  :friend           send a friend request from the current sender
+  :help             show this help
+  :quit             exit
+anything else is sent as a text message`
+
+var _ channel.Channel = (*Channel)(nil)
+
+// Channel 终端渠道, 同一时间只有一个发送者和一个会话
+type Channel struct {
+	// 机器人的昵称, 群聊中 @该昵称 视为 @机器人
+	selfName string
+	// 生成的图片保存的目录
+	imageDir string
+	out      io.Writer
+
+	sender channel.Sender
+	// 当前所在的群, 为空时为私聊
+	group string
+	// 消息序号, 作为消息 id
+	seq int
+}
+
+// New 创建终端渠道, 回复写入 out
+func New(selfName, sender, imageDir string, out io.Writer) *Channel {
+	return &Channel{
+		selfName: selfName,
+		imageDir: imageDir,
+		out:      out,
+		sender:   channel.Sender{ID: sender, NickName: sender},
+	}
+}
+
+// Name 渠道名称
+func (t *Channel) Name() string {
+	return Name
+}
+
+// SelfName 机器人的昵称
+func (t *Channel) SelfName() string {
+	return t.selfName
+}
+
+// ReplyText 输出回复
+func (t *Channel) ReplyText(msg *channel.Message, text string) error {
+	_, err := fmt.Fprintf(t.out, "%s> %s\n", t.selfName, strings.ReplaceAll(text, "\n", "\n  "))
+	return err
+}
+
+// ReplyImage 把图片保存到 imageDir, 输出图片路径
+func (t *Channel) ReplyImage(msg *channel.Message, image io.Reader) error {
+	if err := os.MkdirAll(t.imageDir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(t.imageDir, time.Now().Format("20060102-150405")+"-*.png")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, image); err != nil {
+		return err
+	}
+	path, _ := filepath.Abs(f.Name())
+	_, err = fmt.Fprintf(t.out, "%s> [image saved to %s]\n", t.selfName, path)
+	return err
+}
+
+// AcceptFriend 输出通过好友申请
+func (t *Channel) AcceptFriend(msg *channel.Message) error {
+	_, err := fmt.Fprintf(t.out, "%s> [accepted friend request from %s]\n", t.selfName, msg.Sender.NickName)
+	return err
+}
+
+// Serve 逐行读取 in, 命令切换身份和会话, 其他内容作为文本消息交给 handler, 直到 in 结束或 :quit
+func (t *Channel) Serve(in io.Reader, handler channel.Handler) error {
+	scanner := bufio.NewScanner(in)
+	t.prompt()
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, ":"):
+			if quit := t.command(line, handler); quit {
+				return nil
+			}
+		default:
+			handler(t.message(channel.TypeText, line))
+		}
+		t.prompt()
+	}
+	return scanner.Err()
+}
+
+// command 执行终端命令, 返回是否退出
+func (t *Channel) command(line string, handler channel.Handler) bool {
+	fields := strings.Fields(line)
+	arg := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	switch fields[0] {
+	case ":quit", ":q", ":exit":
+		return true
+	case ":user":
+		if arg == "" {
+			fmt.Fprintln(t.out, "usage: :user <name>")
+			break
+		}
+		t.sender = channel.Sender{ID: arg, NickName: arg}
+	case ":group":
+		if arg == "" {
+			fmt.Fprintln(t.out, "usage: :group <name>")
+			break
+		}
+		t.group = arg
+	case ":private":
+		t.group = ""
+	case ":friend":
+		handler(t.message(channel.TypeFriendAdd, ""))
+	default:
+		fmt.Fprintln(t.out, help)
+	}
+	return false
+}
+
+// message 以当前发送者和会话构造消息, 群聊中包含 @机器人昵称 时视为 @机器人
+func (t *Channel) message(messageType, content string) *channel.Message {
+	t.seq++
+	msg := &channel.Message{
+		Channel:      t,
+		ID:           fmt.Sprintf("%d", t.seq),
+		Type:         messageType,
+		Content:      content,
+		Time:         time.Now(),
+		Sender:       t.sender,
+		Conversation: channel.Conversation{ID: t.sender.ID, Name: t.sender.NickName},
+	}
+	if t.group != "" {
+		msg.Conversation = channel.Conversation{ID: "group:" + t.group, Name: t.group, IsGroup: true}
+		msg.IsAt = strings.Contains(content, "@"+t.selfName)
+	}
+	return msg
+}
+
+func (t *Channel) prompt() {
+	if t.group != "" {
+		fmt.Fprintf(t.out, "[%s @ %s]> ", t.sender.NickName, t.group)
+		return
+	}
+	fmt.Fprintf(t.out, "[%s]> ", t.sender.NickName)
+}
+
+// Run 执行 console 子命令, 返回进程退出码.
+// args 中 -- 之前为 console 的参数, 之后为机器人本身的配置参数, 如 --config
+func Run(args []string) int {
+	consoleArgs, configArgs := config.SplitSubcommandArgs(args)
+	fs := flag.NewFlagSet("console", flag.ContinueOnError)
+	selfName := fs.String("name", "bot", "nickname of the bot, @<name> in a group mentions the bot")
+	sender := fs.String("user", "me", "nickname of the initial sender")
+	imageDir := fs.String("image-dir", "console-images", "directory generated images are saved to")
+	verbose := fs.Bool("verbose", false, "keep the configured log level instead of warnings only")
+	record := fs.Bool("record", false, "append usage and audit records to the configured usage_file and audit_file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: wechatbot console [flags] [-- config flags]")
+		fmt.Fprintln(fs.Output(), "Chat with the bot from the terminal without logging in to WeChat.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(consoleArgs); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	config.SetArgs(configArgs)
+	cfg := config.LoadConfig()
+	if !*record {
+		// 调试时的对话默认不计入用量和审计记录, 避免混入线上的统计
+		cfg.UsageFile = ""
+		cfg.AuditFile = ""
+	}
+	if err := cfg.ConfigureLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !*verbose {
+		// 回复输出到标准输出, 只保留警告以上的日志, 避免混在一起
+		logOptions := cfg.LogOptions()
+		if logOptions.Level < logger.LevelWarning {
+			logOptions.Level = logger.LevelWarning
+		}
+		if err := logger.Configure(logOptions); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	handler, err := handlers.NewHandler()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "register error: %v\n", err)
+		return 1
+	}
+	console := New(*selfName, *sender, *imageDir, os.Stdout)
+	fmt.Fprintln(os.Stdout, help)
+	if err := console.Serve(os.Stdin, handler); err != nil {
+		fmt.Fprintf(os.Stderr, "read stdin error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+)
+
+func TestServe(t *testing.T) {
+	input := strings.Join([]string{
+		"hello",
+		":user bob",
+		":group team",
+		"hi @bot",
+		"no mention",
+		":friend",
+		":private",
+		"back",
+		":quit",
+		"after quit",
+	}, "\n")
+
+	var out bytes.Buffer
+	c := New("bot", "me", t.TempDir(), &out)
+	var got []*channel.Message
+	if err := c.Serve(strings.NewReader(input), func(msg *channel.Message) { got = append(got, msg) }); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		typ          string
+		content      string
+		sender       string
+		conversation channel.Conversation
+		isAt         bool
+	}{
+		{typ: channel.TypeText, content: "hello", sender: "me", conversation: channel.Conversation{ID: "me", Name: "me"}},
+		{typ: channel.TypeText, content: "hi @bot", sender: "bob", conversation: channel.Conversation{ID: "group:team", Name: "team", IsGroup: true}, isAt: true},
+		{typ: channel.TypeText, content: "no mention", sender: "bob", conversation: channel.Conversation{ID: "group:team", Name: "team", IsGroup: true}},
+		{typ: channel.TypeFriendAdd, sender: "bob", conversation: channel.Conversation{ID: "group:team", Name: "team", IsGroup: true}},
+		{typ: channel.TypeText, content: "back", sender: "bob", conversation: channel.Conversation{ID: "bob", Name: "bob"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("handler got %d messages, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		msg := got[i]
+		if msg.Type != w.typ || msg.Content != w.content || msg.IsAt != w.isAt {
+			t.Errorf("message %d = type %q content %q isAt %v, want %q %q %v", i, msg.Type, msg.Content, msg.IsAt, w.typ, w.content, w.isAt)
+		}
+		if msg.Sender != (channel.Sender{ID: w.sender, NickName: w.sender}) {
+			t.Errorf("message %d sender = %+v, want %s", i, msg.Sender, w.sender)
+		}
+		if msg.Conversation != w.conversation {
+			t.Errorf("message %d conversation = %+v, want %+v", i, msg.Conversation, w.conversation)
+		}
+		if msg.Channel != c {
+			t.Errorf("message %d channel = %v, want console", i, msg.Channel)
+		}
+	}
+	if !strings.Contains(out.String(), "[bob @ team]> ") {
+		t.Errorf("output %q missing group prompt", out.String())
+	}
+}
@@ -0,0 +1,296 @@
+// Package dingtalk 钉钉企业内部机器人渠道, 通过 outgoing 回调接收单聊和群里@机器人的消息, 通过回调中的 sessionWebhook 回复
+package dingtalk
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/patrickmn/go-cache"
+)
+
+// Name 渠道名称
+const Name = "dingtalk"
+
+// CallbackPath 机器人消息接收地址
+const CallbackPath = "/dingtalk/callback"
+
+// imagePath 生成的图片的访问地址, 钉钉的 markdown 消息只能引用图片链接
+const imagePath = "/dingtalk/images/"
+
+// idPrefix 会话和用户 id 的前缀, 避免与其他渠道的会话共用上下文
+const idPrefix = "dingtalk:"
+
+// 回调时间戳与当前时间的最大误差
+const maxClockSkew = time.Hour
+
+// conversationGroup 群聊的会话类型, 单聊为 "1"
+const conversationGroup = "2"
+
+// sessionWebhookHosts sessionWebhook 允许的域名, 其他地址不发送请求, 避免伪造的回调让机器人请求内网地址
+var sessionWebhookHosts = map[string]bool{
+	"oapi.dingtalk.com": true,
+	"api.dingtalk.com":  true,
+}
+
+// httpClient 不跟随重定向, 只请求校验过的 sessionWebhook
+var httpClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+var _ channel.Channel = (*Channel)(nil)
+
+// Channel 钉钉机器人渠道. 收到回调后立即响应, 在后台处理, sessionWebhook 过期前都可以回复
+type Channel struct {
+	secret  string
+	handler channel.Handler
+	// 最近处理过的消息 id, 钉钉重试时不重复处理
+	seen *cache.Cache
+	// 生成的图片, 通过 imagePath 访问, 1 小时后过期
+	images *cache.Cache
+}
+
+// inboundMessage 回调的消息, see https://open.dingtalk.com/document/orgapp/receive-message
+type inboundMessage struct {
+	MsgID   string `json:"msgId"`
+	MsgType string `json:"msgtype"`
+	Text    struct {
+		Content string `json:"content"`
+	} `json:"text"`
+	CreateAt                  int64  `json:"createAt"`
+	ConversationType          string `json:"conversationType"`
+	ConversationID            string `json:"conversationId"`
+	ConversationTitle         string `json:"conversationTitle"`
+	SenderID                  string `json:"senderId"`
+	SenderNick                string `json:"senderNick"`
+	SenderStaffID             string `json:"senderStaffId"`
+	IsInAtList                bool   `json:"isInAtList"`
+	SessionWebhook            string `json:"sessionWebhook"`
+	SessionWebhookExpiredTime int64  `json:"sessionWebhookExpiredTime"`
+}
+
+// Register 在内置 http 服务上注册回调地址, 未配置 dingtalk_app_secret 时不注册
+func Register(handler channel.Handler) {
+	cfg := config.LoadConfig()
+	if cfg.DingtalkAppSecret == "" {
+		return
+	}
+	d := &Channel{
+		secret:  cfg.DingtalkAppSecret,
+		handler: handler,
+		seen:    cache.New(10*time.Minute, 10*time.Minute),
+		images:  cache.New(time.Hour, 10*time.Minute),
+	}
+	server.HandleFunc(CallbackPath, d.callback)
+	server.HandleFunc(imagePath, d.image)
+	logger.Info(fmt.Sprintf("dingtalk channel enabled, callback url: %s", CallbackPath))
+}
+
+// Name 渠道名称
+func (d *Channel) Name() string {
+	return Name
+}
+
+// SelfName 回调的消息内容已去掉@机器人
+func (d *Channel) SelfName() string {
+	return ""
+}
+
+// ReplyText 通过 sessionWebhook 回复文本
+func (d *Channel) ReplyText(msg *channel.Message, text string) error {
+	return d.reply(msg, map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": text},
+	})
+}
+
+// ReplyImage 钉钉机器人只能在 markdown 中引用图片链接, 图片暂存在内置 http 服务上, 需要配置 public_url
+func (d *Channel) ReplyImage(msg *channel.Message, image io.Reader) error {
+	publicURL := strings.TrimRight(config.LoadConfig().PublicURL, "/")
+	if publicURL == "" {
+		return errors.New("dingtalk image replies require public_url")
+	}
+	data, err := ioutil.ReadAll(image)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return err
+	}
+	name := hex.EncodeToString(id) + ".png"
+	d.images.SetDefault(name, data)
+	return d.reply(msg, map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": "图片",
+			"text":  fmt.Sprintf("![image](%s%s%s)", publicURL, imagePath, name),
+		},
+	})
+}
+
+// AcceptFriend 机器人没有好友申请
+func (d *Channel) AcceptFriend(msg *channel.Message) error {
+	return fmt.Errorf("%s channel has no friend requests", Name)
+}
+
+// reply 调用 sessionWebhook 发送消息
+func (d *Channel) reply(msg *channel.Message, body map[string]interface{}) error {
+	raw, ok := msg.Raw.(*inboundMessage)
+	if !ok {
+		return errors.New("not a dingtalk message")
+	}
+	if raw.SessionWebhookExpiredTime > 0 && time.Now().After(time.Unix(0, raw.SessionWebhookExpiredTime*int64(time.Millisecond))) {
+		return errors.New("dingtalk session webhook expired")
+	}
+	if err := validSessionWebhook(raw.SessionWebhook); err != nil {
+		return err
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, raw.SessionWebhook, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respModel := struct {
+		Errcode int    `json:"errcode"`
+		Errmsg  string `json:"errmsg"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&respModel); err != nil {
+		return fmt.Errorf("dingtalk-reply-err, statusCode:%v, error: %v", resp.StatusCode, err)
+	}
+	if respModel.Errcode != 0 {
+		return fmt.Errorf("dingtalk-reply-err, errcode:%v, errmsg:%v", respModel.Errcode, respModel.Errmsg)
+	}
+	return nil
+}
+
+// validSessionWebhook sessionWebhook 必须是钉钉域名下的 https 地址
+func validSessionWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return fmt.Errorf("invalid dingtalk session webhook: %v", err)
+	}
+	if u.Scheme != "https" || u.User != nil || (u.Port() != "" && u.Port() != "443") || !sessionWebhookHosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("dingtalk session webhook %q is not an https url on a dingtalk host", u.Host)
+	}
+	return nil
+}
+
+// verify 校验请求头中的签名: 以 AppSecret 为密钥对 "timestamp\nAppSecret" 做 HmacSHA256 后 base64, 时间戳与 now 的误差不能超过 maxClockSkew
+func (d *Channel) verify(timestamp, sign string, now time.Time) bool {
+	millis, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(0, millis*int64(time.Millisecond)))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write([]byte(timestamp + "\n" + d.secret))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(sign), []byte(expected)) == 1
+}
+
+// callback 接收机器人消息
+func (d *Channel) callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !d.verify(r.Header.Get("timestamp"), r.Header.Get("sign"), time.Now()) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	var inbound inboundMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&inbound); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	// 立即响应, 在后台处理消息
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+	if inbound.MsgID != "" && d.seen.Add(inbound.MsgID, struct{}{}, cache.DefaultExpiration) != nil {
+		return
+	}
+	go d.handle(&inbound)
+}
+
+// handle 把消息转换为 channel.Message 交给处理器
+func (d *Channel) handle(inbound *inboundMessage) {
+	defer func() {
+		if err := recover(); err != nil {
+			stats.IncError(stats.ErrorMessagePanic)
+			logger.Danger(fmt.Sprintf("handle dingtalk message panic: %v", err))
+		}
+	}()
+	senderID := inbound.SenderStaffID
+	if senderID == "" {
+		senderID = inbound.SenderID
+	}
+	msg := &channel.Message{
+		Channel: d,
+		ID:      inbound.MsgID,
+		Type:    channel.TypeOther,
+		Content: strings.TrimSpace(inbound.Text.Content),
+		Time:    time.Unix(0, inbound.CreateAt*int64(time.Millisecond)),
+		Sender:  channel.Sender{ID: idPrefix + senderID, NickName: inbound.SenderNick},
+		Raw:     inbound,
+	}
+	if inbound.MsgType == "text" {
+		msg.Type = channel.TypeText
+	}
+	msg.Conversation = channel.Conversation{ID: msg.Sender.ID, Name: msg.Sender.NickName}
+	if inbound.ConversationType == conversationGroup {
+		msg.Conversation = channel.Conversation{ID: idPrefix + inbound.ConversationID, Name: inbound.ConversationTitle, IsGroup: true}
+		msg.IsAt = inbound.IsInAtList
+	}
+	d.handler(msg)
+}
+
+// image 返回暂存的图片
+func (d *Channel) image(w http.ResponseWriter, r *http.Request) {
+	data, ok := d.images.Get(strings.TrimPrefix(r.URL.Path, imagePath))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write(data.([]byte))
+}
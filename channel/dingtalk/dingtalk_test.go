@@ -0,0 +1,61 @@
+package dingtalk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	d := &Channel{secret: "test-secret"}
+	// 签名由独立的 HmacSHA256 实现算出: base64(hmac_sha256("test-secret", "1700000000000\ntest-secret"))
+	const timestamp, sign = "1700000000000", "BYMqUCZnSqbfPf1GCfZftO7Rg2g6P+Rp3/4+bLNtSGA="
+	signedAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		sign      string
+		now       time.Time
+		want      bool
+	}{
+		{name: "known vector", timestamp: timestamp, sign: sign, now: signedAt, want: true},
+		{name: "clock behind within skew", timestamp: timestamp, sign: sign, now: signedAt.Add(-maxClockSkew + time.Minute), want: true},
+		{name: "clock ahead within skew", timestamp: timestamp, sign: sign, now: signedAt.Add(maxClockSkew - time.Minute), want: true},
+		{name: "too old", timestamp: timestamp, sign: sign, now: signedAt.Add(maxClockSkew + time.Minute)},
+		{name: "from the future", timestamp: timestamp, sign: sign, now: signedAt.Add(-maxClockSkew - time.Minute)},
+		{name: "tampered sign", timestamp: timestamp, sign: "CYMqUCZnSqbfPf1GCfZftO7Rg2g6P+Rp3/4+bLNtSGA=", now: signedAt},
+		{name: "tampered timestamp", timestamp: "1700000000001", sign: sign, now: signedAt},
+		{name: "invalid timestamp", timestamp: "now", sign: sign, now: signedAt},
+		{name: "empty sign", timestamp: timestamp, now: signedAt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.verify(tt.timestamp, tt.sign, tt.now); got != tt.want {
+				t.Errorf("verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidSessionWebhook(t *testing.T) {
+	tests := []struct {
+		webhook string
+		valid   bool
+	}{
+		{webhook: "https://oapi.dingtalk.com/robot/sendBySession?session=abc", valid: true},
+		{webhook: "https://api.dingtalk.com/v1.0/robot/sendBySession?session=abc", valid: true},
+		{webhook: "https://OAPI.dingtalk.com:443/robot/sendBySession", valid: true},
+		{webhook: "http://oapi.dingtalk.com/robot/sendBySession"},
+		{webhook: "https://oapi.dingtalk.com.evil.com/robot/sendBySession"},
+		{webhook: "https://evil.com/?host=oapi.dingtalk.com"},
+		{webhook: "https://oapi.dingtalk.com@169.254.169.254/latest/meta-data"},
+		{webhook: "https://oapi.dingtalk.com:8443/robot/sendBySession"},
+		{webhook: "https://127.0.0.1/robot/sendBySession"},
+		{webhook: ""},
+	}
+	for _, tt := range tests {
+		if err := validSessionWebhook(tt.webhook); (err == nil) != tt.valid {
+			t.Errorf("validSessionWebhook(%q) = %v, want valid %v", tt.webhook, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package feishu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+)
+
+// endpoint 飞书开放平台接口地址
+const endpoint = "https://open.feishu.cn"
+
+// tenantTokenRefreshBefore tenant_access_token 在过期前多久刷新
+const tenantTokenRefreshBefore = 5 * time.Minute
+
+// tenant_access_token 失效的错误码, 收到时刷新后重试一次
+var tenantTokenCodes = map[int]bool{99991661: true, 99991663: true}
+
+// client 飞书开放平台接口, 负责 tenant_access_token 的缓存和刷新
+type client struct {
+	http      *http.Client
+	appID     string
+	appSecret string
+
+	mu          sync.Mutex
+	tenantToken string
+	expiresAt   time.Time
+	// 机器人的 open_id, 用于判断群消息是否@了机器人
+	botOpenID string
+}
+
+func newClient(appID, appSecret string) *client {
+	return &client{
+		http:      &http.Client{Timeout: 30 * time.Second},
+		appID:     appID,
+		appSecret: appSecret,
+	}
+}
+
+// apiResponse 接口的公共响应
+type apiResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (r apiResponse) err() error {
+	if r.Code != 0 {
+		return fmt.Errorf("feishu-err, code:%d, msg:%v", r.Code, r.Msg)
+	}
+	return nil
+}
+
+// token 获取 tenant_access_token, 缓存到过期前 5 分钟, see https://open.feishu.cn/document/server-docs/authentication-management/access-token/tenant_access_token_internal
+func (c *client) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tenantToken != "" && time.Now().Before(c.expiresAt) {
+		return c.tenantToken, nil
+	}
+	respModel := struct {
+		apiResponse
+		TenantAccessToken string `json:"tenant_access_token"`
+		Expire            int    `json:"expire"`
+	}{}
+	body := map[string]string{"app_id": c.appID, "app_secret": c.appSecret}
+	if err := c.do(ctx, "/open-apis/auth/v3/tenant_access_token/internal", "", body, &respModel); err != nil {
+		return "", err
+	}
+	if err := respModel.err(); err != nil {
+		return "", err
+	}
+	c.tenantToken = respModel.TenantAccessToken
+	c.expiresAt = time.Now().Add(time.Duration(respModel.Expire)*time.Second - tenantTokenRefreshBefore)
+	return c.tenantToken, nil
+}
+
+// invalidate tenant_access_token 失效时清空缓存, 下次调用重新获取
+func (c *client) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tenantToken = ""
+}
+
+// withToken 带 tenant_access_token 调用接口, 失效时刷新后重试一次
+func (c *client) withToken(ctx context.Context, call func(token string) (apiResponse, error)) error {
+	for attempt := 0; ; attempt++ {
+		token, err := c.token(ctx)
+		if err != nil {
+			return err
+		}
+		respModel, err := call(token)
+		if err != nil {
+			return err
+		}
+		if tenantTokenCodes[respModel.Code] && attempt == 0 {
+			logger.Info(fmt.Sprintf("feishu tenant_access_token 失效, 刷新后重试, code: %d", respModel.Code))
+			c.invalidate()
+			continue
+		}
+		return respModel.err()
+	}
+}
+
+// do 发送 json 请求并解析响应, body 为 nil 时发送 GET 请求
+func (c *client) do(ctx context.Context, path, token string, body interface{}, respModel interface{}) error {
+	method, reader := http.MethodGet, io.Reader(nil)
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		method, reader = http.MethodPost, bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return c.send(ctx, req, token, respModel)
+}
+
+func (c *client) send(ctx context.Context, req *http.Request, token string, respModel interface{}) error {
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(content, respModel); err != nil {
+		return fmt.Errorf("feishu-err, statusCode:%v, body:%s, error: %v", resp.StatusCode, content, err)
+	}
+	return nil
+}
+
+// BotOpenID 获取机器人的 open_id, 成功后缓存, see https://open.feishu.cn/document/client-docs/bot-v3/obtain-bot-info
+func (c *client) BotOpenID(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	openID := c.botOpenID
+	c.mu.Unlock()
+	if openID != "" {
+		return openID, nil
+	}
+	err := c.withToken(ctx, func(token string) (apiResponse, error) {
+		respModel := struct {
+			apiResponse
+			Bot struct {
+				OpenID string `json:"open_id"`
+			} `json:"bot"`
+		}{}
+		err := c.do(ctx, "/open-apis/bot/v3/info", token, nil, &respModel)
+		openID = respModel.Bot.OpenID
+		return respModel.apiResponse, err
+	})
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.botOpenID = openID
+	c.mu.Unlock()
+	return openID, nil
+}
+
+// Reply 回复消息, content 为对应消息类型的 json, see https://open.feishu.cn/document/server-docs/im-v1/message/reply
+func (c *client) Reply(ctx context.Context, messageID, msgType string, content interface{}) error {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	body := map[string]string{"msg_type": msgType, "content": string(encoded)}
+	return c.withToken(ctx, func(token string) (apiResponse, error) {
+		var respModel apiResponse
+		err := c.do(ctx, "/open-apis/im/v1/messages/"+messageID+"/reply", token, body, &respModel)
+		return respModel, err
+	})
+}
+
+// UploadImage 上传用于发送消息的图片, 返回 image_key, see https://open.feishu.cn/document/server-docs/im-v1/image/create
+func (c *client) UploadImage(ctx context.Context, image []byte) (string, error) {
+	var imageKey string
+	err := c.withToken(ctx, func(token string) (apiResponse, error) {
+		respModel := struct {
+			apiResponse
+			Data struct {
+				ImageKey string `json:"image_key"`
+			} `json:"data"`
+		}{}
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		if err := writer.WriteField("image_type", "message"); err != nil {
+			return respModel.apiResponse, err
+		}
+		part, err := writer.CreateFormFile("image", "image.png")
+		if err != nil {
+			return respModel.apiResponse, err
+		}
+		if _, err = part.Write(image); err != nil {
+			return respModel.apiResponse, err
+		}
+		if err = writer.Close(); err != nil {
+			return respModel.apiResponse, err
+		}
+		req, err := http.NewRequest(http.MethodPost, endpoint+"/open-apis/im/v1/images", &body)
+		if err != nil {
+			return respModel.apiResponse, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		err = c.send(ctx, req, token, &respModel)
+		imageKey = respModel.Data.ImageKey
+		return respModel.apiResponse, err
+	})
+	return imageKey, err
+}
@@ -0,0 +1,67 @@
+package feishu
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// decrypt 解密事件, 密钥为 Encrypt Key 的 sha256, 密文前 16 字节为 IV, see https://open.feishu.cn/document/server-docs/event-subscription-guide/event-subscription-configure-/encrypt-key-encryption-configuration-case
+func decrypt(encrypted, encryptKey string) ([]byte, error) {
+	buf, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("decode event: %v", err)
+	}
+	if len(buf) < 2*aes.BlockSize || len(buf)%aes.BlockSize != 0 {
+		return nil, errors.New("decrypt event: invalid ciphertext length")
+	}
+	key := sha256.Sum256([]byte(encryptKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(buf)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, buf[:aes.BlockSize]).CryptBlocks(plaintext, buf[aes.BlockSize:])
+
+	// 去掉 PKCS#7 填充
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > aes.BlockSize || padding > len(plaintext) {
+		return nil, errors.New("decrypt event: invalid padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// 请求时间戳与当前时间的最大误差, 与钉钉相同
+const maxClockSkew = time.Hour
+
+// verifyRequest 校验请求头中的签名和时间戳, 签名为空或时间戳超出 maxClockSkew 时都不通过. timestamp 为秒级时间戳
+func verifyRequest(signature, timestamp, nonce, encryptKey string, body []byte, now time.Time) error {
+	if signature == "" {
+		return errors.New("missing signature")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("stale timestamp %q", timestamp)
+	}
+	if !verifySignature(signature, timestamp, nonce, encryptKey, body) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// verifySignature 校验请求头中的签名: sha256(timestamp + nonce + Encrypt Key + body)
+func verifySignature(signature, timestamp, nonce, encryptKey string, body []byte) bool {
+	sum := sha256.Sum256(append([]byte(timestamp+nonce+encryptKey), body...))
+	expected := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) == 1
+}
@@ -0,0 +1,104 @@
+package feishu
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestDecrypt(t *testing.T) {
+	// 飞书文档中的示例: Encrypt Key 为 "test key", 明文为 "hello world"
+	const sample = "P37w+VZImNgPEO1RBhJ6RtKl7n6zymIbEG1pReEzghk="
+	raw, _ := base64.StdEncoding.DecodeString(sample)
+
+	tests := []struct {
+		name      string
+		encrypted string
+		key       string
+		want      string
+		wantErr   bool
+	}{
+		{name: "official sample", encrypted: sample, key: "test key", want: "hello world"},
+		{name: "wrong key", encrypted: sample, key: "other key", wantErr: true},
+		{name: "iv only", encrypted: base64.StdEncoding.EncodeToString(raw[:16]), key: "test key", wantErr: true},
+		{name: "not block aligned", encrypted: base64.StdEncoding.EncodeToString(raw[:len(raw)-1]), key: "test key", wantErr: true},
+		{name: "invalid base64", encrypted: "not base64!", key: "test key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decrypt(tt.encrypted, tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decrypt = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decrypt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	// 签名由独立的 sha256 实现算出: sha256("1700000000" + "nonce-1" + "test key" + body)
+	const signature = "47a8abe9e2b5a7f9d1f87ce65f69446faf625495d85ec18a4c6edd0f1bdc5b04"
+	body := []byte(`{"type":"event_callback"}`)
+
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		nonce     string
+		body      []byte
+		want      bool
+	}{
+		{name: "known vector", signature: signature, timestamp: "1700000000", nonce: "nonce-1", body: body, want: true},
+		{name: "tampered body", signature: signature, timestamp: "1700000000", nonce: "nonce-1", body: []byte(`{"type":"event_callback "}`)},
+		{name: "tampered timestamp", signature: signature, timestamp: "1700000001", nonce: "nonce-1", body: body},
+		{name: "tampered nonce", signature: signature, timestamp: "1700000000", nonce: "nonce-2", body: body},
+		{name: "tampered signature", signature: "57a8abe9e2b5a7f9d1f87ce65f69446faf625495d85ec18a4c6edd0f1bdc5b04", timestamp: "1700000000", nonce: "nonce-1", body: body},
+		{name: "empty signature", timestamp: "1700000000", nonce: "nonce-1", body: body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifySignature(tt.signature, tt.timestamp, tt.nonce, "test key", tt.body); got != tt.want {
+				t.Errorf("verifySignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	// 与 TestVerifySignature 相同的签名
+	const signature = "47a8abe9e2b5a7f9d1f87ce65f69446faf625495d85ec18a4c6edd0f1bdc5b04"
+	body := []byte(`{"type":"event_callback"}`)
+	signedAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		now       time.Time
+		wantErr   bool
+	}{
+		{name: "valid", signature: signature, timestamp: "1700000000", now: signedAt},
+		{name: "within skew", signature: signature, timestamp: "1700000000", now: signedAt.Add(maxClockSkew - time.Minute)},
+		{name: "missing header", timestamp: "1700000000", now: signedAt, wantErr: true},
+		{name: "stale timestamp", signature: signature, timestamp: "1700000000", now: signedAt.Add(maxClockSkew + time.Minute), wantErr: true},
+		{name: "timestamp from the future", signature: signature, timestamp: "1700000000", now: signedAt.Add(-maxClockSkew - time.Minute), wantErr: true},
+		{name: "missing timestamp", signature: signature, now: signedAt, wantErr: true},
+		{name: "tampered signature", signature: "57a8abe9e2b5a7f9d1f87ce65f69446faf625495d85ec18a4c6edd0f1bdc5b04", timestamp: "1700000000", now: signedAt, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyRequest(tt.signature, tt.timestamp, "nonce-1", "test key", body, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyRequest = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+// Package feishu 飞书机器人渠道, 通过事件订阅接收单聊和群里@机器人的消息, 通过回复消息接口回复
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/patrickmn/go-cache"
+)
+
+// Name 渠道名称
+const Name = "feishu"
+
+// CallbackPath 事件订阅的请求地址
+const CallbackPath = "/feishu/callback"
+
+// idPrefix 会话和用户 id 的前缀, 避免与其他渠道的会话共用上下文
+const idPrefix = "feishu:"
+
+// replyTimeout 调用回复接口的超时时间
+const replyTimeout = 30 * time.Second
+
+// eventMessageReceive 接收消息事件
+const eventMessageReceive = "im.message.receive_v1"
+
+var _ channel.Channel = (*Channel)(nil)
+
+// Channel 飞书机器人渠道. 飞书要求 3 秒内响应事件, 因此收到事件后立即响应, 在后台处理并调用接口回复
+type Channel struct {
+	client            *client
+	verificationToken string
+	encryptKey        string
+	handler           channel.Handler
+	// 最近处理过的事件 id, 飞书重试时不重复处理
+	seen *cache.Cache
+}
+
+// callbackBody 事件订阅的请求, 包括 url_verification 和 2.0 版本的事件
+type callbackBody struct {
+	Encrypt   string `json:"encrypt"`
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Token     string `json:"token"`
+	Header    struct {
+		EventID    string `json:"event_id"`
+		EventType  string `json:"event_type"`
+		Token      string `json:"token"`
+		CreateTime string `json:"create_time"`
+	} `json:"header"`
+	Event json.RawMessage `json:"event"`
+}
+
+// messageEvent 接收消息事件, see https://open.feishu.cn/document/server-docs/im-v1/message/events/receive
+type messageEvent struct {
+	Sender struct {
+		SenderID struct {
+			OpenID string `json:"open_id"`
+		} `json:"sender_id"`
+		SenderType string `json:"sender_type"`
+	} `json:"sender"`
+	Message struct {
+		MessageID   string `json:"message_id"`
+		CreateTime  string `json:"create_time"`
+		ChatID      string `json:"chat_id"`
+		ChatType    string `json:"chat_type"`
+		MessageType string `json:"message_type"`
+		Content     string `json:"content"`
+		Mentions    []struct {
+			Key string `json:"key"`
+			ID  struct {
+				OpenID string `json:"open_id"`
+			} `json:"id"`
+			Name string `json:"name"`
+		} `json:"mentions"`
+	} `json:"message"`
+}
+
+// Register 在内置 http 服务上注册事件订阅地址, 未配置 feishu_app_id 时不注册
+func Register(handler channel.Handler) {
+	cfg := config.LoadConfig()
+	if cfg.FeishuAppID == "" {
+		return
+	}
+	f := &Channel{
+		client:            newClient(cfg.FeishuAppID, cfg.FeishuAppSecret),
+		verificationToken: cfg.FeishuVerificationToken,
+		encryptKey:        cfg.FeishuEncryptKey,
+		handler:           handler,
+		seen:              cache.New(10*time.Minute, 10*time.Minute),
+	}
+	server.HandleFunc(CallbackPath, f.callback)
+	logger.Info(fmt.Sprintf("feishu channel enabled, callback url: %s", CallbackPath))
+}
+
+// Name 渠道名称
+func (f *Channel) Name() string {
+	return Name
+}
+
+// SelfName 转换消息时已去掉@机器人
+func (f *Channel) SelfName() string {
+	return ""
+}
+
+// ReplyText 回复文本. 事件中没有发送者昵称, 群回复开头的 "@open_id" 转换为飞书的@
+func (f *Channel) ReplyText(msg *channel.Message, text string) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	if msg.IsGroup() {
+		openID := raw.Sender.SenderID.OpenID
+		text = strings.Replace(text, "@"+openID, fmt.Sprintf(`<at user_id="%s"></at>`, openID), 1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	return f.client.Reply(ctx, raw.Message.MessageID, "text", map[string]string{"text": text})
+}
+
+// ReplyImage 上传图片后回复
+func (f *Channel) ReplyImage(msg *channel.Message, image io.Reader) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(image)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	imageKey, err := f.client.UploadImage(ctx, data)
+	if err != nil {
+		return err
+	}
+	return f.client.Reply(ctx, raw.Message.MessageID, "image", map[string]string{"image_key": imageKey})
+}
+
+// AcceptFriend 机器人没有好友申请
+func (f *Channel) AcceptFriend(msg *channel.Message) error {
+	return fmt.Errorf("%s channel has no friend requests", Name)
+}
+
+func rawMessage(msg *channel.Message) (*messageEvent, error) {
+	raw, ok := msg.Raw.(*messageEvent)
+	if !ok {
+		return nil, fmt.Errorf("not a %s message", Name)
+	}
+	return raw, nil
+}
+
+// callback 接收事件, 配置了 Encrypt Key 时先校验签名并解密
+func (f *Channel) callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "read body error", http.StatusBadRequest)
+		return
+	}
+
+	// 1.配置了 Encrypt Key 时校验签名和时间戳. 只有 url_verification 请求不带签名, 解密后再判断
+	var signatureErr error
+	if f.encryptKey != "" {
+		signatureErr = verifyRequest(r.Header.Get("X-Lark-Signature"), r.Header.Get("X-Lark-Request-Timestamp"),
+			r.Header.Get("X-Lark-Request-Nonce"), f.encryptKey, body, time.Now())
+	}
+
+	// 2.解密
+	var callback callbackBody
+	if err = json.Unmarshal(body, &callback); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if callback.Encrypt != "" {
+		if f.encryptKey == "" {
+			http.Error(w, "encrypted event without feishu_encrypt_key", http.StatusBadRequest)
+			return
+		}
+		plaintext, err := decrypt(callback.Encrypt, f.encryptKey)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("feishu decrypt event error: %v", err))
+			http.Error(w, "invalid event", http.StatusBadRequest)
+			return
+		}
+		callback = callbackBody{}
+		if err = json.Unmarshal(plaintext, &callback); err != nil {
+			http.Error(w, "invalid event", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if signatureErr != nil && callback.Type != "url_verification" {
+		logger.Warning(fmt.Sprintf("feishu callback rejected: %v", signatureErr))
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	// 3.配置请求地址时的校验, 原样返回 challenge
+	w.Header().Set("Content-Type", "application/json")
+	if callback.Type == "url_verification" {
+		if callback.Token != f.verificationToken {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"challenge": callback.Challenge})
+		return
+	}
+	if callback.Header.Token != f.verificationToken {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+
+	// 4.立即响应, 在后台处理消息
+	_, _ = w.Write([]byte("{}"))
+	if callback.Header.EventType != eventMessageReceive {
+		return
+	}
+	if callback.Header.EventID != "" && f.seen.Add(callback.Header.EventID, struct{}{}, cache.DefaultExpiration) != nil {
+		return
+	}
+	var event messageEvent
+	if err = json.Unmarshal(callback.Event, &event); err != nil {
+		logger.Warning(fmt.Sprintf("feishu parse message event error: %v", err))
+		return
+	}
+	go f.handle(&event)
+}
+
+// handle 把消息转换为 channel.Message 交给处理器
+func (f *Channel) handle(event *messageEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			stats.IncError(stats.ErrorMessagePanic)
+			logger.Danger(fmt.Sprintf("handle feishu message panic: %v", err))
+		}
+	}()
+	// 机器人自己发送的消息不处理
+	if event.Sender.SenderType != "" && event.Sender.SenderType != "user" {
+		return
+	}
+	message := event.Message
+	millis, _ := strconv.ParseInt(message.CreateTime, 10, 64)
+	openID := event.Sender.SenderID.OpenID
+	msg := &channel.Message{
+		Channel: f,
+		ID:      message.MessageID,
+		Type:    messageType(message.MessageType),
+		Time:    time.Unix(0, millis*int64(time.Millisecond)),
+		Sender:  channel.Sender{ID: idPrefix + openID, NickName: openID},
+		Raw:     event,
+	}
+	msg.Conversation = channel.Conversation{ID: msg.Sender.ID, Name: msg.Sender.NickName}
+	if message.ChatType == "group" {
+		msg.Conversation = channel.Conversation{ID: idPrefix + message.ChatID, Name: message.ChatID, IsGroup: true}
+	}
+
+	if msg.Type == channel.TypeText {
+		content := struct {
+			Text string `json:"text"`
+		}{}
+		if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+			logger.Warning(fmt.Sprintf("feishu parse message content error: %v", err))
+			return
+		}
+		// 消息中的@为 @_user_N 占位符, 去掉@机器人, 其余替换为成员名称
+		botOpenID, err := f.client.BotOpenID(context.Background())
+		if err != nil {
+			logger.Warning(fmt.Sprintf("feishu get bot info error, treat the message as not mentioning the bot: %v", err))
+		}
+		text := content.Text
+		for _, mention := range message.Mentions {
+			if botOpenID != "" && mention.ID.OpenID == botOpenID {
+				msg.IsAt = true
+				text = strings.ReplaceAll(text, mention.Key, "")
+				continue
+			}
+			text = strings.ReplaceAll(text, mention.Key, "@"+mention.Name)
+		}
+		msg.Content = strings.TrimSpace(text)
+	}
+	f.handler(msg)
+}
+
+// messageType 飞书的消息类型
+func messageType(msgType string) string {
+	switch msgType {
+	case "text":
+		return channel.TypeText
+	case "image":
+		return channel.TypePicture
+	case "sticker":
+		return channel.TypeEmoticon
+	case "audio":
+		return channel.TypeVoice
+	case "media":
+		return channel.TypeVideo
+	case "location":
+		return channel.TypeLocation
+	case "share_user":
+		return channel.TypeCard
+	case "system":
+		return channel.TypeSystem
+	default:
+		return channel.TypeOther
+	}
+}
@@ -0,0 +1,207 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pollTimeout getUpdates 长轮询的等待时间
+const pollTimeout = 50 * time.Second
+
+// client Telegram Bot API, see https://core.telegram.org/bots/api
+type client struct {
+	http   *http.Client
+	apiURL string
+	token  string
+}
+
+func newClient(apiURL, token string) *client {
+	return &client{
+		// 超时时间要大于长轮询的等待时间
+		http:   &http.Client{Timeout: pollTimeout + 20*time.Second},
+		apiURL: strings.TrimRight(apiURL, "/"),
+		token:  token,
+	}
+}
+
+// apiResponse 接口的公共响应
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// User 用户或机器人
+type User struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+// displayName 优先使用用户名, 群回复时 "@用户名" 会提醒对方
+func (u *User) displayName() string {
+	if u.Username != "" {
+		return u.Username
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// Chat 会话
+type Chat struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+}
+
+// Message 消息, 只解析用到的字段
+type Message struct {
+	MessageID      int64           `json:"message_id"`
+	From           *User           `json:"from"`
+	Chat           Chat            `json:"chat"`
+	Date           int64           `json:"date"`
+	Text           string          `json:"text"`
+	Caption        string          `json:"caption"`
+	ReplyToMessage *Message        `json:"reply_to_message"`
+	Photo          json.RawMessage `json:"photo"`
+	Sticker        json.RawMessage `json:"sticker"`
+	Voice          json.RawMessage `json:"voice"`
+	Audio          json.RawMessage `json:"audio"`
+	Video          json.RawMessage `json:"video"`
+	Location       json.RawMessage `json:"location"`
+	Contact        json.RawMessage `json:"contact"`
+	NewChatMembers json.RawMessage `json:"new_chat_members"`
+}
+
+// Update 收到的更新, 只订阅 message
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+// call 以 json 调用接口, result 解析到 out
+func (c *client) call(ctx context.Context, method string, params map[string]interface{}, out interface{}) error {
+	content, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.methodURL(method), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(ctx, req, method, out)
+}
+
+func (c *client) do(ctx context.Context, req *http.Request, method string, out interface{}) error {
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var respModel apiResponse
+	if err = json.Unmarshal(body, &respModel); err != nil {
+		return fmt.Errorf("telegram-err, method:%s, statusCode:%v, error: %v", method, resp.StatusCode, err)
+	}
+	if !respModel.Ok {
+		return fmt.Errorf("telegram-err, method:%s, error_code:%d, description:%v", method, respModel.ErrorCode, respModel.Description)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respModel.Result, out)
+}
+
+func (c *client) methodURL(method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", c.apiURL, c.token, method)
+}
+
+// GetMe 机器人自身的信息
+func (c *client) GetMe(ctx context.Context) (*User, error) {
+	var me User
+	if err := c.call(ctx, "getMe", map[string]interface{}{}, &me); err != nil {
+		return nil, err
+	}
+	return &me, nil
+}
+
+// GetUpdates 长轮询获取 offset 之后的更新
+func (c *client) GetUpdates(ctx context.Context, offset int64) ([]Update, error) {
+	var updates []Update
+	err := c.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(pollTimeout / time.Second),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// SetWebhook 设置 webhook, Telegram 推送更新时在请求头中带上 secretToken
+func (c *client) SetWebhook(ctx context.Context, url, secretToken string) error {
+	return c.call(ctx, "setWebhook", map[string]interface{}{
+		"url":             url,
+		"secret_token":    secretToken,
+		"allowed_updates": []string{"message"},
+	}, nil)
+}
+
+// DeleteWebhook 删除 webhook, 设置了 webhook 时不能使用 getUpdates
+func (c *client) DeleteWebhook(ctx context.Context) error {
+	return c.call(ctx, "deleteWebhook", map[string]interface{}{}, nil)
+}
+
+// SendMessage 发送文本, replyTo 不为 0 时回复该消息
+func (c *client) SendMessage(ctx context.Context, chatID int64, text string, replyTo int64) error {
+	params := map[string]interface{}{"chat_id": chatID, "text": text}
+	if replyTo != 0 {
+		params["reply_to_message_id"] = replyTo
+		params["allow_sending_without_reply"] = true
+	}
+	return c.call(ctx, "sendMessage", params, nil)
+}
+
+// SendPhoto 上传并发送图片, replyTo 不为 0 时回复该消息
+func (c *client) SendPhoto(ctx context.Context, chatID int64, image []byte, replyTo int64) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fields := map[string]string{"chat_id": strconv.FormatInt(chatID, 10)}
+	if replyTo != 0 {
+		fields["reply_to_message_id"] = strconv.FormatInt(replyTo, 10)
+		fields["allow_sending_without_reply"] = "true"
+	}
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile("photo", "image.png")
+	if err != nil {
+		return err
+	}
+	if _, err = part.Write(image); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.methodURL("sendPhoto"), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return c.do(ctx, req, "sendPhoto", nil)
+}
@@ -0,0 +1,370 @@
+// Package telegram Telegram 机器人渠道, 通过长轮询或 webhook 接收私聊和群消息, 群里@机器人或回复机器人的消息时触发
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/patrickmn/go-cache"
+)
+
+// Name 渠道名称
+const Name = "telegram"
+
+// WebhookPath webhook 模式下接收更新的地址
+const WebhookPath = "/telegram/webhook"
+
+// idPrefix 会话和用户 id 的前缀, 避免与其他渠道的会话共用上下文
+const idPrefix = "telegram:"
+
+// replyTimeout 调用发送接口的超时时间
+const replyTimeout = 30 * time.Second
+
+// maxTextLength 单条文本消息的最大长度, 按 UTF-16 编码单元计算, 超过时分多条发送
+const maxTextLength = 4096
+
+// 获取机器人信息或轮询失败后的重试间隔
+const (
+	minRetryInterval = time.Second
+	maxRetryInterval = time.Minute
+)
+
+var _ channel.Channel = (*Channel)(nil)
+
+// Channel Telegram 机器人渠道
+type Channel struct {
+	client  *client
+	handler channel.Handler
+	// webhook 请求头 X-Telegram-Bot-Api-Secret-Token 的值, 每次启动随机生成
+	secretToken string
+	// 最近处理过的更新 id, webhook 重试时不重复处理
+	seen *cache.Cache
+
+	mu sync.RWMutex
+	// 机器人自身的信息, 启动后通过 getMe 获取, 用于判断群消息是否@了机器人
+	me *User
+	// 匹配消息中的 @机器人用户名, 包括 /command@机器人用户名
+	mention *regexp.Regexp
+}
+
+// Register 启动 Telegram 渠道, 未配置 telegram_bot_token 时不启动.
+// webhook 模式在内置 http 服务上注册接收地址, 否则在后台长轮询
+func Register(handler channel.Handler) error {
+	cfg := config.LoadConfig()
+	if cfg.TelegramBotToken == "" {
+		return nil
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	t := &Channel{
+		client:      newClient(cfg.TelegramApiURL, cfg.TelegramBotToken),
+		handler:     handler,
+		secretToken: hex.EncodeToString(secret),
+		seen:        cache.New(10*time.Minute, 10*time.Minute),
+	}
+	if cfg.TelegramWebhook {
+		webhookURL := strings.TrimRight(cfg.PublicURL, "/") + WebhookPath
+		server.HandleFunc(WebhookPath, t.webhook)
+		go t.runWebhook(webhookURL)
+		logger.Info(fmt.Sprintf("telegram channel enabled, webhook url: %s", webhookURL))
+		return nil
+	}
+	go t.poll()
+	logger.Info("telegram channel enabled, receiving updates by long polling")
+	return nil
+}
+
+// Name 渠道名称
+func (t *Channel) Name() string {
+	return Name
+}
+
+// SelfName 转换消息时已去掉@机器人
+func (t *Channel) SelfName() string {
+	return ""
+}
+
+// ReplyText 发送文本, 群消息以回复的形式发送, 超长时分多条发送
+func (t *Channel) ReplyText(msg *channel.Message, text string) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	for _, chunk := range splitText(text, maxTextLength) {
+		if err = t.client.SendMessage(ctx, raw.Chat.ID, chunk, replyTo(msg, raw)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplyImage 以图片消息发送
+func (t *Channel) ReplyImage(msg *channel.Message, image io.Reader) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(image)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	return t.client.SendPhoto(ctx, raw.Chat.ID, data, replyTo(msg, raw))
+}
+
+// AcceptFriend Telegram 没有好友申请
+func (t *Channel) AcceptFriend(msg *channel.Message) error {
+	return fmt.Errorf("%s channel has no friend requests", Name)
+}
+
+func rawMessage(msg *channel.Message) (*Message, error) {
+	raw, ok := msg.Raw.(*Message)
+	if !ok {
+		return nil, errors.New("not a telegram message")
+	}
+	return raw, nil
+}
+
+// replyTo 群里回复触发的消息, 私聊直接发送
+func replyTo(msg *channel.Message, raw *Message) int64 {
+	if msg.IsGroup() {
+		return raw.MessageID
+	}
+	return 0
+}
+
+// self 机器人自身的信息, 未获取到时为 nil
+func (t *Channel) self() (*User, *regexp.Regexp) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.me, t.mention
+}
+
+// loadMe 获取机器人自身的信息, 失败时按退避间隔重试直到成功
+func (t *Channel) loadMe() {
+	for interval := minRetryInterval; ; interval = nextInterval(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+		me, err := t.client.GetMe(ctx)
+		cancel()
+		if err == nil {
+			t.mu.Lock()
+			t.me = me
+			t.mention = mentionPattern(me.Username)
+			t.mu.Unlock()
+			logger.Info(fmt.Sprintf("telegram bot @%s is ready", me.Username))
+			return
+		}
+		logger.Warning(fmt.Sprintf("telegram getMe error, retry in %v: %v", interval, err))
+		time.Sleep(interval)
+	}
+}
+
+// mentionPattern 匹配消息中的 @机器人用户名, 包括 /command@机器人用户名
+func mentionPattern(username string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(username) + `\b`)
+}
+
+// runWebhook 获取机器人信息后设置 webhook, 失败时按退避间隔重试
+func (t *Channel) runWebhook(webhookURL string) {
+	t.loadMe()
+	for interval := minRetryInterval; ; interval = nextInterval(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+		err := t.client.SetWebhook(ctx, webhookURL, t.secretToken)
+		cancel()
+		if err == nil {
+			return
+		}
+		logger.Warning(fmt.Sprintf("telegram setWebhook error, retry in %v: %v", interval, err))
+		time.Sleep(interval)
+	}
+}
+
+// poll 删除之前设置的 webhook 后长轮询, 失败时按退避间隔重试
+func (t *Channel) poll() {
+	t.loadMe()
+	for interval := minRetryInterval; ; interval = nextInterval(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+		err := t.client.DeleteWebhook(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+		logger.Warning(fmt.Sprintf("telegram deleteWebhook error, retry in %v: %v", interval, err))
+		time.Sleep(interval)
+	}
+
+	var offset int64
+	interval := minRetryInterval
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), pollTimeout+15*time.Second)
+		updates, err := t.client.GetUpdates(ctx, offset)
+		cancel()
+		if err != nil {
+			stats.SetLastError(err)
+			logger.Warning(fmt.Sprintf("telegram getUpdates error, retry in %v: %v", interval, err))
+			time.Sleep(interval)
+			interval = nextInterval(interval)
+			continue
+		}
+		interval = minRetryInterval
+		for i := range updates {
+			offset = updates[i].UpdateID + 1
+			if updates[i].Message != nil {
+				go t.handle(updates[i].Message)
+			}
+		}
+	}
+}
+
+func nextInterval(interval time.Duration) time.Duration {
+	if interval *= 2; interval > maxRetryInterval {
+		return maxRetryInterval
+	}
+	return interval
+}
+
+// webhook 接收 Telegram 推送的更新, 机器人信息未就绪时返回 503 让 Telegram 稍后重试
+func (t *Channel) webhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Telegram-Bot-Api-Secret-Token")), []byte(t.secretToken)) != 1 {
+		http.Error(w, "invalid secret token", http.StatusForbidden)
+		return
+	}
+	if me, _ := t.self(); me == nil {
+		http.Error(w, "bot not ready", http.StatusServiceUnavailable)
+		return
+	}
+	var update Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	// 立即响应, 在后台处理消息
+	w.WriteHeader(http.StatusOK)
+	if update.Message == nil || t.seen.Add(strconv.FormatInt(update.UpdateID, 10), struct{}{}, cache.DefaultExpiration) != nil {
+		return
+	}
+	go t.handle(update.Message)
+}
+
+// handle 把消息转换为 channel.Message 交给处理器, 其他机器人的消息不处理
+func (t *Channel) handle(message *Message) {
+	defer func() {
+		if err := recover(); err != nil {
+			stats.IncError(stats.ErrorMessagePanic)
+			logger.Danger(fmt.Sprintf("handle telegram message panic: %v", err))
+		}
+	}()
+	if message.From == nil || message.From.IsBot {
+		return
+	}
+	me, mention := t.self()
+	sender := channel.Sender{ID: idPrefix + strconv.FormatInt(message.From.ID, 10), NickName: message.From.displayName()}
+	msg := &channel.Message{
+		Channel:      t,
+		ID:           strconv.FormatInt(message.MessageID, 10),
+		Type:         messageType(message),
+		Content:      message.Text,
+		Time:         time.Unix(message.Date, 0),
+		Sender:       sender,
+		Conversation: channel.Conversation{ID: sender.ID, Name: sender.NickName},
+		Raw:          message,
+	}
+	if msg.Content == "" {
+		msg.Content = message.Caption
+	}
+	if message.Chat.Type == "group" || message.Chat.Type == "supergroup" {
+		msg.Conversation = channel.Conversation{ID: idPrefix + strconv.FormatInt(message.Chat.ID, 10), Name: message.Chat.Title, IsGroup: true}
+		// 回复机器人的消息也视为@机器人
+		if reply := message.ReplyToMessage; reply != nil && reply.From != nil && me != nil && reply.From.ID == me.ID {
+			msg.IsAt = true
+		}
+	}
+	// 去掉@机器人, 包括群里的 /command@机器人用户名
+	if mention != nil && mention.MatchString(msg.Content) {
+		msg.IsAt = true
+		msg.Content = strings.TrimSpace(mention.ReplaceAllString(msg.Content, ""))
+	}
+	t.handler(msg)
+}
+
+// messageType Telegram 的消息类型
+func messageType(message *Message) string {
+	switch {
+	case message.Text != "":
+		return channel.TypeText
+	case message.Photo != nil:
+		return channel.TypePicture
+	case message.Sticker != nil:
+		return channel.TypeEmoticon
+	case message.Voice != nil, message.Audio != nil:
+		return channel.TypeVoice
+	case message.Video != nil:
+		return channel.TypeVideo
+	case message.Location != nil:
+		return channel.TypeLocation
+	case message.Contact != nil:
+		return channel.TypeCard
+	case message.NewChatMembers != nil:
+		return channel.TypeSystem
+	default:
+		return channel.TypeOther
+	}
+}
+
+// splitText 把文本拆分为不超过 maxLength 个 UTF-16 编码单元的多段, 尽量在换行处拆分
+func splitText(text string, maxLength int) []string {
+	var chunks []string
+	for {
+		length, cut, newline := 0, len(text), -1
+		for i, r := range text {
+			width := 1
+			if r > 0xFFFF {
+				width = 2
+			}
+			if length+width > maxLength {
+				cut = i
+				break
+			}
+			length += width
+			if r == '\n' {
+				newline = i + 1
+			}
+		}
+		if cut == len(text) {
+			return append(chunks, text)
+		}
+		if newline > 0 {
+			cut = newline
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+}
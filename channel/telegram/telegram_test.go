@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxLength int
+		want      []string
+	}{
+		{name: "short", text: "hello", maxLength: 5, want: []string{"hello"}},
+		{name: "hard cut", text: "abcdef", maxLength: 4, want: []string{"abcd", "ef"}},
+		{name: "cut at newline", text: "ab\ncdef", maxLength: 5, want: []string{"ab\n", "cdef"}},
+		{name: "last newline wins", text: "a\nb\ncdefg", maxLength: 5, want: []string{"a\nb\n", "cdefg"}},
+		{name: "cjk is one unit", text: "你好世界", maxLength: 3, want: []string{"你好世", "界"}},
+		{name: "emoji is two units", text: "😀😀😀", maxLength: 4, want: []string{"😀😀", "😀"}},
+		{name: "emoji not split", text: "ab😀", maxLength: 3, want: []string{"ab", "😀"}},
+		{name: "many chunks", text: strings.Repeat("x", 10), maxLength: 4, want: []string{"xxxx", "xxxx", "xx"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitText(tt.text, tt.maxLength); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitText(%q, %d) = %q, want %q", tt.text, tt.maxLength, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandle(t *testing.T) {
+	me := &User{ID: 1000, IsBot: true, Username: "wechatbot_bot"}
+	alice := &User{ID: 42, Username: "alice"}
+	group := Chat{ID: -100, Type: "supergroup", Title: "team"}
+	private := Chat{ID: 42, Type: "private"}
+
+	tests := []struct {
+		name    string
+		message *Message
+		// 为空表示不交给处理器
+		wantConversation string
+		wantContent      string
+		wantIsAt         bool
+	}{
+		{name: "private", message: &Message{From: alice, Chat: private, Text: "hello"}, wantConversation: "telegram:42", wantContent: "hello"},
+		{name: "group without mention", message: &Message{From: alice, Chat: group, Text: "hello"}, wantConversation: "telegram:-100", wantContent: "hello"},
+		{name: "group mention", message: &Message{From: alice, Chat: group, Text: "@wechatbot_bot hello"}, wantConversation: "telegram:-100", wantContent: "hello", wantIsAt: true},
+		{name: "mention case insensitive", message: &Message{From: alice, Chat: group, Text: "hi @WechatBot_Bot"}, wantConversation: "telegram:-100", wantContent: "hi", wantIsAt: true},
+		{name: "command with bot name", message: &Message{From: alice, Chat: group, Text: "/usage@wechatbot_bot weekly"}, wantConversation: "telegram:-100", wantContent: "/usage weekly", wantIsAt: true},
+		{name: "other bot name", message: &Message{From: alice, Chat: group, Text: "@wechatbot_botx hello"}, wantConversation: "telegram:-100", wantContent: "@wechatbot_botx hello"},
+		{name: "reply to bot", message: &Message{From: alice, Chat: group, Text: "and then?", ReplyToMessage: &Message{From: me}}, wantConversation: "telegram:-100", wantContent: "and then?", wantIsAt: true},
+		{name: "reply to other user", message: &Message{From: alice, Chat: group, Text: "and then?", ReplyToMessage: &Message{From: &User{ID: 7}}}, wantConversation: "telegram:-100", wantContent: "and then?"},
+		{name: "caption", message: &Message{From: alice, Chat: group, Caption: "@wechatbot_bot what is this", Photo: []byte(`[]`)}, wantConversation: "telegram:-100", wantContent: "what is this", wantIsAt: true},
+		{name: "from bot", message: &Message{From: &User{ID: 7, IsBot: true}, Chat: group, Text: "@wechatbot_bot hello"}},
+		{name: "no sender", message: &Message{Chat: group, Text: "hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *channel.Message
+			c := &Channel{me: me, mention: mentionPattern(me.Username), handler: func(msg *channel.Message) { got = msg }}
+			c.handle(tt.message)
+			if tt.wantConversation == "" {
+				if got != nil {
+					t.Fatalf("handler called with %+v, want not called", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("handler not called")
+			}
+			if got.Conversation.ID != tt.wantConversation || got.Content != tt.wantContent || got.IsAt != tt.wantIsAt {
+				t.Errorf("got conversation %q content %q isAt %v, want %q %q %v",
+					got.Conversation.ID, got.Content, got.IsAt, tt.wantConversation, tt.wantContent, tt.wantIsAt)
+			}
+			if got.Sender.ID != "telegram:42" || got.Sender.NickName != "alice" {
+				t.Errorf("sender = %+v, want telegram:42 alice", got.Sender)
+			}
+		})
+	}
+}
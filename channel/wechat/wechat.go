@@ -0,0 +1,180 @@
+// Package wechat 基于 openwechat 的个人微信渠道
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/eatmoreapple/openwechat"
+)
+
+// Name 渠道名称
+const Name = "wechat"
+
+var _ channel.Channel = (*Channel)(nil)
+
+var _ channel.Profiled = (*Channel)(nil)
+
+// Channel 个人微信渠道, 把 openwechat 的消息转换为 channel.Message 交给处理器
+type Channel struct {
+	bot *openwechat.Bot
+	// 多账号模式下的账号名称, 单账号模式下为空
+	account string
+	// 会话和用户 id 的前缀, 多账号模式下为 "wechat:账号名称:", 各账号的会话互不影响
+	idPrefix string
+	profile  string
+}
+
+// New 创建微信渠道, account 为多账号模式下的账号名称, 单账号模式下为空. profile 为该账号默认的 profile
+func New(bot *openwechat.Bot, account, profile string) *Channel {
+	w := &Channel{bot: bot, account: account, profile: profile}
+	if account != "" {
+		w.idPrefix = Name + ":" + account + ":"
+	}
+	return w
+}
+
+// Name 渠道名称
+func (w *Channel) Name() string {
+	return Name
+}
+
+// Profile 账号默认的 profile
+func (w *Channel) Profile() string {
+	return w.profile
+}
+
+// SelfName 登录账号的昵称, 未登录时为空
+func (w *Channel) SelfName() string {
+	self, err := w.bot.GetCurrentUser()
+	if err != nil {
+		return ""
+	}
+	return self.NickName
+}
+
+// ReplyText 回复文本消息
+func (w *Channel) ReplyText(msg *channel.Message, text string) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	_, err = raw.ReplyText(text)
+	return err
+}
+
+// ReplyImage 回复图片消息
+func (w *Channel) ReplyImage(msg *channel.Message, image io.Reader) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	_, err = raw.ReplyImage(image)
+	return err
+}
+
+// AcceptFriend 通过好友申请
+func (w *Channel) AcceptFriend(msg *channel.Message) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	_, err = raw.Agree("")
+	return err
+}
+
+// MessageHandler 把 handler 适配为 openwechat 的消息处理函数, 无法转换的消息只记录日志.
+// 处理消息时的 panic 只记录日志, 不影响该账号后续的消息
+func (w *Channel) MessageHandler(handler channel.Handler) openwechat.MessageHandler {
+	return func(raw *openwechat.Message) {
+		defer func() {
+			if err := recover(); err != nil {
+				stats.IncError(stats.ErrorMessagePanic)
+				logger.Danger(fmt.Sprintf("handle wechat message panic, account %q: %v", w.account, err))
+			}
+		}()
+		msg, err := w.convert(raw)
+		if err != nil {
+			stats.IncError(stats.ErrorWechatMessage)
+			logger.Warning(fmt.Sprintf("convert wechat message error: %s", err))
+			return
+		}
+		handler(msg)
+	}
+}
+
+// convert 转换消息, 群消息的发送者为群成员, 会话为群
+func (w *Channel) convert(raw *openwechat.Message) (*channel.Message, error) {
+	msg := &channel.Message{
+		Channel: w,
+		ID:      raw.MsgId,
+		Type:    messageType(raw),
+		Content: raw.Content,
+		Time:    time.Unix(raw.CreateTime, 0),
+		IsAt:    raw.IsAt(),
+		Raw:     raw,
+	}
+	// 好友申请的发送者不在好友列表中, 通过申请时只需要原始消息
+	if raw.IsFriendAdd() {
+		return msg, nil
+	}
+
+	sender, err := raw.Sender()
+	if err != nil {
+		return nil, err
+	}
+	msg.Conversation = channel.Conversation{ID: w.idPrefix + sender.ID(), Name: sender.NickName}
+	msg.Sender = channel.Sender{ID: w.idPrefix + sender.ID(), NickName: sender.NickName}
+	if raw.IsComeFromGroup() {
+		member, err := raw.SenderInGroup()
+		if err != nil {
+			return nil, err
+		}
+		msg.Conversation.IsGroup = true
+		msg.Sender = channel.Sender{ID: w.idPrefix + member.ID(), NickName: member.NickName}
+	}
+	return msg, nil
+}
+
+func rawMessage(msg *channel.Message) (*openwechat.Message, error) {
+	raw, ok := msg.Raw.(*openwechat.Message)
+	if !ok {
+		return nil, errors.New("not a wechat message")
+	}
+	return raw, nil
+}
+
+// messageType 消息类型
+func messageType(msg *openwechat.Message) string {
+	switch {
+	case msg.IsText():
+		return channel.TypeText
+	case msg.IsLocation():
+		return channel.TypeLocation
+	case msg.IsPicture():
+		return channel.TypePicture
+	case msg.IsEmoticon():
+		return channel.TypeEmoticon
+	case msg.IsVoice():
+		return channel.TypeVoice
+	case msg.IsVideo():
+		return channel.TypeVideo
+	case msg.IsCard():
+		return channel.TypeCard
+	case msg.IsFriendAdd():
+		return channel.TypeFriendAdd
+	case msg.IsRecalled():
+		return channel.TypeRecalled
+	case msg.IsMedia():
+		return channel.TypeApp
+	case msg.IsSystem():
+		return channel.TypeSystem
+	default:
+		return channel.TypeOther
+	}
+}
@@ -0,0 +1,84 @@
+package wecom
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// msgCrypt 企业微信回调消息的签名校验和解密, see https://developer.work.weixin.qq.com/document/path/90968
+type msgCrypt struct {
+	token  string
+	key    []byte
+	corpID string
+}
+
+// newMsgCrypt encodingAESKey 为 43 位的 EncodingAESKey
+func newMsgCrypt(token, encodingAESKey, corpID string) (*msgCrypt, error) {
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, fmt.Errorf("invalid EncodingAESKey: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("invalid EncodingAESKey: must decode to 32 bytes")
+	}
+	return &msgCrypt{token: token, key: key, corpID: corpID}, nil
+}
+
+// signature 对 token, timestamp, nonce 和密文排序拼接后取 sha1
+func (m *msgCrypt) signature(timestamp, nonce, encrypted string) string {
+	parts := []string{m.token, timestamp, nonce, encrypted}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// verify 校验签名
+func (m *msgCrypt) verify(signature, timestamp, nonce, encrypted string) bool {
+	expected := m.signature(timestamp, nonce, encrypted)
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) == 1
+}
+
+// decrypt 解密消息, 明文为 16 字节随机串 + 4 字节消息长度 + 消息 + 企业 id
+func (m *msgCrypt) decrypt(encrypted string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("decode message: %v", err)
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("decrypt message: invalid ciphertext length")
+	}
+	block, err := aes.NewCipher(m.key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, m.key[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext)
+
+	// 去掉 PKCS#7 填充, 企业微信按 32 字节填充
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > 32 || padding > len(plaintext) {
+		return nil, errors.New("decrypt message: invalid padding")
+	}
+	plaintext = plaintext[:len(plaintext)-padding]
+	if len(plaintext) < 20 {
+		return nil, errors.New("decrypt message: plaintext too short")
+	}
+	length := int(binary.BigEndian.Uint32(plaintext[16:20]))
+	if 20+length > len(plaintext) {
+		return nil, errors.New("decrypt message: invalid message length")
+	}
+	message, receiveID := plaintext[20:20+length], string(plaintext[20+length:])
+	if receiveID != m.corpID {
+		return nil, fmt.Errorf("decrypt message: unexpected corp id %q", receiveID)
+	}
+	return message, nil
+}
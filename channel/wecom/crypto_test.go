@@ -0,0 +1,138 @@
+package wecom
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+)
+
+// 企业微信官方加解密示例中的参数, see https://developer.work.weixin.qq.com/document/path/90968
+const (
+	sampleToken          = "QDG6eK"
+	sampleEncodingAESKey = "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"
+	sampleCorpID         = "wx5823bf96d3bd56c7"
+	sampleSignature      = "5c45ff5e21c57e6ad56bac8758b79b1d9ac89fd3"
+	sampleTimestamp      = "1409659589"
+	sampleNonce          = "263014780"
+	sampleEchoStr        = "P9nAzCzyDtyTWESHep1vC5X9xho/qYX3Zpb4yKa9SKld1DsH3Iyt3tP3zNdtp+4RPcs8TgAE7OaBO+FZXvnaqQ=="
+	sampleEchoPlain      = "1616140317555161061"
+)
+
+func newSampleCrypt(t *testing.T) *msgCrypt {
+	t.Helper()
+	m, err := newMsgCrypt(sampleToken, sampleEncodingAESKey, sampleCorpID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// encrypt 按企业微信的格式加密, padding 为填充字节数, 用于构造异常的密文
+func encrypt(t *testing.T, m *msgCrypt, message, corpID string, padding int) string {
+	t.Helper()
+	var plaintext bytes.Buffer
+	plaintext.WriteString("0123456789abcdef")
+	_ = binary.Write(&plaintext, binary.BigEndian, uint32(len(message)))
+	plaintext.WriteString(message)
+	plaintext.WriteString(corpID)
+	plaintext.Write(bytes.Repeat([]byte{byte(padding)}, padding))
+	if plaintext.Len()%aes.BlockSize != 0 {
+		t.Fatalf("plaintext length %d is not a multiple of the block size", plaintext.Len())
+	}
+	block, err := aes.NewCipher(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, plaintext.Len())
+	cipher.NewCBCEncrypter(block, m.key[:aes.BlockSize]).CryptBlocks(ciphertext, plaintext.Bytes())
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+// pkcs7 企业微信按 32 字节填充时需要的填充字节数
+func pkcs7(message, corpID string) int {
+	n := 20 + len(message) + len(corpID)
+	return 32 - n%32
+}
+
+func TestNewMsgCrypt(t *testing.T) {
+	tests := map[string]string{
+		"too short":      "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2",
+		"invalid base64": "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2!",
+	}
+	for name, key := range tests {
+		if _, err := newMsgCrypt(sampleToken, key, sampleCorpID); err == nil {
+			t.Errorf("%s: newMsgCrypt(%q) should fail", name, key)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := newSampleCrypt(t)
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		nonce     string
+		encrypted string
+		want      bool
+	}{
+		{name: "official sample", signature: sampleSignature, timestamp: sampleTimestamp, nonce: sampleNonce, encrypted: sampleEchoStr, want: true},
+		{name: "tampered signature", signature: "5c45ff5e21c57e6ad56bac8758b79b1d9ac89fd4", timestamp: sampleTimestamp, nonce: sampleNonce, encrypted: sampleEchoStr},
+		{name: "tampered timestamp", signature: sampleSignature, timestamp: "1409659590", nonce: sampleNonce, encrypted: sampleEchoStr},
+		{name: "tampered nonce", signature: sampleSignature, timestamp: sampleTimestamp, nonce: "263014781", encrypted: sampleEchoStr},
+		{name: "tampered ciphertext", signature: sampleSignature, timestamp: sampleTimestamp, nonce: sampleNonce, encrypted: "Q" + sampleEchoStr[1:]},
+		{name: "empty signature", timestamp: sampleTimestamp, nonce: sampleNonce, encrypted: sampleEchoStr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.verify(tt.signature, tt.timestamp, tt.nonce, tt.encrypted); got != tt.want {
+				t.Errorf("verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	m := newSampleCrypt(t)
+	message := "<xml><Content><![CDATA[hello]]></Content></xml>"
+	valid := encrypt(t, m, message, sampleCorpID, pkcs7(message, sampleCorpID))
+	raw, _ := base64.StdEncoding.DecodeString(valid)
+
+	tests := []struct {
+		name      string
+		encrypted string
+		want      string
+		wantErr   bool
+	}{
+		{name: "official sample", encrypted: sampleEchoStr, want: sampleEchoPlain},
+		{name: "round trip", encrypted: valid, want: message},
+		{name: "wrong corp", encrypted: encrypt(t, m, message, "wx0000000000000000", pkcs7(message, "wx0000000000000000")), wantErr: true},
+		// 不填充, 最后一个字节为 'k', 不是合法的填充长度
+		{name: "bad padding", encrypted: encrypt(t, m, message, sampleCorpID+"abcdefghijk", 0), wantErr: true},
+		{name: "padding too large", encrypted: encrypt(t, m, "", "", 44), wantErr: true},
+		{name: "truncated", encrypted: base64.StdEncoding.EncodeToString(raw[:len(raw)-aes.BlockSize]), wantErr: true},
+		{name: "not block aligned", encrypted: base64.StdEncoding.EncodeToString(raw[:len(raw)-1]), wantErr: true},
+		{name: "empty", encrypted: "", wantErr: true},
+		{name: "invalid base64", encrypted: "not base64!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.decrypt(tt.encrypted)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decrypt = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decrypt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// Package wecom 企业微信自建应用渠道, 通过回调接收成员发给应用的消息, 通过应用消息接口回复
+package wecom
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/wechat_notify_http_client"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/patrickmn/go-cache"
+)
+
+// Name 渠道名称
+const Name = "wecom"
+
+// CallbackPath 应用接收消息的回调地址
+const CallbackPath = "/wecom/callback"
+
+// idPrefix 会话和用户 id 的前缀, 避免与其他渠道的会话共用上下文
+const idPrefix = "wecom:"
+
+// replyTimeout 调用应用消息接口的超时时间
+const replyTimeout = 30 * time.Second
+
+var _ channel.Channel = (*Channel)(nil)
+
+// Channel 企业微信自建应用渠道. 企业微信要求 5 秒内响应回调, 因此收到消息后立即响应, 在后台处理并通过应用消息接口回复
+type Channel struct {
+	client  *wechat_notify_http_client.WechatAppHttpClient
+	crypt   *msgCrypt
+	handler channel.Handler
+	// 最近处理过的消息 id, 企业微信超时重试时不重复处理
+	seen *cache.Cache
+}
+
+// envelope 回调的加密消息
+type envelope struct {
+	ToUserName string `xml:"ToUserName"`
+	AgentID    string `xml:"AgentID"`
+	Encrypt    string `xml:"Encrypt"`
+}
+
+// inboundMessage 解密后的消息, see https://developer.work.weixin.qq.com/document/path/90239
+type inboundMessage struct {
+	ToUserName   string `xml:"ToUserName"`
+	FromUserName string `xml:"FromUserName"`
+	CreateTime   int64  `xml:"CreateTime"`
+	MsgType      string `xml:"MsgType"`
+	Content      string `xml:"Content"`
+	MsgID        string `xml:"MsgId"`
+	AgentID      int64  `xml:"AgentID"`
+	Event        string `xml:"Event"`
+}
+
+// Register 在内置 http 服务上注册回调地址, 未配置 wecom_app_corp_id 时不注册
+func Register(handler channel.Handler) error {
+	cfg := config.LoadConfig()
+	if cfg.WecomAppCorpID == "" {
+		return nil
+	}
+	crypt, err := newMsgCrypt(cfg.WecomAppToken, cfg.WecomAppAESKey, cfg.WecomAppCorpID)
+	if err != nil {
+		return err
+	}
+	w := &Channel{
+		client:  wechat_notify_http_client.NewWechatAppHttpClient(cfg.WecomAppCorpID, cfg.WecomAppAgentID, cfg.WecomAppSecret),
+		crypt:   crypt,
+		handler: handler,
+		seen:    cache.New(10*time.Minute, 10*time.Minute),
+	}
+	server.HandleFunc(CallbackPath, w.callback)
+	logger.Info(fmt.Sprintf("wecom app channel enabled, callback url: %s", CallbackPath))
+	return nil
+}
+
+// Name 渠道名称
+func (w *Channel) Name() string {
+	return Name
+}
+
+// SelfName 应用消息没有@
+func (w *Channel) SelfName() string {
+	return ""
+}
+
+// ReplyText 通过应用消息接口回复文本
+func (w *Channel) ReplyText(msg *channel.Message, text string) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	return w.client.SendText(ctx, raw.FromUserName, text)
+}
+
+// ReplyImage 上传图片后通过应用消息接口回复
+func (w *Channel) ReplyImage(msg *channel.Message, image io.Reader) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(image)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	return w.client.SendImage(ctx, raw.FromUserName, data)
+}
+
+// AcceptFriend 应用没有好友申请
+func (w *Channel) AcceptFriend(msg *channel.Message) error {
+	return fmt.Errorf("%s channel has no friend requests", Name)
+}
+
+func rawMessage(msg *channel.Message) (*inboundMessage, error) {
+	raw, ok := msg.Raw.(*inboundMessage)
+	if !ok {
+		return nil, errors.New("not a wecom message")
+	}
+	return raw, nil
+}
+
+// callback GET 为配置回调地址时的校验, POST 为成员发送的消息
+func (w *Channel) callback(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signature, timestamp, nonce := query.Get("msg_signature"), query.Get("timestamp"), query.Get("nonce")
+
+	switch r.Method {
+	case http.MethodGet:
+		// 1.校验回调地址, 返回解密后的 echostr
+		echo := query.Get("echostr")
+		if !w.crypt.verify(signature, timestamp, nonce, echo) {
+			http.Error(rw, "invalid signature", http.StatusForbidden)
+			return
+		}
+		plaintext, err := w.crypt.decrypt(echo)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("wecom verify callback url error: %v", err))
+			http.Error(rw, "invalid echostr", http.StatusBadRequest)
+			return
+		}
+		_, _ = rw.Write(plaintext)
+	case http.MethodPost:
+		// 2.校验签名并解密消息
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<20))
+		if err != nil {
+			http.Error(rw, "read body error", http.StatusBadRequest)
+			return
+		}
+		var env envelope
+		if err = xml.Unmarshal(body, &env); err != nil {
+			http.Error(rw, "invalid xml", http.StatusBadRequest)
+			return
+		}
+		if !w.crypt.verify(signature, timestamp, nonce, env.Encrypt) {
+			http.Error(rw, "invalid signature", http.StatusForbidden)
+			return
+		}
+		plaintext, err := w.crypt.decrypt(env.Encrypt)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("wecom decrypt message error: %v", err))
+			http.Error(rw, "invalid message", http.StatusBadRequest)
+			return
+		}
+		var inbound inboundMessage
+		if err = xml.NewDecoder(bytes.NewReader(plaintext)).Decode(&inbound); err != nil {
+			logger.Warning(fmt.Sprintf("wecom parse message error: %v", err))
+			http.Error(rw, "invalid message", http.StatusBadRequest)
+			return
+		}
+
+		// 3.立即响应空串, 表示不被动回复, 在后台处理消息
+		rw.WriteHeader(http.StatusOK)
+		if w.duplicate(&inbound) {
+			return
+		}
+		go w.handle(&inbound)
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// duplicate 企业微信未及时收到响应时会重试, 相同的消息只处理一次
+func (w *Channel) duplicate(inbound *inboundMessage) bool {
+	key := inbound.MsgID
+	if key == "" {
+		key = fmt.Sprintf("%s:%d:%s", inbound.FromUserName, inbound.CreateTime, inbound.Event)
+	}
+	return w.seen.Add(key, struct{}{}, cache.DefaultExpiration) != nil
+}
+
+// handle 把消息转换为 channel.Message 交给处理器, 事件消息(如进入应用)不处理
+func (w *Channel) handle(inbound *inboundMessage) {
+	if inbound.MsgType == "event" {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			stats.IncError(stats.ErrorMessagePanic)
+			logger.Danger(fmt.Sprintf("handle wecom message panic: %v", err))
+		}
+	}()
+	sender := channel.Sender{ID: idPrefix + inbound.FromUserName, NickName: inbound.FromUserName}
+	w.handler(&channel.Message{
+		Channel:      w,
+		ID:           inbound.MsgID,
+		Type:         messageType(inbound.MsgType),
+		Content:      inbound.Content,
+		Time:         time.Unix(inbound.CreateTime, 0),
+		Sender:       sender,
+		Conversation: channel.Conversation{ID: sender.ID, Name: sender.NickName},
+		Raw:          inbound,
+	})
+}
+
+// messageType 企业微信的消息类型
+func messageType(msgType string) string {
+	switch msgType {
+	case "text":
+		return channel.TypeText
+	case "image":
+		return channel.TypePicture
+	case "voice":
+		return channel.TypeVoice
+	case "video":
+		return channel.TypeVideo
+	case "location":
+		return channel.TypeLocation
+	case "link":
+		return channel.TypeApp
+	default:
+		return channel.TypeOther
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultStorageFile wechat_storage_file 的默认值
+const DefaultStorageFile = "storage.json"
+
+// 个人微信的登录方式, 登录模式的区别: https://openwechat.readthedocs.io/zh/latest/bot.html
+const (
+	// LoginHot 热登录, 使用保存的登录信息, 不需要任何操作
+	LoginHot = "hot"
+	// LoginPush 免扫码登录, 使用保存的登录信息, 需要在手机上确认
+	LoginPush = "push"
+	// LoginQrCode 扫码登录, 二维码会打印到控制台并发送到告警渠道
+	LoginQrCode = "qr"
+)
+
+// 个人微信的客户端模式
+const (
+	// WechatModeDesktop 桌面微信模式, 网页版登录不上时使用
+	WechatModeDesktop = "desktop"
+	// WechatModeNormal 网页版微信模式
+	WechatModeNormal = "normal"
+)
+
+// accountNamePattern 账号名称用于会话 id 和文件名, 只允许字母, 数字, - 和 _
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// WechatAccount 个人微信账号, 多账号模式下每个账号独立登录, 独立存储登录信息, 会话互不影响
+type WechatAccount struct {
+	// 账号名称, 用于日志, 告警和会话 id 的命名空间, 单账号模式下为空
+	Name string `json:"name"`
+	// 热登录的存储文件, 默认为 wechat_storage_file 所在目录下的 storage-<name>.json
+	StorageFile string `json:"storage_file"`
+	// 设备 id, 为空时随机生成
+	DeviceId string `json:"device_id"`
+	// 该账号的会话没有匹配 bindings 时使用的 profile
+	Profile string `json:"profile"`
+	// 登录方式, 默认为 wechat_login_strategy
+	LoginStrategy []string `json:"login_strategy"`
+	// 客户端模式, 默认为 wechat_mode
+	Mode string `json:"mode"`
+}
+
+// Accounts 要登录的个人微信账号, 未配置 wechat_accounts 时为单账号模式, 只有一个名称为空的账号
+func (c *Configuration) Accounts() []WechatAccount {
+	if len(c.WechatAccounts) == 0 {
+		return []WechatAccount{{
+			StorageFile:   c.WechatStorageFile,
+			DeviceId:      c.DeviceId,
+			LoginStrategy: c.WechatLoginStrategy,
+			Mode:          c.WechatMode,
+		}}
+	}
+	accounts := make([]WechatAccount, 0, len(c.WechatAccounts))
+	for _, account := range c.WechatAccounts {
+		if account.StorageFile == "" {
+			account.StorageFile = filepath.Join(filepath.Dir(c.WechatStorageFile), "storage-"+account.Name+".json")
+		}
+		if len(account.LoginStrategy) == 0 {
+			account.LoginStrategy = c.WechatLoginStrategy
+		}
+		if account.Mode == "" {
+			account.Mode = c.WechatMode
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// MultiAccount 是否为多账号模式
+func (c *Configuration) MultiAccount() bool {
+	return len(c.WechatAccounts) > 0
+}
+
+// WithProfile 会话没有匹配的 binding 时应用 name 指定的 profile
+func (c *Configuration) WithProfile(settings Settings, name string) Settings {
+	profile, ok := c.Profiles[name]
+	if settings.Profile != "" || !ok {
+		return settings
+	}
+	settings.Profile = name
+	profile.applyTo(&settings)
+	return settings
+}
+
+// validateAccounts 校验个人微信的登录配置和 wechat_accounts, 账号名称和存储文件不能重复
+func (c *Configuration) validateAccounts(errs *ValidationErrors) {
+	validateLoginStrategy(errs, "wechat_login_strategy", c.WechatLoginStrategy)
+	validateWechatMode(errs, "wechat_mode", c.WechatMode)
+	if strings.TrimSpace(c.WechatStorageFile) == "" {
+		errs.add("wechat_storage_file", "must not be empty")
+	}
+	if !c.MultiAccount() {
+		return
+	}
+	names := make(map[string]bool)
+	storageFiles := make(map[string]bool)
+	for i, account := range c.Accounts() {
+		field := "wechat_accounts[" + strconv.Itoa(i) + "]"
+		if !accountNamePattern.MatchString(account.Name) {
+			errs.add(field+".name", "must be non-empty and contain only letters, digits, - and _, got %q", account.Name)
+		} else if names[account.Name] {
+			errs.add(field+".name", "duplicate account %q", account.Name)
+		}
+		names[account.Name] = true
+		if storageFiles[account.StorageFile] {
+			errs.add(field+".storage_file", "storage file %q is shared with another account", account.StorageFile)
+		}
+		storageFiles[account.StorageFile] = true
+		if _, ok := c.Profiles[account.Profile]; account.Profile != "" && !ok {
+			errs.add(field+".profile", "profile %q is not defined", account.Profile)
+		}
+		// 未配置时使用全局的登录方式和客户端模式, 已在上面校验
+		if len(c.WechatAccounts[i].LoginStrategy) > 0 {
+			validateLoginStrategy(errs, field+".login_strategy", account.LoginStrategy)
+		}
+		if c.WechatAccounts[i].Mode != "" {
+			validateWechatMode(errs, field+".mode", account.Mode)
+		}
+	}
+}
+
+// validateLoginStrategy 登录方式不能为空, 不能重复
+func validateLoginStrategy(errs *ValidationErrors, field string, strategy []string) {
+	if len(strategy) == 0 {
+		errs.add(field, "must not be empty, expected hot, push or qr in the order to try")
+		return
+	}
+	seen := make(map[string]bool)
+	for _, method := range strategy {
+		if method != LoginHot && method != LoginPush && method != LoginQrCode {
+			errs.add(field, "unknown login method %q, expected hot, push or qr", method)
+		} else if seen[method] {
+			errs.add(field, "duplicate login method %q", method)
+		}
+		seen[method] = true
+	}
+}
+
+// validateWechatMode 客户端模式只能是 desktop 或 normal
+func validateWechatMode(errs *ValidationErrors, field, mode string) {
+	if mode != WechatModeDesktop && mode != WechatModeNormal {
+		errs.add(field, "unknown mode %q, expected desktop or normal", mode)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// defaultAlertTemplates 各告警事件的默认模板, 可用变量见 alert.Data
+var defaultAlertTemplates = map[string]string{
+	"started":         `[{{.Instance}}] wechat-gpt {{.Version}} has started on {{.Hostname}}`,
+	"logged_out":      `[{{.Instance}}] wechat-gpt has logged out after {{.Uptime}}, trying to login again{{if .LastError}}, last error: {{.LastError}}{{end}}`,
+	"reconnected":     `[{{.Instance}}] wechat-gpt is back online after {{.Downtime}} offline, reconnected {{.Reconnects}} times`,
+	"relogin_stopped": `[{{.Instance}}] wechat-gpt stopped trying to login again{{if .LastError}}: {{.LastError}}{{end}}, restart the service to login`,
+	"dead":            `[{{.Instance}}] wechat-gpt is dead after {{.Uptime}}, received {{.MessagesReceived}} messages and sent {{.RepliesSent}} replies{{if .LastError}}, last error: {{.LastError}}{{end}}`,
+	"panic":           `[{{.Instance}}] wechat-gpt panicked after {{.Uptime}}: {{.LastError}}`,
+	"quota_exhausted": `[{{.Instance}}] openai api key {{.Key}} exceeded its quota and is benched until {{.Until.Format "2006-01-02 15:04:05"}}: {{.LastError}}`,
+	"key_revoked":     `[{{.Instance}}] openai api key {{.Key}} is revoked and benched until restart: {{.LastError}}`,
+	"login_qrcode":    `[{{.Instance}}] wechat-gpt needs to login, please scan the QR code with WeChat{{if .QrCodePage}}, or open {{.QrCodePage}}{{end}}`,
+	"usage_report":    "[{{.Instance}}] {{.Report}}",
+}
+
+// defaultAlertTemplatesCopy 默认模板的副本, 配置文件中的模板会覆盖同名事件
+func defaultAlertTemplatesCopy() map[string]string {
+	templates := make(map[string]string, len(defaultAlertTemplates))
+	for event, text := range defaultAlertTemplates {
+		templates[event] = text
+	}
+	return templates
+}
+
+// validateAlertTemplates 校验告警模板的事件名称和语法
+func (c *Configuration) validateAlertTemplates(errs *ValidationErrors) {
+	events := make([]string, 0, len(defaultAlertTemplates))
+	for event := range defaultAlertTemplates {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	for event, text := range c.AlertTemplates {
+		field := "alert_templates." + event
+		if _, ok := defaultAlertTemplates[event]; !ok {
+			errs.add(field, "unknown event, expected one of: %s", strings.Join(events, ", "))
+			continue
+		}
+		if _, err := template.New(event).Parse(text); err != nil {
+			errs.add(field, "%v", err)
+		}
+	}
+}
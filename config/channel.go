@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// validateWecomApp 校验企业微信自建应用渠道, 未配置企业 id 时不校验
+func (c *Configuration) validateWecomApp(errs *ValidationErrors) {
+	if c.WecomAppCorpID == "" {
+		return
+	}
+	if c.WecomAppAgentID == 0 {
+		errs.add("wecom_app_agent_id", "must be set when wecom_app_corp_id is set")
+	}
+	if c.WecomAppSecret == "" {
+		errs.add("wecom_app_secret", "must be set when wecom_app_corp_id is set")
+	}
+	if c.WecomAppToken == "" {
+		errs.add("wecom_app_token", "must be set when wecom_app_corp_id is set")
+	}
+	if key, err := base64.StdEncoding.DecodeString(c.WecomAppAESKey + "="); len(c.WecomAppAESKey) != 43 || err != nil || len(key) != 32 {
+		errs.add("wecom_app_aes_key", "must be the 43 characters EncodingAESKey of the app")
+	}
+	if c.HttpAddr == "" {
+		errs.add("wecom_app_corp_id", "wecom app channel requires http_addr to receive callbacks")
+	}
+}
+
+// validateDingtalk 校验钉钉机器人渠道, 未配置 AppSecret 时不校验
+func (c *Configuration) validateDingtalk(errs *ValidationErrors) {
+	if c.DingtalkAppSecret != "" && c.HttpAddr == "" {
+		errs.add("dingtalk_app_secret", "dingtalk channel requires http_addr to receive callbacks")
+	}
+}
+
+// validateFeishu 校验飞书机器人渠道, 未配置 App ID 时不校验
+func (c *Configuration) validateFeishu(errs *ValidationErrors) {
+	if c.FeishuAppID == "" {
+		return
+	}
+	if c.FeishuAppSecret == "" {
+		errs.add("feishu_app_secret", "must be set when feishu_app_id is set")
+	}
+	if c.FeishuVerificationToken == "" {
+		errs.add("feishu_verification_token", "must be set when feishu_app_id is set")
+	}
+	if c.HttpAddr == "" {
+		errs.add("feishu_app_id", "feishu channel requires http_addr to receive events")
+	}
+}
+
+// validateTelegram 校验 Telegram 渠道, 未配置 bot token 时不校验
+func (c *Configuration) validateTelegram(errs *ValidationErrors) {
+	if c.TelegramBotToken == "" {
+		return
+	}
+	if err := validateURL(c.TelegramApiURL); err != nil {
+		errs.add("telegram_api_url", "%v", err)
+	}
+	if !c.TelegramWebhook {
+		return
+	}
+	if !strings.HasPrefix(c.PublicURL, "https://") {
+		errs.add("telegram_webhook", "telegram webhooks require an https public_url")
+	}
+	if c.HttpAddr == "" {
+		errs.add("telegram_webhook", "telegram webhooks require http_addr to receive updates")
+	}
+}
@@ -1,142 +1,367 @@
 package config
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
-	"log"
+	"io/ioutil"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/notifier"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
+	"gopkg.in/yaml.v3"
 )
 
-// Configuration 项目配置
+// Configuration 项目配置, 环境变量和命令行参数的规则见 loader.go
 type Configuration struct {
 	// gpt apikey
-	ApiKey string `json:"api_key"`
+	ApiKey string `json:"api_key" legacy_env:"APIKEY" secret:"true" usage:"openai api key"`
+	// 从文件读取 gpt apikey, 每行一个, 适用于 Docker/K8s secrets
+	ApiKeyFile string `json:"api_key_file" usage:"file containing openai api keys, one per line"`
+	// 多个 gpt apikey 组成的 key 池
+	ApiKeys []string `json:"api_keys" secret:"true" usage:"comma separated openai api keys"`
+	// key 池的轮换策略: round_robin, least_used
+	ApiKeyStrategy string `json:"api_key_strategy" usage:"api key rotation strategy, round_robin or least_used"`
+	// key 额度用尽后暂停使用的时长, 失效(401)的 key 会一直暂停直到重启
+	ApiKeyCooldown Duration `json:"api_key_cooldown" usage:"how long a key is benched after insufficient_quota"`
 	// 自动通过好友
-	AutoPass bool `json:"auto_pass"`
+	AutoPass bool `json:"auto_pass" legacy_env:"AUTO_PASS" usage:"accept friend requests automatically"`
 	// 会话超时时间
-	SessionTimeout time.Duration `json:"session_timeout"`
+	SessionTimeout Duration `json:"session_timeout" legacy_env:"SESSION_TIMEOUT" usage:"conversation context timeout, seconds or duration such as 60s"`
 	// GPT请求最大字符数
-	MaxTokens uint `json:"max_tokens"`
+	MaxTokens uint `json:"max_tokens" legacy_env:"MAX_TOKENS" usage:"max tokens of each completion"`
 	// GPT模型
-	Model string `json:"model"`
+	Model string `json:"model" legacy_env:"MODEL" usage:"gpt model"`
 	// 热度
-	Temperature float64 `json:"temperature"`
+	Temperature float64 `json:"temperature" legacy_env:"TEMPREATURE" usage:"sampling temperature, 0 to 2"`
 	// 回复前缀
-	ReplyPrefix string `json:"reply_prefix"`
+	ReplyPrefix string `json:"reply_prefix" legacy_env:"REPLY_PREFIX" usage:"prefix of private replies"`
 	// 清空会话口令
-	SessionClearToken string `json:"session_clear_token"`
+	SessionClearToken string `json:"session_clear_token" legacy_env:"SESSION_CLEAR_TOKEN" usage:"message that clears the conversation context"`
 	// 设备id
-	DeviceId string `json:"device_id"`
+	DeviceId string `json:"device_id" legacy_env:"DEVICE_ID" usage:"wechat device id"`
 	// 企业微信告警的 sendKey
-	WechatWorkSendKey string `json:"wechat_work_send_key"`
+	WechatWorkSendKey string `json:"wechat_work_send_key" legacy_env:"WechatWorkSendKey" secret:"true" usage:"wecom group robot webhook key for alerts"`
+	// 内置 http 服务的监听地址, 如 :8090, 为空时不启动
+	HttpAddr string `json:"http_addr" usage:"listen address of the built-in http server, such as :8090, empty to disable"`
+	// 内置 http 服务的外部访问地址, 用于告警中的链接, 如 https://bot.example.com
+	PublicURL string `json:"public_url" usage:"public base url of the built-in http server, used in alert links"`
+	// 管理后台的 token, 为空时不启用管理后台
+	AdminToken string `json:"admin_token" secret:"true" usage:"token of the admin console at /admin, empty to disable"`
+	// 告警渠道, 多个渠道同时发送
+	Notifiers []notifier.Config `json:"notifiers"`
+	// 实例名称, 用于区分告警来源, 默认为主机名
+	InstanceName string `json:"instance_name" usage:"instance name shown in alerts, defaults to hostname"`
+	// 告警模板, 按事件名称覆盖默认模板, 使用 text/template 语法
+	AlertTemplates map[string]string `json:"alert_templates"`
+	// 从文件读取企业微信告警的 sendKey
+	WechatWorkSendKeyFile string `json:"wechat_work_send_key_file" usage:"file containing the wecom webhook key"`
 	// openai 的 api proxy 域名
-	ApiProxyHost string `json:"api_proxy_host"`
+	ApiProxyHost string `json:"api_proxy_host" legacy_env:"ApiProxyHost" usage:"openai api proxy base url"`
+	// 会话级配置, 见 profile.go
+	Profiles map[string]Profile `json:"profiles"`
+	// 群和用户到 profile 的映射
+	Bindings []ProfileBinding `json:"bindings"`
+	// 人设文件目录, 目录下每个 json/yaml/toml 文件为一个人设, 见 persona.go
+	PersonaDir string `json:"persona_dir" usage:"directory of persona files"`
+	// 直接写在配置文件中的人设, 与 persona_dir 中的人设合并
+	Personas map[string]Persona `json:"personas"`
+	// 人设口令, 发送 "口令" 查看人设列表, "口令 名称" 切换人设, "口令 reset" 恢复默认
+	PersonaCommand string `json:"persona_command" usage:"chat command to list and switch personas"`
+	// 管理员, 写带渠道前缀的完整用户 id, 如 telegram:123456. 单账号微信的 id 没有前缀, 见 IsAdmin
+	Admins []string `json:"admins" usage:"comma separated user ids of admins, including the channel prefix"`
+	// 用量记录文件, 每次请求追加一行 JSON, 为空时不记录
+	UsageFile string `json:"usage_file" usage:"file that usage records are appended to, empty to disable"`
+	// 各模型的价格, 见 usage.go
+	Prices map[string]Price `json:"prices"`
+	// 价格的货币单位
+	Currency string `json:"currency" usage:"currency of prices, shown in reports"`
+	// 用量报告口令, 管理员发送 "口令 daily|weekly|monthly" 查看报告
+	UsageCommand string `json:"usage_command" usage:"chat command for admins to get usage reports"`
+	// 定时通过告警渠道发送的用量报告: daily, weekly, monthly
+	UsageReports []string `json:"usage_reports" usage:"comma separated periods of scheduled usage reports, daily, weekly or monthly"`
+	// 定时报告的发送时间, 周报在周一发送, 月报在每月 1 日发送
+	UsageReportTime string `json:"usage_report_time" usage:"time of day to send scheduled usage reports, HH:MM"`
+	// 日志的最低级别: debug, info, warning, error
+	LogLevel string `json:"log_level" usage:"minimum log level, debug, info, warning or error"`
+	// 日志格式: console, json
+	LogFormat string `json:"log_format" usage:"log format, console or json"`
+	// 日志文件, 为空时输出到标准输出
+	LogFile string `json:"log_file" usage:"log file path, empty to log to stdout"`
+	// 配置了日志文件时是否同时输出到标准输出
+	LogStdout bool `json:"log_stdout" usage:"also log to stdout when log_file is set"`
+	// 单个日志文件的最大大小, 单位 MB, 超过后切割, 0 表示不按大小切割
+	LogMaxSize uint `json:"log_max_size" usage:"max size in MB of a log file before it is rotated, 0 to disable"`
+	// 按时间切割日志的周期, 0 表示不按时间切割
+	LogRotateInterval Duration `json:"log_rotate_interval" usage:"rotate the log file every interval such as 24h, 0 to disable"`
+	// 保留的历史日志文件数, 0 表示全部保留
+	LogMaxBackups uint `json:"log_max_backups" usage:"number of rotated log files to keep, 0 to keep all"`
+	// 日志的隐私级别: basic 只遮盖密钥, standard 同时遮盖手机号和身份证号, strict 同时对用户身份做哈希并丢弃消息正文
+	PrivacyLevel string `json:"privacy_level" usage:"privacy level of logs, basic, standard or strict"`
+	// 会话审计文件, 每条收到的消息及 prompt, 模型参数, 回复追加一行 JSON, 为空时不记录
+	AuditFile string `json:"audit_file" usage:"file that conversation audit records are appended to, empty to disable"`
+	// http 对话接口的 token, 调用时放在 Authorization: Bearer 请求头中, 为空时不启用接口
+	ApiTokens []string `json:"api_tokens" secret:"true" usage:"comma separated tokens of the http chat api, empty to disable"`
+	// 是否登录个人微信, 只使用企业微信等其他渠道时关闭
+	WechatEnabled bool `json:"wechat_enabled" usage:"log in to the personal wechat account, disable to run other channels only"`
+	// 个人微信的登录方式, 按顺序尝试, 失败时使用下一种: hot 热登录, push 免扫码登录, qr 扫码登录
+	WechatLoginStrategy []string `json:"wechat_login_strategy" usage:"comma separated wechat login methods tried in order, hot, push or qr"`
+	// 个人微信的客户端模式: desktop 桌面微信, normal 网页版微信
+	WechatMode string `json:"wechat_mode" usage:"wechat client mode, desktop or normal"`
+	// 保存个人微信登录信息的文件, 用于热登录和免扫码登录
+	WechatStorageFile string `json:"wechat_storage_file" usage:"file that wechat login info is saved to for hot and push login"`
+	// 同时登录的多个个人微信账号, 为空时只登录一个账号, 使用 wechat_storage_file 和 device_id
+	WechatAccounts []WechatAccount `json:"wechat_accounts"`
+	// 企业微信自建应用的企业 id, 为空时不启用企业微信应用渠道, 回调地址为 /wecom/callback
+	WecomAppCorpID string `json:"wecom_app_corp_id" usage:"corp id of the wecom self-built app channel, empty to disable"`
+	// 企业微信自建应用的 AgentId
+	WecomAppAgentID int64 `json:"wecom_app_agent_id" usage:"agent id of the wecom self-built app"`
+	// 企业微信自建应用的 Secret, 用于获取 access_token
+	WecomAppSecret string `json:"wecom_app_secret" secret:"true" usage:"secret of the wecom self-built app"`
+	// 企业微信自建应用接收消息的 Token, 用于校验回调签名
+	WecomAppToken string `json:"wecom_app_token" secret:"true" usage:"callback token of the wecom self-built app"`
+	// 企业微信自建应用接收消息的 EncodingAESKey, 用于解密回调消息
+	WecomAppAESKey string `json:"wecom_app_aes_key" secret:"true" usage:"callback EncodingAESKey of the wecom self-built app"`
+	// 钉钉企业内部机器人的 AppSecret, 用于校验回调签名, 为空时不启用钉钉渠道, 回调地址为 /dingtalk/callback
+	DingtalkAppSecret string `json:"dingtalk_app_secret" secret:"true" usage:"app secret of the dingtalk robot channel, empty to disable"`
+	// 飞书自建应用的 App ID, 为空时不启用飞书渠道, 事件订阅的请求地址为 /feishu/callback
+	FeishuAppID string `json:"feishu_app_id" usage:"app id of the feishu bot channel, empty to disable"`
+	// 飞书自建应用的 App Secret, 用于获取 tenant_access_token
+	FeishuAppSecret string `json:"feishu_app_secret" secret:"true" usage:"app secret of the feishu bot"`
+	// 飞书事件订阅的 Verification Token
+	FeishuVerificationToken string `json:"feishu_verification_token" secret:"true" usage:"event subscription verification token of the feishu bot"`
+	// 飞书事件订阅的 Encrypt Key, 为空时事件不加密
+	FeishuEncryptKey string `json:"feishu_encrypt_key" secret:"true" usage:"event subscription encrypt key of the feishu bot, empty if events are not encrypted"`
+	// Telegram 机器人的 token, 为空时不启用 Telegram 渠道
+	TelegramBotToken string `json:"telegram_bot_token" secret:"true" usage:"bot token of the telegram channel, empty to disable"`
+	// 是否通过 webhook 接收 Telegram 消息, 地址为 <public_url>/telegram/webhook, 关闭时使用长轮询
+	TelegramWebhook bool `json:"telegram_webhook" usage:"receive telegram updates by webhook at <public_url>/telegram/webhook instead of long polling"`
+	// Telegram Bot API 地址, 可以换成反向代理或自建的 Bot API 服务
+	TelegramApiURL string `json:"telegram_api_url" usage:"telegram bot api base url"`
 }
 
 var config *Configuration
 var once sync.Once
 
-// LoadConfig 加载配置
+// configLock 保护热更新时替换 config
+var configLock sync.RWMutex
+
+// configFile 生效的配置文件路径
+var configFile string
+
+// args LoadConfig 解析的命令行参数
+var args = os.Args[1:]
+
+// SetArgs 替换 LoadConfig 解析的命令行参数, 子命令去掉自己的参数后调用, 必须在第一次 LoadConfig 之前调用
+func SetArgs(commandLineArgs []string) {
+	args = commandLineArgs
+}
+
+// SplitSubcommandArgs 按 -- 拆分子命令的参数, 之前为子命令自己的参数, 之后为配置参数
+func SplitSubcommandArgs(commandLineArgs []string) (subcommandArgs, configArgs []string) {
+	for i, arg := range commandLineArgs {
+		if arg == "--" {
+			return commandLineArgs[:i], commandLineArgs[i+1:]
+		}
+	}
+	return commandLineArgs, nil
+}
+
+// LoadConfig 加载配置, 配置不合法时一次性输出全部错误后退出
 func LoadConfig() *Configuration {
 	once.Do(func() {
-		// 给配置赋默认值
-		config = &Configuration{
-			AutoPass:          false,
-			SessionTimeout:    60,
-			MaxTokens:         512,
-			Model:             "text-davinci-003",
-			Temperature:       0.9,
-			SessionClearToken: "下一个问题",
-			DeviceId:          "",
-			WechatWorkSendKey: "",
-			ApiProxyHost: "",
+		l := newLoader(args)
+		cfg, err := l.load()
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
 		}
-
-		// 判断配置文件是否存在，存在直接JSON读取
-		_, err := os.Stat("config.json")
-		if err == nil {
-			f, err := os.Open("config.json")
-			if err != nil {
-				log.Fatalf("open config err: %v", err)
-				return
-			}
-			defer f.Close()
-			encoder := json.NewDecoder(f)
-			err = encoder.Decode(config)
-			if err != nil {
-				log.Fatalf("decode config err: %v", err)
-				return
-			}
+		if l.printConfig && cfg != nil {
+			l.print(os.Stdout, cfg)
 		}
-		// 有环境变量使用环境变量
-		ApiKey := os.Getenv("APIKEY")
-		AutoPass := os.Getenv("AUTO_PASS")
-		SessionTimeout := os.Getenv("SESSION_TIMEOUT")
-		Model := os.Getenv("MODEL")
-		MaxTokens := os.Getenv("MAX_TOKENS")
-		Temperature := os.Getenv("TEMPREATURE")
-		ReplyPrefix := os.Getenv("REPLY_PREFIX")
-		SessionClearToken := os.Getenv("SESSION_CLEAR_TOKEN")
-		DeviceId := os.Getenv("DEVICE_ID")
-		WechatWorkSendKey := os.Getenv("WechatWorkSendKey")
-		ApiProxyHost := os.Getenv("ApiProxyHost")
-		if ApiKey != "" {
-			config.ApiKey = ApiKey
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		if AutoPass == "true" {
-			config.AutoPass = true
+		if l.printConfig {
+			os.Exit(0)
 		}
-		if SessionTimeout != "" {
-			duration, err := time.ParseDuration(SessionTimeout)
-			if err != nil {
-				logger.Danger(fmt.Sprintf("config session timeout err: %v ,get is %v", err, SessionTimeout))
-				return
-			}
-			config.SessionTimeout = duration
+		config = cfg
+		configFile = l.configFile
+	})
+
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return config
+}
+
+// Load 按 默认值 -> 配置文件 -> 环境变量 -> 命令行参数 的顺序读取配置并校验, 不会中途退出
+func Load(args []string) (*Configuration, error) {
+	cfg, err := newLoader(args).load()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// key 池的轮换策略
+const (
+	// KeyStrategyRoundRobin 依次轮换
+	KeyStrategyRoundRobin = "round_robin"
+	// KeyStrategyLeastUsed 优先使用调用次数最少的 key
+	KeyStrategyLeastUsed = "least_used"
+)
+
+// AllApiKeys 合并 api_key, api_keys 和 api_key_file 中的全部 key, 去重并保持顺序
+func (c *Configuration) AllApiKeys() []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, key := range append([]string{c.ApiKey}, c.ApiKeys...) {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			continue
 		}
-		if Model != "" {
-			config.Model = Model
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ValidAdminToken token 是否为管理员 token, 未配置 admin_token 时始终返回 false
+func (c *Configuration) ValidAdminToken(token string) bool {
+	return c.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) == 1
+}
+
+// resolveSecretFiles 读取 *_file 配置项指向的密钥文件
+func (c *Configuration) resolveSecretFiles(errs *ValidationErrors) {
+	if c.ApiKeyFile != "" {
+		content, err := ioutil.ReadFile(c.ApiKeyFile)
+		if err != nil {
+			errs.add("api_key_file", "%v", err)
 		}
-		if MaxTokens != "" {
-			max, err := strconv.Atoi(MaxTokens)
-			if err != nil {
-				logger.Danger(fmt.Sprintf("config MaxTokens err: %v ,get is %v", err, MaxTokens))
-				return
+		for _, line := range strings.Split(string(content), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				c.ApiKeys = append(c.ApiKeys, line)
 			}
-			config.MaxTokens = uint(max)
 		}
-		if Temperature != "" {
-			temp, err := strconv.ParseFloat(Temperature, 64)
-			if err != nil {
-				logger.Danger(fmt.Sprintf("config Temperature err: %v ,get is %v", err, Temperature))
-				return
-			}
-			config.Temperature = temp
+	}
+	if c.WechatWorkSendKeyFile != "" {
+		content, err := ioutil.ReadFile(c.WechatWorkSendKeyFile)
+		if err != nil {
+			errs.add("wechat_work_send_key_file", "%v", err)
 		}
-		if ReplyPrefix != "" {
-			config.ReplyPrefix = ReplyPrefix
+		c.WechatWorkSendKey = strings.TrimSpace(string(content))
+	}
+}
+
+// NotifierConfigs 生效的告警渠道, 配置了 wechat_work_send_key 且 notifiers 中没有相同 key 的企业微信渠道时, 自动追加一个
+func (c *Configuration) NotifierConfigs() []notifier.Config {
+	configs := append([]notifier.Config{}, c.Notifiers...)
+	if c.WechatWorkSendKey == "" {
+		return configs
+	}
+	for _, n := range configs {
+		if n.Type == notifier.TypeWeCom && n.Key == c.WechatWorkSendKey {
+			return configs
 		}
-		if SessionClearToken != "" {
-			config.SessionClearToken = SessionClearToken
+	}
+	return append(configs, notifier.Config{Type: notifier.TypeWeCom, Key: c.WechatWorkSendKey})
+}
+
+// defaultConfiguration 配置默认值
+func defaultConfiguration() *Configuration {
+	return &Configuration{
+		AutoPass:            false,
+		ApiKeyStrategy:      KeyStrategyRoundRobin,
+		ApiKeyCooldown:      Duration{time.Hour},
+		SessionTimeout:      Duration{60 * time.Second},
+		MaxTokens:           512,
+		Model:               "text-davinci-003",
+		Temperature:         0.9,
+		SessionClearToken:   "下一个问题",
+		AlertTemplates:      defaultAlertTemplatesCopy(),
+		PersonaDir:          "personas",
+		PersonaCommand:      "/persona",
+		UsageFile:           "usage.jsonl",
+		Prices:              defaultPricesCopy(),
+		Currency:            "USD",
+		UsageCommand:        "/usage",
+		UsageReportTime:     "09:00",
+		LogLevel:            "info",
+		LogFormat:           logger.FormatConsole,
+		LogMaxSize:          100,
+		LogRotateInterval:   Duration{24 * time.Hour},
+		LogMaxBackups:       7,
+		PrivacyLevel:        redact.LevelStandard,
+		WechatEnabled:       true,
+		WechatLoginStrategy: []string{LoginHot, LoginPush, LoginQrCode},
+		WechatMode:          WechatModeDesktop,
+		WechatStorageFile:   DefaultStorageFile,
+		TelegramApiURL:      "https://api.telegram.org",
+		DeviceId:            "",
+		WechatWorkSendKey:   "",
+		ApiProxyHost:        "",
+	}
+}
+
+// LogOptions 日志配置
+func (c *Configuration) LogOptions() logger.Options {
+	level, _ := logger.ParseLevel(c.LogLevel)
+	return logger.Options{
+		Level:          level,
+		Format:         c.LogFormat,
+		File:           c.LogFile,
+		Stdout:         c.LogStdout,
+		MaxSize:        int64(c.LogMaxSize) * 1024 * 1024,
+		RotateInterval: c.LogRotateInterval.Duration,
+		MaxBackups:     int(c.LogMaxBackups),
+	}
+}
+
+// configFileCandidates 未指定配置文件时, 依次查找的文件
+var configFileCandidates = []string{DefaultConfigFile, "config.yaml", "config.yml", "config.toml"}
+
+// decodeConfigFile 按扩展名读取 JSON, YAML 或 TOML 文件到 v, 返回文件中出现过的配置项.
+// YAML 和 TOML 先转换为 JSON 再解析, 因此三种格式共用 json tag 作为字段名
+func decodeConfigFile(path string, v interface{}) (map[string]json.RawMessage, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open config err: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var raw map[string]interface{}
+		if err := yaml.Unmarshal(content, &raw); err != nil {
+			return nil, fmt.Errorf("decode yaml config err: %v", err)
 		}
-		if DeviceId != "" {
-			config.DeviceId = DeviceId
+		if content, err = json.Marshal(raw); err != nil {
+			return nil, fmt.Errorf("decode yaml config err: %v", err)
 		}
-		if WechatWorkSendKey != "" {
-			config.WechatWorkSendKey = WechatWorkSendKey
+	case ".toml":
+		var raw map[string]interface{}
+		if err := toml.Unmarshal(content, &raw); err != nil {
+			return nil, fmt.Errorf("decode toml config err: %v", err)
 		}
-		if ApiProxyHost != "" {
-			config.ApiProxyHost = ApiProxyHost
+		if content, err = json.Marshal(raw); err != nil {
+			return nil, fmt.Errorf("decode toml config err: %v", err)
 		}
-
-	})
-	if config.ApiKey == "" {
-		logger.Danger("config err: api key required")
 	}
 
-	return config
+	present := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &present); err != nil {
+		return nil, fmt.Errorf("decode config err: %v", err)
+	}
+	if err := json.NewDecoder(bytes.NewReader(content)).Decode(v); err != nil {
+		return present, fmt.Errorf("decode config err: %v", err)
+	}
+	return present, nil
 }
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration 时长配置, 配置文件中可写秒数(60)或时长字符串("60s"), 环境变量和命令行参数同理
+type Duration struct {
+	time.Duration
+}
+
+// Set 解析时长, 纯数字按秒计算
+func (d *Duration) Set(raw string) error {
+	if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, use seconds (60) or a duration (60s, 5m)", raw)
+	}
+	d.Duration = duration
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	switch value := raw.(type) {
+	case float64:
+		d.Duration = time.Duration(value * float64(time.Second))
+		return nil
+	case string:
+		return d.Set(value)
+	default:
+		return fmt.Errorf("invalid duration %s", string(b))
+	}
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
@@ -0,0 +1,352 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+)
+
+// EnvPrefix 所有环境变量的统一前缀
+const EnvPrefix = "WECHATBOT_"
+
+// DefaultConfigFile 默认配置文件路径
+const DefaultConfigFile = "config.json"
+
+// 配置的生效优先级从低到高依次为:
+//  1. 默认值
+//  2. 配置文件, 路径由 --config 或 WECHATBOT_CONFIG 指定, 默认依次查找 config.json, config.yaml, config.yml, config.toml
+//  3. 环境变量, 名称为 WECHATBOT_ + json tag 的大写形式, 如 WECHATBOT_API_KEY
+//  4. 命令行参数, 名称为 json tag 中的下划线替换为中划线, 如 --api-key
+//
+// 字段上的 legacy_env tag 是旧版本的环境变量名, 仍然兼容, 但优先级低于新名称.
+// 字段上的 secret tag 表示敏感配置, --print-config 时会被打码.
+
+// field 一个可通过环境变量和命令行参数设置的配置项
+type field struct {
+	index     []int
+	name      string
+	env       string
+	legacyEnv string
+	flag      string
+	usage     string
+	secret    bool
+}
+
+// durationType 需要按 Set 方法解析的结构体类型
+var durationType = reflect.TypeOf(Duration{})
+
+// stringSliceType 字符串列表, 环境变量和命令行参数中以逗号分隔
+var stringSliceType = reflect.TypeOf([]string{})
+
+// fields 从 Configuration 的 struct tag 生成配置项列表
+func fields() []field {
+	var result []field
+	t := reflect.TypeOf(Configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || !isScalar(structField.Type) {
+			continue
+		}
+		result = append(result, field{
+			index:     structField.Index,
+			name:      name,
+			env:       EnvPrefix + strings.ToUpper(name),
+			legacyEnv: structField.Tag.Get("legacy_env"),
+			flag:      strings.ReplaceAll(name, "_", "-"),
+			usage:     structField.Tag.Get("usage"),
+			secret:    structField.Tag.Get("secret") == "true",
+		})
+	}
+	return result
+}
+
+// configKeys 配置文件中可以出现的全部顶层配置项, 包括 profiles 等非标量配置
+func configKeys() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(Configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+func isScalar(t reflect.Type) bool {
+	if t == durationType || t == stringSliceType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// setValue 将字符串解析后写入配置项
+func setValue(v reflect.Value, raw string) error {
+	if setter, ok := v.Addr().Interface().(interface{ Set(string) error }); ok {
+		return setter.Set(raw)
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid positive integer %q", raw)
+		}
+		v.SetUint(u)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// loader 按优先级合并各来源的配置, 并记录每个配置项的来源
+type loader struct {
+	args       []string
+	configFile string
+	// 配置文件由 --config 或环境变量明确指定, 此时文件必须存在
+	explicitConfigFile bool
+	// 热更新时校验的临时配置文件, 优先于命令行参数中的配置文件
+	overrideConfigFile string
+	printConfig        bool
+	flagValues         map[string]string
+	sources            map[string]string
+}
+
+func newLoader(args []string) *loader {
+	return &loader{
+		args:       args,
+		configFile: DefaultConfigFile,
+		flagValues: map[string]string{},
+		sources:    map[string]string{},
+	}
+}
+
+// parseFlags 解析命令行参数, 先暂存, 在环境变量之后才写入配置
+func (l *loader) parseFlags() error {
+	fs := flag.NewFlagSet("wechatbot", flag.ContinueOnError)
+	fs.StringVar(&l.configFile, "config", l.configFile, "config file path (env "+EnvPrefix+"CONFIG)")
+	fs.BoolVar(&l.printConfig, "print-config", false, "print the effective config with secrets masked, then exit")
+	for _, f := range fields() {
+		isBool := reflect.TypeOf(Configuration{}).FieldByIndex(f.index).Type.Kind() == reflect.Bool
+		fs.Var(&flagValue{name: f.name, values: l.flagValues, isBool: isBool}, f.flag, fmt.Sprintf("%s (env %s)", f.usage, f.env))
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of wechatbot:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nPrecedence: defaults < config file < env < flags\n")
+	}
+
+	// 配置文件路径也可以由环境变量指定, 命令行参数优先
+	if path := os.Getenv(EnvPrefix + "CONFIG"); path != "" {
+		l.configFile = path
+		l.explicitConfigFile = true
+	}
+	if err := fs.Parse(l.args); err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			l.explicitConfigFile = true
+		}
+	})
+	return nil
+}
+
+// load 读取全部来源的配置并校验, 配置不合法时同时返回配置和全部错误
+func (l *loader) load() (*Configuration, error) {
+	if err := l.parseFlags(); err != nil {
+		return nil, err
+	}
+	if l.overrideConfigFile != "" {
+		l.configFile = l.overrideConfigFile
+		l.explicitConfigFile = true
+	}
+
+	var errs ValidationErrors
+	cfg := defaultConfiguration()
+	for _, f := range fields() {
+		l.sources[f.name] = "default"
+	}
+
+	// 配置文件, 未指定时依次查找 config.json, config.yaml, config.yml, config.toml, 都不存在时只使用其他来源
+	if !l.explicitConfigFile {
+		for _, candidate := range configFileCandidates {
+			if _, err := os.Stat(candidate); err == nil {
+				l.configFile = candidate
+				break
+			}
+		}
+	}
+	if _, err := os.Stat(l.configFile); err == nil {
+		present, err := decodeConfigFile(l.configFile, cfg)
+		if err != nil {
+			errs.add(l.configFile, "%v", err)
+		}
+		known := configKeys()
+		names := make([]string, 0, len(present))
+		for name := range present {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			// 拼错的配置项会被静默忽略, 按错误报告
+			if !known[name] {
+				errs.add(name, "unknown key in config file %s", l.configFile)
+				continue
+			}
+			l.sources[name] = "file " + l.configFile
+		}
+	} else if l.explicitConfigFile {
+		errs.add("config", "config file %q not found", l.configFile)
+	}
+
+	v := reflect.ValueOf(cfg).Elem()
+	for _, f := range fields() {
+		// 环境变量, 新名称优先于旧名称
+		envName, raw := f.env, os.Getenv(f.env)
+		if raw == "" && f.legacyEnv != "" {
+			if raw = os.Getenv(f.legacyEnv); raw != "" {
+				envName = f.legacyEnv
+				logger.Warning(fmt.Sprintf("env %s is deprecated, use %s instead", f.legacyEnv, f.env))
+			}
+		}
+		if raw != "" {
+			if err := setValue(v.FieldByIndex(f.index), raw); err != nil {
+				errs.add(f.name, "env %s: %v", envName, err)
+			} else {
+				l.sources[f.name] = "env " + envName
+			}
+		}
+
+		// 命令行参数
+		if raw, ok := l.flagValues[f.name]; ok {
+			if err := setValue(v.FieldByIndex(f.index), raw); err != nil {
+				errs.add(f.name, "flag --%s: %v", f.flag, err)
+			} else {
+				l.sources[f.name] = "flag --" + f.flag
+			}
+		}
+	}
+
+	// 读取密钥文件和人设文件, 校验最终生效的配置, 与读取阶段的错误一并返回
+	cfg.resolveSecretFiles(&errs)
+	cfg.loadPersonaDir(&errs)
+	cfg.validate(&errs)
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+// print 输出生效的配置及其来源, 敏感配置打码
+func (l *loader) print(w io.Writer, cfg *Configuration) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tVALUE\tSOURCE")
+	v := reflect.ValueOf(cfg).Elem()
+	for _, f := range fields() {
+		fieldValue := v.FieldByIndex(f.index).Interface()
+		value := fmt.Sprintf("%v", fieldValue)
+		if f.secret {
+			if items, ok := fieldValue.([]string); ok {
+				masked := make([]string, 0, len(items))
+				for _, item := range items {
+					masked = append(masked, MaskSecret(item))
+				}
+				value = strings.Join(masked, ",")
+			} else {
+				value = MaskSecret(value)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%q\t%s\n", f.name, value, l.sources[f.name])
+	}
+	_ = tw.Flush()
+
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content, _ := json.Marshal(cfg.Profiles[name])
+		fmt.Fprintf(w, "profile %s: %s\n", name, content)
+	}
+	for _, binding := range cfg.Bindings {
+		fmt.Fprintf(w, "binding group=%q user=%q -> %s\n", binding.Group, binding.User, binding.Profile)
+	}
+}
+
+// MaskSecret 对密钥打码, 只保留首尾少量字符以便辨认
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	runes := []rune(secret)
+	if len(runes) <= 8 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:3]) + strings.Repeat("*", len(runes)-7) + string(runes[len(runes)-4:])
+}
+
+// flagValue 暂存命令行参数的原始值
+type flagValue struct {
+	name   string
+	values map[string]string
+	isBool bool
+}
+
+// IsBoolFlag 布尔配置项可以只写 --auto-pass
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func (f *flagValue) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return f.values[f.name]
+}
+
+func (f *flagValue) Set(raw string) error {
+	f.values[f.name] = raw
+	return nil
+}
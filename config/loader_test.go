@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile 在临时目录下写入配置文件, 返回文件路径
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setEnv 设置环境变量, 测试结束后恢复
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	old, had := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if had {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"api_key": "sk-file", "max_tokens": 100, "model": "text-curie-001"}`)
+
+	tests := []struct {
+		name       string
+		env        string
+		args       []string
+		wantTokens uint
+		wantSource string
+	}{
+		{name: "file", wantTokens: 100, wantSource: "file " + path},
+		{name: "env over file", env: "200", wantTokens: 200, wantSource: "env WECHATBOT_MAX_TOKENS"},
+		{name: "flag over env", env: "200", args: []string{"--max-tokens", "300"}, wantTokens: 300, wantSource: "flag --max-tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				setEnv(t, "WECHATBOT_MAX_TOKENS", tt.env)
+			}
+			l := newLoader(append([]string{"--config", path}, tt.args...))
+			cfg, err := l.load()
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if cfg.MaxTokens != tt.wantTokens {
+				t.Errorf("max_tokens = %d, want %d", cfg.MaxTokens, tt.wantTokens)
+			}
+			if got := l.sources["max_tokens"]; got != tt.wantSource {
+				t.Errorf("source = %q, want %q", got, tt.wantSource)
+			}
+			// 未被覆盖的配置项保留配置文件和默认值
+			if cfg.Model != "text-curie-001" {
+				t.Errorf("model = %q, want value from file", cfg.Model)
+			}
+			if cfg.SessionClearToken != "下一个问题" || l.sources["session_clear_token"] != "default" {
+				t.Errorf("session_clear_token = %q from %q, want default", cfg.SessionClearToken, l.sources["session_clear_token"])
+			}
+		})
+	}
+}
+
+func TestLoadLegacyEnv(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"api_key": "sk-file"}`)
+	setEnv(t, "MAX_TOKENS", "64")
+	cfg, err := newLoader([]string{"--config", path}).load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.MaxTokens != 64 {
+		t.Errorf("max_tokens = %d, want 64 from legacy env", cfg.MaxTokens)
+	}
+
+	setEnv(t, "WECHATBOT_MAX_TOKENS", "128")
+	cfg, err = newLoader([]string{"--config", path}).load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.MaxTokens != 128 {
+		t.Errorf("max_tokens = %d, want 128, new env name should win", cfg.MaxTokens)
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "config.json", content: `{
+  "api_keys": ["sk-a", "sk-b"],
+  "max_tokens": 256,
+  "session_timeout": "2m",
+  "profiles": {"support": {"model": "text-curie-001", "max_tokens": 300}}
+}`},
+		{name: "config.yaml", content: `
+api_keys: [sk-a, sk-b]
+max_tokens: 256
+session_timeout: 2m
+profiles:
+  support:
+    model: text-curie-001
+    max_tokens: 300
+`},
+		{name: "config.toml", content: `
+api_keys = ["sk-a", "sk-b"]
+max_tokens = 256
+session_timeout = "2m"
+
+[profiles.support]
+model = "text-curie-001"
+max_tokens = 300
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.name, tt.content)
+			cfg, err := newLoader([]string{"--config", path}).load()
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if strings.Join(cfg.ApiKeys, ",") != "sk-a,sk-b" {
+				t.Errorf("api_keys = %v", cfg.ApiKeys)
+			}
+			if cfg.MaxTokens != 256 {
+				t.Errorf("max_tokens = %d, want 256", cfg.MaxTokens)
+			}
+			if cfg.SessionTimeout.Minutes() != 2 {
+				t.Errorf("session_timeout = %v, want 2m", cfg.SessionTimeout)
+			}
+			profile, ok := cfg.Profiles["support"]
+			if !ok || profile.Model != "text-curie-001" || profile.MaxTokens != 300 {
+				t.Errorf("profiles.support = %+v", profile)
+			}
+		})
+	}
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "max_tokens: 0\ntemperature: 5\n")
+	_, err := newLoader([]string{"--config", path, "--auto-pass=maybe"}).load()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("err = %v, want ValidationErrors", err)
+	}
+	fields := map[string]bool{}
+	for _, fieldErr := range errs {
+		fields[fieldErr.Field] = true
+	}
+	for _, field := range []string{"api_key", "max_tokens", "temperature", "auto_pass"} {
+		if !fields[field] {
+			t.Errorf("missing error for %s in %v", field, err)
+		}
+	}
+}
+
+func TestLoadMissingConfigFile(t *testing.T) {
+	// 在没有配置文件的目录中运行, 未指定配置文件时不查找到仓库中的文件
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	tests := []struct {
+		name        string
+		env         string
+		args        []string
+		wantMissing bool
+	}{
+		{name: "no config file", wantMissing: false},
+		{name: "explicit default name", args: []string{"--config", DefaultConfigFile}, wantMissing: true},
+		{name: "explicit path", args: []string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, wantMissing: true},
+		{name: "env", env: "missing.toml", wantMissing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				setEnv(t, EnvPrefix+"CONFIG", tt.env)
+			}
+			_, err := newLoader(append([]string{"--api-key", "sk-flag"}, tt.args...)).load()
+			if missing := err != nil && strings.Contains(err.Error(), "not found"); missing != tt.wantMissing {
+				t.Errorf("err = %v, want config file not found %v", err, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"api_key": "sk-file", "api_kyes": ["sk-other"], "profiles": {}}`)
+	l := newLoader([]string{"--config", path})
+	_, err := l.load()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("err = %v, want ValidationErrors", err)
+	}
+	if len(errs) != 1 || errs[0].Field != "api_kyes" || !strings.Contains(errs[0].Message, "unknown key") {
+		t.Errorf("errs = %v, want one unknown key error for api_kyes", errs)
+	}
+	if l.sources["api_key"] != "file "+path {
+		t.Errorf("api_key source = %q, want file", l.sources["api_key"])
+	}
+
+	// 仓库中的示例配置不能有未知的配置项
+	for _, example := range []string{"../config.dev.json", "../config.dev.yaml"} {
+		_, err := newLoader([]string{"--config", example}).load()
+		if err != nil && strings.Contains(err.Error(), "unknown key") {
+			t.Errorf("%s: %v", example, err)
+		}
+	}
+}
+
+func TestPrintConfigMasksSecrets(t *testing.T) {
+	secret := "sk-0123456789abcdefghij"
+	path := writeConfigFile(t, "config.json", `{"api_keys": ["sk-aaaaaaaaaaaaaaaa1111"], "admin_token": "0123456789abcdef", "http_addr": ":8090"}`)
+	l := newLoader([]string{"--config", path, "--api-key", secret})
+	cfg, err := l.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var buf bytes.Buffer
+	l.print(&buf, cfg)
+	out := buf.String()
+
+	for _, raw := range []string{secret, "sk-aaaaaaaaaaaaaaaa1111", "0123456789abcdef"} {
+		if strings.Contains(out, raw) {
+			t.Errorf("print-config leaks secret %q:\n%s", raw, out)
+		}
+	}
+	for _, want := range []string{MaskSecret(secret), MaskSecret("sk-aaaaaaaaaaaaaaaa1111"), "flag --api-key", `":8090"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("print-config missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		"short":               "*****",
+		"12345678":            "********",
+		"sk-0123456789abcdef": "sk-************cdef",
+	}
+	for secret, want := range tests {
+		if got := MaskSecret(secret); got != want {
+			t.Errorf("MaskSecret(%q) = %q, want %q", secret, got, want)
+		}
+	}
+}
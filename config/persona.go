@@ -0,0 +1,153 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Persona 人设, 包含系统提示词, 示例对话和模型参数, 未设置的模型参数沿用会话配置
+type Persona struct {
+	// 人设名称, 文件中未设置时使用文件名
+	Name string `json:"name"`
+	// 简介, 展示在人设列表中
+	Description string `json:"description"`
+	// 系统提示词
+	SystemPrompt string `json:"system_prompt"`
+	// 示例对话
+	Examples []PersonaExample `json:"examples"`
+	// GPT模型
+	Model string `json:"model"`
+	// 热度
+	Temperature *float64 `json:"temperature"`
+	// GPT请求最大字符数
+	MaxTokens uint `json:"max_tokens"`
+}
+
+// PersonaExample 一轮示例对话
+type PersonaExample struct {
+	User string `json:"user"`
+	Bot  string `json:"bot"`
+}
+
+// Prompt 系统提示词和示例对话拼接成的提示词
+func (p Persona) Prompt() string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(p.SystemPrompt))
+	if len(p.Examples) > 0 {
+		b.WriteString("\n\n示例对话:")
+		for _, example := range p.Examples {
+			b.WriteString("\n用户: " + strings.TrimSpace(example.User))
+			b.WriteString("\n机器人: " + strings.TrimSpace(example.Bot))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// PersonaNames 按名称排序的人设列表
+func (c *Configuration) PersonaNames() []string {
+	names := make([]string, 0, len(c.Personas))
+	for name := range c.Personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithPersona 在会话配置上应用人设, name 为空时使用 settings 中 profile 指定的人设
+func (c *Configuration) WithPersona(settings Settings, name string) Settings {
+	if name == "" {
+		name = settings.Persona
+	}
+	persona, ok := c.Personas[name]
+	if !ok {
+		return settings
+	}
+
+	settings.Persona = name
+	settings.SystemPrompt = persona.Prompt()
+	if persona.Model != "" {
+		settings.Model = persona.Model
+	}
+	if persona.Temperature != nil {
+		settings.Temperature = *persona.Temperature
+	}
+	if persona.MaxTokens != 0 {
+		settings.MaxTokens = persona.MaxTokens
+	}
+	return settings
+}
+
+// loadPersonaDir 读取人设目录下的全部人设文件, 目录不存在时忽略
+func (c *Configuration) loadPersonaDir(errs *ValidationErrors) {
+	if c.PersonaDir == "" {
+		return
+	}
+	entries, err := ioutil.ReadDir(c.PersonaDir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		errs.add("persona_dir", "%v", err)
+		return
+	}
+
+	if c.Personas == nil {
+		c.Personas = map[string]Persona{}
+	}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml" && ext != ".toml") {
+			continue
+		}
+		path := filepath.Join(c.PersonaDir, entry.Name())
+		var persona Persona
+		if _, err := decodeConfigFile(path, &persona); err != nil {
+			errs.add(path, "%v", err)
+			continue
+		}
+		if persona.Name == "" {
+			persona.Name = strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		}
+		if _, ok := c.Personas[persona.Name]; ok {
+			errs.add(path, "duplicated persona %q", persona.Name)
+			continue
+		}
+		c.Personas[persona.Name] = persona
+	}
+}
+
+// validatePersonas 校验人设及 profile 引用的人设
+func (c *Configuration) validatePersonas(errs *ValidationErrors) {
+	if strings.TrimSpace(c.PersonaCommand) == "" {
+		errs.add("persona_command", "must not be empty")
+	}
+	for name, persona := range c.Personas {
+		field := "personas." + name
+		if strings.ContainsAny(name, " \t\n") {
+			errs.add(field, "name must not contain spaces")
+		}
+		if strings.TrimSpace(persona.SystemPrompt) == "" {
+			errs.add(field+".system_prompt", "must not be empty")
+		}
+		if persona.Model != "" && !isKnownModel(persona.Model) {
+			errs.add(field+".model", "unknown model %q", persona.Model)
+		}
+		if persona.Temperature != nil && (*persona.Temperature < 0 || *persona.Temperature > 2) {
+			errs.add(field+".temperature", "must be between 0 and 2, got %v", *persona.Temperature)
+		}
+		if persona.MaxTokens > 4096 {
+			errs.add(field+".max_tokens", "must be between 1 and 4096, got %d", persona.MaxTokens)
+		}
+	}
+	for name, profile := range c.Profiles {
+		if profile.Persona == "" {
+			continue
+		}
+		if _, ok := c.Personas[profile.Persona]; !ok {
+			errs.add("profiles."+name+".persona", "persona %q is not defined", profile.Persona)
+		}
+	}
+}
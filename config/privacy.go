@@ -0,0 +1,30 @@
+package config
+
+import (
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
+)
+
+// Secrets 配置中的全部密钥, 日志中出现时会被遮盖
+func (c *Configuration) Secrets() []string {
+	secrets := append([]string{c.WechatWorkSendKey, c.AdminToken}, c.AllApiKeys()...)
+	secrets = append(secrets, c.ApiTokens...)
+	secrets = append(secrets, c.WecomAppSecret, c.WecomAppToken, c.WecomAppAESKey)
+	secrets = append(secrets, c.DingtalkAppSecret, c.FeishuAppSecret, c.FeishuVerificationToken, c.FeishuEncryptKey, c.TelegramBotToken)
+	for _, n := range c.Notifiers {
+		secrets = append(secrets, n.Key, n.Secret, n.BotToken, n.Password)
+		for _, value := range n.Headers {
+			secrets = append(secrets, value)
+		}
+	}
+	return secrets
+}
+
+// ConfigureLogging 按配置设置日志的隐私级别, 脱敏规则, 级别, 格式和输出
+func (c *Configuration) ConfigureLogging() error {
+	if err := redact.Configure(c.PrivacyLevel, c.Secrets()); err != nil {
+		return err
+	}
+	logger.SetRedactor(redact.Text)
+	return logger.Configure(c.LogOptions())
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 触发模式, 决定哪些消息需要机器人回复
+const (
+	// TriggerAt 被@时回复, 群聊默认
+	TriggerAt = "at"
+	// TriggerAlways 所有消息都回复, 私聊默认
+	TriggerAlways = "always"
+	// TriggerPrefix 以 trigger_prefix 开头的消息才回复
+	TriggerPrefix = "prefix"
+)
+
+// DefaultProfileName 名为 default 的 profile 作用于所有会话, 其他 profile 在其基础上覆盖
+const DefaultProfileName = "default"
+
+// Profile 会话级配置, 未设置的项沿用全局配置
+type Profile struct {
+	// 系统提示词, 拼接在每次请求的最前面
+	SystemPrompt string `json:"system_prompt"`
+	// 默认人设, 见 persona.go, 用户可以在会话中切换
+	Persona string `json:"persona"`
+	// GPT模型
+	Model string `json:"model"`
+	// 热度
+	Temperature *float64 `json:"temperature"`
+	// GPT请求最大字符数
+	MaxTokens uint `json:"max_tokens"`
+	// 回复前缀
+	ReplyPrefix *string `json:"reply_prefix"`
+	// 触发模式: at, always, prefix
+	TriggerMode string `json:"trigger_mode"`
+	// trigger_mode 为 prefix 时的触发前缀
+	TriggerPrefix string `json:"trigger_prefix"`
+	// 功能开关
+	Features Features `json:"features"`
+}
+
+// Features 功能开关, 未设置时默认开启
+type Features struct {
+	// 生成图片
+	Image *bool `json:"image"`
+	// 携带上下文
+	Context *bool `json:"context"`
+}
+
+// ProfileBinding 将群或用户映射到 profile, 按配置顺序匹配, 第一个匹配的生效
+type ProfileBinding struct {
+	// profile 名称
+	Profile string `json:"profile"`
+	// 群 id 或群昵称, 昵称支持通配符, 如 "技术*"
+	Group string `json:"group"`
+	// 用户 id 或昵称, 昵称支持通配符. 同时设置 group 时表示该群里的该用户
+	User string `json:"user"`
+}
+
+// Identity 群或用户的身份, 用于匹配 ProfileBinding
+type Identity struct {
+	ID       string
+	NickName string
+}
+
+// Settings 某个会话最终生效的配置
+type Settings struct {
+	// 生效的 profile 名称, 没有匹配时为空
+	Profile string
+	// 生效的人设名称, 没有人设时为空
+	Persona        string
+	SystemPrompt   string
+	Model          string
+	Temperature    float64
+	MaxTokens      uint
+	ReplyPrefix    string
+	TriggerMode    string
+	TriggerPrefix  string
+	ImageEnabled   bool
+	ContextEnabled bool
+}
+
+// SettingsFor 计算会话生效的配置, 私聊时 group 为 nil
+func (c *Configuration) SettingsFor(group *Identity, user *Identity) Settings {
+	settings := Settings{
+		Model:          c.Model,
+		Temperature:    c.Temperature,
+		MaxTokens:      c.MaxTokens,
+		TriggerMode:    TriggerAlways,
+		ImageEnabled:   true,
+		ContextEnabled: true,
+	}
+	if group != nil {
+		// 全局的回复前缀只作用于私聊
+		settings.TriggerMode = TriggerAt
+	} else {
+		settings.ReplyPrefix = c.ReplyPrefix
+	}
+
+	if profile, ok := c.Profiles[DefaultProfileName]; ok {
+		profile.applyTo(&settings)
+	}
+	if name := c.matchProfile(group, user); name != "" {
+		settings.Profile = name
+		c.Profiles[name].applyTo(&settings)
+	}
+	return settings
+}
+
+// matchProfile 返回第一个匹配的 profile 名称
+func (c *Configuration) matchProfile(group *Identity, user *Identity) string {
+	for _, binding := range c.Bindings {
+		if binding.Group == "" && binding.User == "" {
+			continue
+		}
+		if binding.Group != "" && (group == nil || !binding.matches(binding.Group, *group)) {
+			continue
+		}
+		if binding.Group == "" && group != nil {
+			// 只配置了用户的规则只作用于私聊
+			continue
+		}
+		if binding.User != "" && (user == nil || !binding.matches(binding.User, *user)) {
+			continue
+		}
+		return binding.Profile
+	}
+	return ""
+}
+
+func (b ProfileBinding) matches(pattern string, identity Identity) bool {
+	return matchIdentity(pattern, identity)
+}
+
+// matchIdentity pattern 为 id 或昵称, 昵称支持 * 通配符. 多账号模式下微信的 id 带有 "wechat:账号名称:" 前缀,
+// pattern 可以写带前缀的 id 只匹配该账号, 也可以写微信原始的 id 匹配所有账号
+func matchIdentity(pattern string, identity Identity) bool {
+	if pattern == identity.ID || pattern == unprefixedWechatID(identity.ID) {
+		return true
+	}
+	matched, err := path.Match(pattern, identity.NickName)
+	return err == nil && matched
+}
+
+// unprefixedWechatID 去掉多账号模式下微信 id 的 "wechat:账号名称:" 前缀, 其他 id 返回空
+func unprefixedWechatID(id string) string {
+	if !strings.HasPrefix(id, "wechat:") {
+		return ""
+	}
+	rest := id[len("wechat:"):]
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return ""
+	}
+	return rest[i+1:]
+}
+
+func (p Profile) applyTo(settings *Settings) {
+	if p.SystemPrompt != "" {
+		settings.SystemPrompt = strings.TrimSpace(p.SystemPrompt)
+	}
+	if p.Persona != "" {
+		settings.Persona = p.Persona
+	}
+	if p.Model != "" {
+		settings.Model = p.Model
+	}
+	if p.Temperature != nil {
+		settings.Temperature = *p.Temperature
+	}
+	if p.MaxTokens != 0 {
+		settings.MaxTokens = p.MaxTokens
+	}
+	if p.ReplyPrefix != nil {
+		settings.ReplyPrefix = *p.ReplyPrefix
+	}
+	if p.TriggerMode != "" {
+		settings.TriggerMode = p.TriggerMode
+		settings.TriggerPrefix = p.TriggerPrefix
+	}
+	if p.Features.Image != nil {
+		settings.ImageEnabled = *p.Features.Image
+	}
+	if p.Features.Context != nil {
+		settings.ContextEnabled = *p.Features.Context
+	}
+}
+
+// validateProfiles 校验 profiles 及 bindings
+func (c *Configuration) validateProfiles(errs *ValidationErrors) {
+	for name, profile := range c.Profiles {
+		field := "profiles." + name
+		if profile.Model != "" && !isKnownModel(profile.Model) {
+			errs.add(field+".model", "unknown model %q", profile.Model)
+		}
+		if profile.Temperature != nil && (*profile.Temperature < 0 || *profile.Temperature > 2) {
+			errs.add(field+".temperature", "must be between 0 and 2, got %v", *profile.Temperature)
+		}
+		if profile.MaxTokens > 4096 {
+			errs.add(field+".max_tokens", "must be between 1 and 4096, got %d", profile.MaxTokens)
+		}
+		switch profile.TriggerMode {
+		case "", TriggerAt, TriggerAlways:
+		case TriggerPrefix:
+			if strings.TrimSpace(profile.TriggerPrefix) == "" {
+				errs.add(field+".trigger_prefix", "required when trigger_mode is prefix")
+			}
+		default:
+			errs.add(field+".trigger_mode", "unknown trigger mode %q, expected one of: at, always, prefix", profile.TriggerMode)
+		}
+	}
+
+	for i, binding := range c.Bindings {
+		field := "bindings[" + strconv.Itoa(i) + "]"
+		if _, ok := c.Profiles[binding.Profile]; !ok {
+			errs.add(field+".profile", "profile %q is not defined", binding.Profile)
+		}
+		if binding.Group == "" && binding.User == "" {
+			errs.add(field, "group or user required")
+		}
+		for _, pattern := range []string{binding.Group, binding.User} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs.add(field, "bad pattern %q: %v", pattern, err)
+			}
+		}
+	}
+}
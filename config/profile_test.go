@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestMatchIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		identity Identity
+		want     bool
+	}{
+		{name: "id", pattern: "12345", identity: Identity{ID: "12345"}, want: true},
+		{name: "nickname glob", pattern: "技术*", identity: Identity{ID: "1", NickName: "技术交流群"}, want: true},
+		{name: "prefixed wechat id", pattern: "wechat:sales:12345", identity: Identity{ID: "wechat:sales:12345"}, want: true},
+		{name: "bare wechat id in multi account mode", pattern: "12345", identity: Identity{ID: "wechat:sales:12345"}, want: true},
+		{name: "other account", pattern: "wechat:support:12345", identity: Identity{ID: "wechat:sales:12345"}, want: false},
+		{name: "bare id of other channel", pattern: "12345", identity: Identity{ID: "telegram:12345"}, want: false},
+		{name: "no match", pattern: "67890", identity: Identity{ID: "wechat:sales:12345", NickName: "张三"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchIdentity(tt.pattern, tt.identity); got != tt.want {
+				t.Errorf("matchIdentity(%q, %+v) = %v, want %v", tt.pattern, tt.identity, got, tt.want)
+			}
+		})
+	}
+}
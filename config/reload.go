@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+)
+
+// ConfigFile 生效的配置文件路径, 没有配置文件时为默认路径
+func ConfigFile() string {
+	LoadConfig()
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return configFile
+}
+
+// ReadConfigFile 读取生效的配置文件内容, 文件不存在时返回空内容
+func ReadConfigFile() ([]byte, error) {
+	content, err := ioutil.ReadFile(ConfigFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// ReadMaskedConfigFile 读取生效的配置文件内容, 密钥按 MaskSecret 打码, 用于在管理后台展示
+func ReadMaskedConfigFile() ([]byte, error) {
+	content, err := ReadConfigFile()
+	if err != nil || len(content) == 0 {
+		return content, err
+	}
+	return maskSecrets(content, configFileSecrets()), nil
+}
+
+// UnmaskConfigContent 把管理后台提交的内容中打码的密钥还原为配置文件中原有的值, 未修改的密钥保持不变
+func UnmaskConfigContent(content []byte) ([]byte, error) {
+	return unmaskSecrets(content, configFileSecrets())
+}
+
+// configFileSecrets 配置文件和生效配置中的全部密钥. 文件中的值可能被环境变量或命令行参数覆盖, 两者都需要打码
+func configFileSecrets() []string {
+	var fileConfig Configuration
+	// 解析失败时 fileConfig 中仍有已解析的部分
+	_, _ = decodeConfigFile(ConfigFile(), &fileConfig)
+	return append(fileConfig.Secrets(), LoadConfig().Secrets()...)
+}
+
+// maskSecrets 把内容中出现的密钥替换为打码后的值, 先替换长的, 避免短密钥是长密钥的一部分时替换不完整
+func maskSecrets(content []byte, secrets []string) []byte {
+	secrets = uniqueSecrets(secrets)
+	text := string(content)
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, MaskSecret(secret))
+	}
+	return []byte(text)
+}
+
+// unmaskSecrets 把打码后的值替换回原有的密钥. 不同密钥打码后相同且出现在内容中时无法还原, 返回错误
+func unmaskSecrets(content []byte, secrets []string) ([]byte, error) {
+	secrets = uniqueSecrets(secrets)
+	originals := map[string]string{}
+	for _, secret := range secrets {
+		masked := MaskSecret(secret)
+		if original, ok := originals[masked]; ok && original != secret {
+			originals[masked] = ""
+			continue
+		}
+		originals[masked] = secret
+	}
+
+	text := string(content)
+	for _, secret := range secrets {
+		masked := MaskSecret(secret)
+		if !strings.Contains(text, masked) {
+			continue
+		}
+		original := originals[masked]
+		if original == "" {
+			return nil, fmt.Errorf("masked secret %s matches more than one secret, please enter the full value", masked)
+		}
+		text = strings.ReplaceAll(text, masked, original)
+	}
+	return []byte(text), nil
+}
+
+// uniqueSecrets 去掉空值和重复的密钥, 按长度从长到短排序
+func uniqueSecrets(secrets []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, secret := range secrets {
+		if secret == "" || seen[secret] {
+			continue
+		}
+		seen[secret] = true
+		result = append(result, secret)
+	}
+	sort.Slice(result, func(i, j int) bool { return len(result[i]) > len(result[j]) })
+	return result
+}
+
+// Reload 校验新的配置文件内容, 合法时写入配置文件并替换生效的配置, 不合法时返回全部错误且不做任何修改.
+// 环境变量和命令行参数仍然优先于配置文件. 已创建的 key 池, 会话缓存和 http 服务不会随之更新, 需要重启
+func Reload(content []byte) (*Configuration, error) {
+	path := ConfigFile()
+	tmp := filepath.Join(filepath.Dir(path), ".reload-"+filepath.Base(path))
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return nil, err
+	}
+
+	l := newLoader(args)
+	l.overrideConfigFile = tmp
+	cfg, err := l.load()
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+
+	if err := cfg.ConfigureLogging(); err != nil {
+		logger.Warning(fmt.Sprintf("reconfigure logger error: %v", err))
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+	config = cfg
+	return cfg, nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaskSecretsRoundTrip(t *testing.T) {
+	secrets := []string{"sk-aaaaaaaaaaaaaaaa1111", "", "admin-token-value", "sk-aaaaaaaaaaaaaaaa1111"}
+	content := []byte(`{"api_key": "sk-aaaaaaaaaaaaaaaa1111", "admin_token": "admin-token-value", "http_addr": ":8090"}`)
+
+	masked := maskSecrets(content, secrets)
+	for _, secret := range []string{"sk-aaaaaaaaaaaaaaaa1111", "admin-token-value"} {
+		if strings.Contains(string(masked), secret) {
+			t.Errorf("masked content %s contains secret %s", masked, secret)
+		}
+	}
+	if !strings.Contains(string(masked), MaskSecret("admin-token-value")) {
+		t.Errorf("masked content %s missing masked admin token", masked)
+	}
+
+	restored, err := unmaskSecrets(masked, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(restored) != string(content) {
+		t.Errorf("unmaskSecrets() = %s, want %s", restored, content)
+	}
+
+	// 修改过的密钥按提交的值保存
+	edited := strings.Replace(string(masked), MaskSecret("admin-token-value"), "new-admin-token", 1)
+	restored, err = unmaskSecrets([]byte(edited), secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"api_key": "sk-aaaaaaaaaaaaaaaa1111", "admin_token": "new-admin-token", "http_addr": ":8090"}`; string(restored) != want {
+		t.Errorf("unmaskSecrets() = %s, want %s", restored, want)
+	}
+}
+
+func TestUnmaskAmbiguousSecrets(t *testing.T) {
+	// 8 位及以下的密钥打码后都是 *, 无法区分
+	secrets := []string{"token-a1", "token-b2"}
+	masked := maskSecrets([]byte(`wecom_app_token: token-a1`), secrets)
+	if _, err := unmaskSecrets(masked, secrets); err == nil {
+		t.Error("unmaskSecrets() error = nil, want error for ambiguous mask")
+	}
+	if _, err := unmaskSecrets([]byte(`wecom_app_token: token-c3`), secrets); err != nil {
+		t.Errorf("unmaskSecrets() without masked values error = %v", err)
+	}
+}
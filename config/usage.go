@@ -0,0 +1,107 @@
+package config
+
+import (
+	"sort"
+	"time"
+)
+
+// 用量报告的周期
+const (
+	ReportDaily   = "daily"
+	ReportWeekly  = "weekly"
+	ReportMonthly = "monthly"
+)
+
+// ReportPeriods 支持的用量报告周期
+var ReportPeriods = []string{ReportDaily, ReportWeekly, ReportMonthly}
+
+// Price 模型的价格, token 按每 1000 个计价, 图片按张和尺寸计价
+type Price struct {
+	// 每 1000 个 prompt token 的价格
+	Prompt float64 `json:"prompt"`
+	// 每 1000 个 completion token 的价格
+	Completion float64 `json:"completion"`
+	// 每张图片的价格, key 为尺寸, 如 1024x1024
+	Images map[string]float64 `json:"images,omitempty"`
+}
+
+// defaultPrices openai 官方价格, 单位为美元
+var defaultPrices = map[string]Price{
+	"text-davinci-003": {Prompt: 0.02, Completion: 0.02},
+	"text-davinci-002": {Prompt: 0.02, Completion: 0.02},
+	"text-davinci-001": {Prompt: 0.02, Completion: 0.02},
+	"text-curie-001":   {Prompt: 0.002, Completion: 0.002},
+	"text-babbage-001": {Prompt: 0.0005, Completion: 0.0005},
+	"text-ada-001":     {Prompt: 0.0004, Completion: 0.0004},
+	"dall-e": {Images: map[string]float64{
+		"1024x1024": 0.02,
+		"512x512":   0.018,
+		"256x256":   0.016,
+	}},
+}
+
+// defaultPricesCopy 默认价格的副本, 配置文件中的价格会覆盖同名模型
+func defaultPricesCopy() map[string]Price {
+	prices := make(map[string]Price, len(defaultPrices))
+	for model, price := range defaultPrices {
+		prices[model] = price
+	}
+	return prices
+}
+
+// IsAdmin 用户是否为管理员, admins 中写带渠道前缀的用户 id. 昵称可以被用户随意修改, 不用于鉴权.
+// 单账号微信的 id 为 openwechat 的 User.ID(), 即 Uin 或头像地址中的 seq, 没有前缀; 多账号时为 wechat:<账号>:<id>
+func (c *Configuration) IsAdmin(user *Identity) bool {
+	if user == nil || user.ID == "" {
+		return false
+	}
+	for _, admin := range c.Admins {
+		if admin == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// validateUsage 校验价格表和用量报告配置
+func (c *Configuration) validateUsage(errs *ValidationErrors) {
+	models := make([]string, 0, len(c.Prices))
+	for model := range c.Prices {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		price := c.Prices[model]
+		if price.Prompt < 0 || price.Completion < 0 {
+			errs.add("prices."+model, "price must not be negative")
+		}
+		for size, imagePrice := range price.Images {
+			if imagePrice < 0 {
+				errs.add("prices."+model+".images."+size, "price must not be negative")
+			}
+		}
+	}
+
+	for _, period := range c.UsageReports {
+		if !isReportPeriod(period) {
+			errs.add("usage_reports", "unknown period %q, expected daily, weekly or monthly", period)
+		}
+	}
+	if len(c.UsageReports) > 0 {
+		if c.UsageFile == "" {
+			errs.add("usage_reports", "usage reports require usage_file")
+		}
+		if _, err := time.Parse("15:04", c.UsageReportTime); err != nil {
+			errs.add("usage_report_time", "must be HH:MM, got %q", c.UsageReportTime)
+		}
+	}
+}
+
+func isReportPeriod(period string) bool {
+	for _, p := range ReportPeriods {
+		if period == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+func TestIsAdmin(t *testing.T) {
+	c := &Configuration{Admins: []string{"telegram:42", "wecom:zhangsan"}}
+	tests := []struct {
+		name string
+		user *Identity
+		want bool
+	}{
+		{name: "nil", user: nil, want: false},
+		{name: "prefixed id", user: &Identity{ID: "telegram:42", NickName: "alice"}, want: true},
+		{name: "bare id", user: &Identity{ID: "42"}, want: false},
+		{name: "other channel", user: &Identity{ID: "dingtalk:42"}, want: false},
+		{name: "nickname matching admin", user: &Identity{ID: "feishu:ou_x", NickName: "wecom:zhangsan"}, want: false},
+		{name: "empty id", user: &Identity{NickName: "telegram:42"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.IsAdmin(tt.user); got != tt.want {
+				t.Errorf("IsAdmin(%+v) = %v, want %v", tt.user, got, tt.want)
+			}
+		})
+	}
+}
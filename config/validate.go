@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
+	gogpt "github.com/sashabaranov/go-gpt3"
+)
+
+// knownModels 支持的 GPT 模型
+var knownModels = []string{
+	gogpt.GPT3TextDavinci003,
+	gogpt.GPT3TextDavinci002,
+	gogpt.GPT3TextCurie001,
+	gogpt.GPT3TextBabbage001,
+	gogpt.GPT3TextAda001,
+	gogpt.GPT3TextDavinci001,
+	gogpt.GPT3DavinciInstructBeta,
+	gogpt.GPT3Davinci,
+	gogpt.GPT3CurieInstructBeta,
+	gogpt.GPT3Curie,
+	gogpt.GPT3Ada,
+	gogpt.GPT3Babbage,
+}
+
+// FieldError 单个配置项的错误, Field 为配置项路径, 如 temperature
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors 收集到的全部配置错误
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	lines := make([]string, 0, len(v))
+	for _, fieldErr := range v {
+		lines = append(lines, "  - "+fieldErr.Error())
+	}
+	return fmt.Sprintf("invalid config, %d error(s):\n%s", len(v), strings.Join(lines, "\n"))
+}
+
+// add 追加一个配置错误
+func (v *ValidationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate 校验配置, 一次性返回所有不合法的配置项, 全部合法时返回 nil
+func (c *Configuration) Validate() error {
+	var errs ValidationErrors
+	c.validate(&errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (c *Configuration) validate(errs *ValidationErrors) {
+	if len(c.AllApiKeys()) == 0 {
+		errs.add("api_key", "api key required, set api_key, api_keys or api_key_file")
+	}
+	if c.ApiKeyStrategy != KeyStrategyRoundRobin && c.ApiKeyStrategy != KeyStrategyLeastUsed {
+		errs.add("api_key_strategy", "unknown strategy %q, expected round_robin or least_used", c.ApiKeyStrategy)
+	}
+	if c.ApiKeyCooldown.Duration <= 0 {
+		errs.add("api_key_cooldown", "must be greater than 0, got %v", c.ApiKeyCooldown)
+	}
+	if c.SessionTimeout.Duration <= 0 {
+		errs.add("session_timeout", "must be greater than 0, got %v", c.SessionTimeout)
+	}
+	if c.MaxTokens == 0 || c.MaxTokens > 4096 {
+		errs.add("max_tokens", "must be between 1 and 4096, got %d", c.MaxTokens)
+	}
+	if c.Temperature < 0 || c.Temperature > 2 {
+		errs.add("temperature", "must be between 0 and 2, got %v", c.Temperature)
+	}
+	if !isKnownModel(c.Model) {
+		errs.add("model", "unknown model %q, expected one of: %s", c.Model, strings.Join(knownModels, ", "))
+	}
+	if strings.TrimSpace(c.SessionClearToken) == "" {
+		errs.add("session_clear_token", "must not be empty")
+	}
+	if c.ApiProxyHost != "" {
+		if err := validateURL(c.ApiProxyHost); err != nil {
+			errs.add("api_proxy_host", "%v", err)
+		}
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		errs.add("admin_token", "must be at least 16 characters")
+	}
+	if c.AdminToken != "" && c.HttpAddr == "" {
+		errs.add("admin_token", "admin console requires http_addr")
+	}
+	for i, token := range c.ApiTokens {
+		if len(token) < 16 {
+			errs.add(fmt.Sprintf("api_tokens[%d]", i), "must be at least 16 characters")
+		}
+	}
+	if len(c.ApiTokens) > 0 && c.HttpAddr == "" {
+		errs.add("api_tokens", "chat api requires http_addr")
+	}
+	c.validateWecomApp(errs)
+	c.validateDingtalk(errs)
+	c.validateFeishu(errs)
+	c.validateTelegram(errs)
+	if c.PublicURL != "" {
+		if err := validateURL(c.PublicURL); err != nil {
+			errs.add("public_url", "%v", err)
+		}
+	}
+	for i, n := range c.Notifiers {
+		if err := n.Validate(); err != nil {
+			errs.add(fmt.Sprintf("notifiers[%d]", i), "%v", err)
+		}
+	}
+	c.validateAlertTemplates(errs)
+	c.validateProfiles(errs)
+	c.validateAccounts(errs)
+	c.validatePersonas(errs)
+	c.validateUsage(errs)
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		errs.add("log_level", "%v", err)
+	}
+	if c.LogFormat != logger.FormatConsole && c.LogFormat != logger.FormatJSON {
+		errs.add("log_format", "unknown format %q, expected console or json", c.LogFormat)
+	}
+	if !redact.IsLevel(c.PrivacyLevel) {
+		errs.add("privacy_level", "unknown level %q, expected basic, standard or strict", c.PrivacyLevel)
+	}
+	if c.LogRotateInterval.Duration < 0 {
+		errs.add("log_rotate_interval", "must not be negative")
+	}
+}
+
+func isKnownModel(model string) bool {
+	for _, knownModel := range knownModels {
+		if model == knownModel {
+			return true
+		}
+	}
+	return false
+}
+
+// validateURL 校验 url 是否为带 host 的 http(s) 地址
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("malformed url %q: %v", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url %q must start with http:// or https://", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url %q has no host", rawURL)
+	}
+	return nil
+}
@@ -9,15 +9,25 @@ import (
 	"fmt"
 	"github.com/coolseven/wechatbot-chatgpt/config"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
 	gogpt "github.com/sashabaranov/go-gpt3"
 	"image/png"
 	"io"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 const BASEURL = "https://api.openai.com/v1/"
 
+// ImageModel 生成图片的模型, 用于统计用量
+const ImageModel = "dall-e"
+
+// ImageSize 生成图片的尺寸
+const ImageSize = gogpt.CreateImageSize1024x1024
+
 // ChatGPTResponseBody 请求体
 type ChatGPTResponseBody struct {
 	ID      string                 `json:"id"`
@@ -46,70 +56,114 @@ type ChatGPTRequestBody struct {
 	PresencePenalty  int     `json:"presence_penalty"`
 }
 
-// Completions see https://platform.openai.com/docs/api-reference/completions/create
-func Completions(input string) (string, error) {
-	cfg := config.LoadConfig()
+// Completion 一次补全请求的结果
+type Completion struct {
+	// 模型返回的文本
+	Text string
+	// 实际发送给模型的 prompt, 包含系统提示词
+	Prompt           string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	// 请求耗时, 包含换 key 重试的时间
+	Latency time.Duration
+}
 
-	c := gogpt.NewClient(cfg.ApiKey)
-	if cfg.ApiProxyHost != "" {
-		c.BaseURL = cfg.ApiProxyHost
+// Completions see https://platform.openai.com/docs/api-reference/completions/create
+// settings 为会话生效的配置, 决定模型, 热度, 最大字符数和系统提示词, requester 用于记录用量
+func Completions(input string, settings config.Settings, requester usage.Requester) (string, error) {
+	completion, err := Complete(input, settings, requester)
+	if err != nil {
+		return "", err
 	}
+	return completion.Text, nil
+}
 
+// Complete 与 Completions 相同, 同时返回 prompt, token 用量和耗时, 用于审计和回放
+func Complete(input string, settings config.Settings, requester usage.Requester) (Completion, error) {
 	ctx := context.Background()
 
+	prompt := input
+	if settings.SystemPrompt != "" {
+		prompt = settings.SystemPrompt + "\n\n" + input
+	}
+	completion := Completion{Prompt: prompt, Model: settings.Model}
+
 	req := gogpt.CompletionRequest{
-		Model:            gogpt.GPT3TextDavinci003,
-		MaxTokens:        int(cfg.MaxTokens),
-		Prompt:           input,
-		Temperature:      float32(cfg.Temperature),
+		Model:            settings.Model,
+		MaxTokens:        int(settings.MaxTokens),
+		Prompt:           prompt,
+		Temperature:      float32(settings.Temperature),
 		TopP:             1,
 		FrequencyPenalty: 0,
 		PresencePenalty:  0,
 	}
-	resp, err := c.CreateCompletion(ctx, req)
+	var resp gogpt.CompletionResponse
+	startedAt := time.Now()
+	err := withKey(settings.Model, func(c *gogpt.Client) (err error) {
+		resp, err = c.CreateCompletion(ctx, req)
+		return err
+	})
+	completion.Latency = time.Since(startedAt)
 	if err != nil {
-		return "", errors.New(fmt.Sprintf("请求GTP出错了，gpt api err: %v ", err))
+		return completion, errors.New(fmt.Sprintf("请求GTP出错了，gpt api err: %v ", err))
 	}
+	observeUsage(requester, usage.Record{
+		Model:            settings.Model,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	})
 	responseBodyString, _ := json.Marshal(resp)
-	logger.Info(fmt.Sprintf("response gpt json string : %s", string(responseBodyString)))
+	logger.Info(fmt.Sprintf("response gpt json string : %s", redact.Body(string(responseBodyString))))
 
-	return resp.Choices[0].Text, nil
+	if len(resp.Choices) == 0 {
+		return completion, errors.New("请求GTP出错了，gpt api err: empty choices")
+	}
+	completion.Text = resp.Choices[0].Text
+	if resp.Model != "" {
+		completion.Model = resp.Model
+	}
+	completion.PromptTokens = resp.Usage.PromptTokens
+	completion.CompletionTokens = resp.Usage.CompletionTokens
+	return completion, nil
 }
 
 // CreateImageMedia see https://platform.openai.com/docs/api-reference/images/create
-func CreateImageMedia(imageDescription string, imageCount int) ([]io.Reader, error) {
-	cfg := config.LoadConfig()
-
-	c := gogpt.NewClient(cfg.ApiKey)
-	if cfg.ApiProxyHost != "" {
-		c.BaseURL = cfg.ApiProxyHost
-	}
-
+// requester 用于记录用量
+func CreateImageMedia(imageDescription string, imageCount int, requester usage.Requester) ([]io.Reader, error) {
 	ctx := context.Background()
 
 	req := gogpt.ImageRequest{
 		Prompt:         imageDescription,
 		N:              imageCount,
-		Size:           gogpt.CreateImageSize1024x1024,
+		Size:           ImageSize,
 		ResponseFormat: gogpt.CreateImageResponseFormatB64JSON,
 		User:           "",
 	}
-	resp, err := c.CreateImage(ctx, req)
+	var resp gogpt.ImageResponse
+	err := withKey(ImageModel, func(c *gogpt.Client) (err error) {
+		resp, err = c.CreateImage(ctx, req)
+		return err
+	})
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("请求GTP出错了，gpt api err: %v ", err))
 	}
 
+	observeUsage(requester, usage.Record{Model: ImageModel, Images: len(resp.Data), ImageSize: ImageSize})
+
 	var localImageFiles []io.Reader
 	for _, dataInner := range resp.Data {
 		// 将图片base64到本地临时文件中
 		unbased, err := base64.StdEncoding.DecodeString(dataInner.B64JSON)
 		if err != nil {
+			stats.IncError(stats.ErrorImageDecode)
 			return localImageFiles, err
 		}
 
 		r := bytes.NewReader(unbased)
 		im, err := png.Decode(r)
 		if err != nil {
+			stats.IncError(stats.ErrorImageDecode)
 			return localImageFiles, err
 		}
 
@@ -0,0 +1,189 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/alert"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	gogpt "github.com/sashabaranov/go-gpt3"
+)
+
+// ErrNoAvailableKey key 池中所有 key 都已暂停使用
+var ErrNoAvailableKey = errors.New("no available openai api key, all keys are benched")
+
+// apiKey key 池中的一个 key
+type apiKey struct {
+	key string
+	// 调用次数
+	uses uint64
+	// 暂停使用直到该时间, 零值表示可用
+	benchedUntil time.Time
+}
+
+// keyPool 多个 api key 的轮换池, 额度用尽或失效的 key 会被自动暂停
+type keyPool struct {
+	mu       sync.Mutex
+	keys     []*apiKey
+	strategy string
+	cooldown time.Duration
+	next     int
+}
+
+var pool *keyPool
+var poolOnce sync.Once
+
+// getKeyPool 按配置创建全局的 key 池
+func getKeyPool() *keyPool {
+	poolOnce.Do(func() {
+		cfg := config.LoadConfig()
+		pool = newKeyPool(cfg.AllApiKeys(), cfg.ApiKeyStrategy, cfg.ApiKeyCooldown.Duration)
+	})
+	return pool
+}
+
+func newKeyPool(keys []string, strategy string, cooldown time.Duration) *keyPool {
+	p := &keyPool{strategy: strategy, cooldown: cooldown}
+	for _, key := range keys {
+		p.keys = append(p.keys, &apiKey{key: key})
+	}
+	return p
+}
+
+// size key 池中 key 的数量
+func (p *keyPool) size() int {
+	return len(p.keys)
+}
+
+// available 当前可用的 key 数量
+func (p *keyPool) available() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	count := 0
+	for _, k := range p.keys {
+		if !now.Before(k.benchedUntil) {
+			count++
+		}
+	}
+	return count
+}
+
+// KeyStatus key 池中可用的 key 数量和 key 总数
+func KeyStatus() (available int, total int) {
+	p := getKeyPool()
+	return p.available(), p.size()
+}
+
+// pick 按轮换策略选出一个可用的 key
+func (p *keyPool) pick() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var picked *apiKey
+	for i := 0; i < len(p.keys); i++ {
+		candidate := p.keys[(p.next+i)%len(p.keys)]
+		if now.Before(candidate.benchedUntil) {
+			continue
+		}
+		if p.strategy == config.KeyStrategyLeastUsed {
+			if picked == nil || candidate.uses < picked.uses {
+				picked = candidate
+			}
+			continue
+		}
+		picked = candidate
+		p.next = (p.next + i + 1) % len(p.keys)
+		break
+	}
+	if picked == nil {
+		return "", ErrNoAvailableKey
+	}
+	picked.uses++
+	return picked.key, nil
+}
+
+// bench 暂停使用一个 key, 并通过告警渠道通知
+func (p *keyPool) bench(key string, reason error, permanent bool) {
+	p.mu.Lock()
+	until := time.Now().Add(p.cooldown)
+	if permanent {
+		until = time.Now().AddDate(100, 0, 0)
+	}
+	for _, k := range p.keys {
+		if k.key == key {
+			k.benchedUntil = until
+		}
+	}
+	p.mu.Unlock()
+
+	event := alert.EventQuotaExhausted
+	if permanent {
+		event = alert.EventKeyRevoked
+	}
+	logger.Warning(fmt.Sprintf("openai api key %s is benched until %s, event: %s, reason: %v", config.MaskSecret(key), until.Format(time.RFC3339), event, reason))
+	alert.SendAsync(event, alert.Data{
+		Key:       config.MaskSecret(key),
+		Until:     until,
+		LastError: reason.Error(),
+	})
+}
+
+var statusCodePattern = regexp.MustCompile(`status code: (\d+)`)
+
+// classifyKeyError 判断 openai 返回的错误是否需要暂停当前 key, revoked 表示 key 已失效
+func classifyKeyError(err error) (bench bool, revoked bool) {
+	if err == nil {
+		return false, false
+	}
+	message := err.Error()
+	if match := statusCodePattern.FindStringSubmatch(message); match != nil {
+		if code, _ := strconv.Atoi(match[1]); code == 401 {
+			return true, true
+		}
+	}
+	if strings.Contains(message, "insufficient_quota") || strings.Contains(message, "exceeded your current quota") {
+		return true, false
+	}
+	return false, false
+}
+
+// withKey 使用 key 池中的 key 调用 openai, 遇到额度用尽或 key 失效时暂停该 key 并换下一个重试, model 用于统计指标
+func withKey(model string, call func(c *gogpt.Client) error) error {
+	p := getKeyPool()
+	var err error
+	for attempt := 0; attempt < p.size(); attempt++ {
+		key, pickErr := p.pick()
+		if pickErr != nil {
+			stats.IncError(stats.ErrorNoApiKey)
+			if err != nil {
+				return fmt.Errorf("%v, last error: %v", pickErr, err)
+			}
+			return pickErr
+		}
+
+		c := gogpt.NewClient(key)
+		if apiProxyHost := config.LoadConfig().ApiProxyHost; apiProxyHost != "" {
+			c.BaseURL = apiProxyHost
+		}
+
+		startedAt := time.Now()
+		err = call(c)
+		observeRequest(model, startedAt, err)
+		stats.MarkOpenAI(err)
+		bench, revoked := classifyKeyError(err)
+		if !bench {
+			return err
+		}
+		p.bench(key, err, revoked)
+	}
+	return err
+}
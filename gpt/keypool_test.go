@@ -0,0 +1,177 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	gogpt "github.com/sashabaranov/go-gpt3"
+)
+
+func TestMain(m *testing.M) {
+	// 暂停 key 时会发送告警并读取全局配置, 不能让配置去解析 go test 的参数
+	config.SetArgs([]string{"--api-key", "sk-test"})
+	os.Exit(m.Run())
+}
+
+// apiError 构造与 go-gpt3 相同格式的错误
+func apiError(code int, message string) error {
+	return fmt.Errorf("error, status code: %d, message: %s", code, message)
+}
+
+func TestClassifyKeyError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantBench   bool
+		wantRevoked bool
+	}{
+		{name: "nil", err: nil},
+		{name: "unauthorized", err: apiError(401, "Incorrect API key provided"), wantBench: true, wantRevoked: true},
+		{name: "unauthorized without message", err: errors.New("error, status code: 401"), wantBench: true, wantRevoked: true},
+		{name: "insufficient quota", err: apiError(429, "insufficient_quota"), wantBench: true},
+		{name: "exceeded quota", err: apiError(429, "You exceeded your current quota, please check your plan and billing details."), wantBench: true},
+		{name: "rate limited", err: apiError(429, "Rate limit reached for default-text-davinci-003"), wantBench: false},
+		{name: "server error", err: apiError(500, "The server had an error while processing your request"), wantBench: false},
+		{name: "bad request mentioning 401", err: apiError(400, "max_tokens 4010 is too large"), wantBench: false},
+		{name: "network", err: errors.New("dial tcp: i/o timeout"), wantBench: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bench, revoked := classifyKeyError(tt.err)
+			if bench != tt.wantBench || revoked != tt.wantRevoked {
+				t.Errorf("classifyKeyError(%v) = (%v, %v), want (%v, %v)", tt.err, bench, revoked, tt.wantBench, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+// pickN 连续选出 n 个 key
+func pickN(t *testing.T, p *keyPool, n int) []string {
+	t.Helper()
+	var keys []string
+	for i := 0; i < n; i++ {
+		key, err := p.pick()
+		if err != nil {
+			t.Fatalf("pick %d: %v", i, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	p := newKeyPool([]string{"a", "b", "c"}, config.KeyStrategyRoundRobin, time.Hour)
+	if got := fmt.Sprint(pickN(t, p, 5)); got != "[a b c a b]" {
+		t.Errorf("picked %s, want [a b c a b]", got)
+	}
+
+	// 暂停的 key 被跳过
+	p.bench("c", apiError(429, "insufficient_quota"), false)
+	if got := fmt.Sprint(pickN(t, p, 4)); got != "[a b a b]" {
+		t.Errorf("picked %s after benching c, want [a b a b]", got)
+	}
+}
+
+func TestPickLeastUsed(t *testing.T) {
+	p := newKeyPool([]string{"a", "b", "c"}, config.KeyStrategyLeastUsed, time.Hour)
+	p.keys[0].uses = 5
+	p.keys[1].uses = 1
+	p.keys[2].uses = 2
+	if got := fmt.Sprint(pickN(t, p, 3)); got != "[b b c]" {
+		t.Errorf("picked %s, want [b b c]", got)
+	}
+
+	p.bench("b", apiError(401, "Incorrect API key provided"), true)
+	if got := fmt.Sprint(pickN(t, p, 3)); got != "[c c a]" {
+		t.Errorf("picked %s after benching b, want [c c a]", got)
+	}
+}
+
+func TestBench(t *testing.T) {
+	p := newKeyPool([]string{"a", "b"}, config.KeyStrategyRoundRobin, 50*time.Millisecond)
+	p.bench("a", apiError(429, "insufficient_quota"), false)
+	p.bench("b", apiError(401, "Incorrect API key provided"), true)
+	if available := p.available(); available != 0 {
+		t.Fatalf("available = %d, want 0", available)
+	}
+	if _, err := p.pick(); err != ErrNoAvailableKey {
+		t.Fatalf("pick err = %v, want ErrNoAvailableKey", err)
+	}
+
+	// 额度用尽的 key 冷却后恢复, 失效的 key 一直暂停
+	time.Sleep(60 * time.Millisecond)
+	if available := p.available(); available != 1 {
+		t.Fatalf("available after cooldown = %d, want 1", available)
+	}
+	if got := fmt.Sprint(pickN(t, p, 2)); got != "[a a]" {
+		t.Errorf("picked %s after cooldown, want [a a]", got)
+	}
+}
+
+// usePool 替换全局的 key 池, 测试结束后恢复
+func usePool(t *testing.T, p *keyPool) {
+	poolOnce.Do(func() {})
+	old := pool
+	pool = p
+	t.Cleanup(func() { pool = old })
+}
+
+func TestWithKeyRetriesNextKey(t *testing.T) {
+	p := newKeyPool([]string{"a", "b", "c"}, config.KeyStrategyRoundRobin, time.Hour)
+	usePool(t, p)
+
+	errs := []error{apiError(401, "Incorrect API key provided"), apiError(429, "insufficient_quota"), nil}
+	calls := 0
+	err := withKey("text-davinci-003", func(c *gogpt.Client) error {
+		calls++
+		return errs[calls-1]
+	})
+	if err != nil {
+		t.Fatalf("withKey: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+	if available := p.available(); available != 1 {
+		t.Errorf("available = %d, want 1", available)
+	}
+}
+
+func TestWithKeyStopsOnOtherErrors(t *testing.T) {
+	p := newKeyPool([]string{"a", "b"}, config.KeyStrategyRoundRobin, time.Hour)
+	usePool(t, p)
+
+	want := apiError(500, "The server had an error while processing your request")
+	calls := 0
+	err := withKey("text-davinci-003", func(c *gogpt.Client) error {
+		calls++
+		return want
+	})
+	if err != want || calls != 1 {
+		t.Errorf("withKey = %v after %d calls, want %v after 1 call", err, calls, want)
+	}
+	if available := p.available(); available != 2 {
+		t.Errorf("available = %d, want 2", available)
+	}
+}
+
+func TestWithKeyAllBenched(t *testing.T) {
+	p := newKeyPool([]string{"a", "b"}, config.KeyStrategyRoundRobin, time.Hour)
+	usePool(t, p)
+
+	calls := 0
+	err := withKey("text-davinci-003", func(c *gogpt.Client) error {
+		calls++
+		return apiError(429, "insufficient_quota")
+	})
+	if err == nil || calls != 2 {
+		t.Fatalf("withKey = %v after %d calls, want quota error after 2 calls", err, calls)
+	}
+	if _, err = p.pick(); err != ErrNoAvailableKey {
+		t.Errorf("pick err = %v, want ErrNoAvailableKey", err)
+	}
+}
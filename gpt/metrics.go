@@ -0,0 +1,92 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/metrics"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
+)
+
+var (
+	llmRequestsTotal = metrics.NewCounter("wechatbot_llm_requests_total",
+		"OpenAI requests by model and result.", "model", "result")
+	llmRequestDuration = metrics.NewHistogram("wechatbot_llm_request_duration_seconds",
+		"OpenAI request latency in seconds by model and result.",
+		[]float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}, "model", "result")
+	llmPromptTokens = metrics.NewCounter("wechatbot_llm_prompt_tokens_total",
+		"Prompt tokens consumed by model.", "model")
+	llmCompletionTokens = metrics.NewCounter("wechatbot_llm_completion_tokens_total",
+		"Completion tokens consumed by model.", "model")
+	imageGenerationsTotal = metrics.NewCounter("wechatbot_image_generations_total",
+		"Images generated.")
+	rateLimitRejectionsTotal = metrics.NewCounter("wechatbot_rate_limit_rejections_total",
+		"Requests rejected by the OpenAI rate limiter (HTTP 429 without quota exhaustion).")
+)
+
+func init() {
+	metrics.NewGaugeFunc("wechatbot_api_keys_available", "OpenAI api keys that are not benched.", func() float64 {
+		available, _ := KeyStatus()
+		return float64(available)
+	})
+}
+
+// observeRequest 记录一次 openai 请求的耗时和结果, 失败时按分类统计错误
+func observeRequest(model string, startedAt time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+		class := errorClass(err)
+		stats.IncError(class)
+		if class == stats.ErrorOpenAIRateLimited {
+			rateLimitRejectionsTotal.Inc()
+		}
+	}
+	llmRequestsTotal.Inc(model, result)
+	llmRequestDuration.Observe(time.Since(startedAt).Seconds(), model, result)
+}
+
+// observeUsage 记录 token 和图片用量
+func observeUsage(requester usage.Requester, record usage.Record) {
+	stats.AddUsage(record.Model, record.PromptTokens, record.CompletionTokens, record.Images)
+	usage.Add(requester, record)
+	if record.PromptTokens > 0 {
+		llmPromptTokens.Add(float64(record.PromptTokens), record.Model)
+	}
+	if record.CompletionTokens > 0 {
+		llmCompletionTokens.Add(float64(record.CompletionTokens), record.Model)
+	}
+	if record.Images > 0 {
+		imageGenerationsTotal.Add(float64(record.Images))
+	}
+}
+
+// errorClass openai 错误的分类
+func errorClass(err error) string {
+	if bench, revoked := classifyKeyError(err); bench {
+		if revoked {
+			return stats.ErrorOpenAIUnauthorized
+		}
+		return stats.ErrorOpenAIQuota
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return stats.ErrorOpenAITimeout
+	}
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		switch {
+		case code == 429:
+			return stats.ErrorOpenAIRateLimited
+		case code >= 500:
+			return stats.ErrorOpenAIServer
+		case code >= 400:
+			return stats.ErrorOpenAIBadRequest
+		}
+	}
+	return stats.ErrorOpenAIOther
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"github.com/coolseven/wechatbot-chatgpt/audit"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/gpt"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
+	"time"
+)
+
+// newAuditRecord 创建消息的审计记录
+func newAuditRecord(msg *channel.Message) *audit.Record {
+	record := &audit.Record{
+		Time:           time.Now(),
+		ConversationID: msg.Conversation.ID,
+		Conversation:   msg.Conversation.Name,
+		UserID:         msg.Sender.ID,
+		User:           msg.Sender.NickName,
+		IsGroup:        msg.IsGroup(),
+		MessageType:    msg.Type,
+	}
+	// 非文本消息的内容为 xml 或媒体地址, 不记录
+	if msg.IsText() {
+		record.Message = msg.Content
+	}
+	return record
+}
+
+// completeWithAudit 请求GPT, 并把 prompt, 模型参数, 回复, 耗时和 token 用量记入审计记录.
+// 平台实现了 channel.CompletionRecorder 时, 把实际使用的模型和 token 用量交给平台
+func completeWithAudit(msg *channel.Message, record *audit.Record, requestText string, settings config.Settings, requester usage.Requester) (string, error) {
+	record.SetSettings(settings)
+	record.Prompt = requestText
+	completion, err := gpt.Complete(requestText, settings, requester)
+	record.Response = completion.Text
+	record.LatencyMs = completion.Latency.Milliseconds()
+	record.PromptTokens = completion.PromptTokens
+	record.CompletionTokens = completion.CompletionTokens
+	if recorder, ok := msg.Channel.(channel.CompletionRecorder); ok && err == nil {
+		recorder.RecordCompletion(msg, completion.Model, completion.PromptTokens, completion.CompletionTokens)
+	}
+	return completion.Text, err
+}
@@ -3,10 +3,14 @@ package handlers
 import (
 	"errors"
 	"fmt"
-	"github.com/coolseven/wechatbot-chatgpt/gpt"
+	"github.com/coolseven/wechatbot-chatgpt/audit"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
 	"github.com/coolseven/wechatbot-chatgpt/service"
-	"github.com/eatmoreapple/openwechat"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
 	"strings"
 )
 
@@ -14,62 +18,49 @@ var _ MessageHandlerInterface = (*GroupMessageHandler)(nil)
 
 // GroupMessageHandler 群消息处理
 type GroupMessageHandler struct {
-	// 获取自己
-	self *openwechat.Self
-	// 群
-	group *openwechat.Group
-	// 接收到消息
-	msg *openwechat.Message
-	// 发送的用户
-	sender *openwechat.User
+	// 接收到消息, 发送者为群成员, 会话为群
+	msg *channel.Message
 	// 实现的用户业务
 	service service.UserServiceInterface
+	// 会话生效的配置
+	settings config.Settings
+	// 带会话 id 的日志
+	log *logger.Entry
+	// 审计记录, 处理结束后写入 audit_file
+	record *audit.Record
 }
 
-func GroupMessageContextHandler() func(ctx *openwechat.MessageContext) {
-	return func(ctx *openwechat.MessageContext) {
-		msg := ctx.Message
-		// 获取用户消息处理器
-		handler, err := NewGroupMessageHandler(msg)
-		if err != nil {
-			logger.Warning(fmt.Sprintf("init group message handler error: %s", err))
-			return
-		}
+func GroupMessageContextHandler() channel.Handler {
+	return func(msg *channel.Message) {
+		stats.IncMessagesReceived()
+		// 获取群消息处理器
+		handler := NewGroupMessageHandler(msg)
 
-		// 处理用户消息
-		err = handler.handle()
+		// 处理群消息
+		err := handler.handle()
 		if err != nil {
+			stats.SetLastError(err)
+			stats.IncError(stats.ErrorWechatMessage)
 			logger.Warning(fmt.Sprintf("handle group message error: %s", err))
 		}
 	}
 }
 
 // NewGroupMessageHandler 创建群消息处理器
-func NewGroupMessageHandler(msg *openwechat.Message) (MessageHandlerInterface, error) {
-	sender, err := msg.Sender()
-	if err != nil {
-		return nil, err
-	}
-	group := &openwechat.Group{User: sender}
-	groupSender, err := msg.SenderInGroup()
-	if err != nil {
-		return nil, err
-	}
-
-	userService := service.NewUserService(c, groupSender)
-	handler := &GroupMessageHandler{
-		self:    sender.Self(),
-		msg:     msg,
-		group:   group,
-		sender:  groupSender,
-		service: userService,
+func NewGroupMessageHandler(msg *channel.Message) MessageHandlerInterface {
+	userService := service.NewUserService(c, msg.Conversation.ID, msg.Sender)
+	return &GroupMessageHandler{
+		msg:      msg,
+		service:  userService,
+		settings: settingsFor(msg, userService),
+		log:      logger.WithConversation(redact.Identity(msg.Conversation.ID)).WithFields(logger.Fields{"sender": redact.Identity(msg.Sender.NickName)}),
+		record:   newAuditRecord(msg),
 	}
-	return handler, nil
-
 }
 
 // handle 处理消息
-func (g *GroupMessageHandler) handle() error {
+func (g *GroupMessageHandler) handle() (err error) {
+	defer func() { audit.Write(g.record, err) }()
 	if g.msg.IsText() {
 		return g.ReplyText()
 	}
@@ -78,70 +69,94 @@ func (g *GroupMessageHandler) handle() error {
 
 // ReplyText 发息送文本消到群
 func (g *GroupMessageHandler) ReplyText() error {
-	logger.Info(fmt.Sprintf("Received Group %v Text Msg : %v", g.group.NickName, g.msg.Content))
+	g.log.Info(fmt.Sprintf("Received Group %v Text Msg : %v", redact.Identity(g.msg.Conversation.Name), redact.Body(g.msg.Content)))
 	var (
 		err   error
 		reply string
 	)
 
-	// 1.不是@的不处理
-	if !g.msg.IsAt() {
+	// 1.不满足触发模式的不处理，默认只处理@我的消息. 没有昵称的渠道在转换消息时已去掉@机器人
+	content := g.msg.Content
+	if selfName := g.msg.Channel.SelfName(); selfName != "" {
+		content = strings.ReplaceAll(content, "@"+selfName, "")
+	}
+	question, triggered := matchTrigger(g.settings, g.msg.IsAt, strings.TrimSpace(content))
+	if !triggered {
 		return nil
 	}
+	g.record.Triggered = true
 
 	// 2.获取请求的文本，如果为空字符串不处理
-	requestText := g.getRequestText()
+	requestText := g.getRequestText(question)
 	if requestText == "" {
-		logger.Info("user message is null")
+		g.log.Info("user message is null")
 		return nil
 	}
 
 	// 3.请求GPT获取回复
-	reply, err = gpt.Completions(requestText)
+	reply, err = completeWithAudit(g.msg, g.record, requestText, g.settings, usage.Requester{
+		UserID:  g.msg.Sender.ID,
+		User:    g.msg.Sender.NickName,
+		GroupID: g.msg.Conversation.ID,
+		Group:   g.msg.Conversation.Name,
+	})
 	if err != nil {
 		// 2.1 将GPT请求失败信息输出给用户，省得整天来问又不知道日志在哪里。
 		errMsg := fmt.Sprintf("gpt request error: %v", err)
-		_, err = g.msg.ReplyText(errMsg)
+		g.record.Error = err.Error()
+		g.record.Reply = errMsg
+		err = g.msg.ReplyText(errMsg)
 		if err != nil {
+			stats.IncError(stats.ErrorWechatReply)
 			return errors.New(fmt.Sprintf("response group error: %v ", err))
 		}
 		return err
 	}
 
 	// 4.设置上下文，并响应信息给用户
-	g.service.SetUserSessionContext(requestText, reply)
-	_, err = g.msg.ReplyText(g.buildReplyText(reply))
+	if g.settings.ContextEnabled {
+		g.service.SetUserSessionContext(requestText, reply)
+	}
+	replyText := g.buildReplyText(question, reply)
+	g.record.Reply = replyText
+	err = g.msg.ReplyText(replyText)
 	if err != nil {
+		stats.IncError(stats.ErrorWechatReply)
 		return errors.New(fmt.Sprintf("response user error: %v ", err))
 	}
+	replySent("text", chatGroup)
+	stats.RecordMessage(stats.MessageRecord{
+		ConversationID: g.msg.Conversation.ID,
+		Conversation:   g.msg.Conversation.Name,
+		Sender:         g.msg.Sender.NickName,
+		Content:        question,
+		Reply:          replyText,
+	}, true)
 
 	// 5.返回错误信息
 	return err
 }
 
-// getRequestText 获取请求接口的文本，要做一些清洗
-func (g *GroupMessageHandler) getRequestText() string {
+// getRequestText 获取请求接口的文本，要做一些清洗，question 为去掉@和触发前缀后的问题
+func (g *GroupMessageHandler) getRequestText(question string) string {
 	// 1.去除空格以及换行
-	requestText := strings.TrimSpace(g.msg.Content)
-	requestText = strings.Trim(g.msg.Content, "\n")
-
-	// 2.替换掉当前用户名称
-	replaceText := "@" + g.self.NickName
-	requestText = strings.TrimSpace(strings.ReplaceAll(g.msg.Content, replaceText, ""))
+	requestText := strings.TrimSpace(question)
 	if requestText == "" {
 		return ""
 	}
 
-	// 3.获取上下文，拼接在一起，如果字符长度超出4000，截取为4000。（GPT按字符长度算），达芬奇3最大为4068，也许后续为了适应要动态进行判断。
-	sessionText := g.service.GetUserSessionContext()
-	if sessionText != "" {
-		requestText = sessionText + "\n" + requestText
+	// 2.获取上下文，拼接在一起，如果字符长度超出4000，截取为4000。（GPT按字符长度算），达芬奇3最大为4068，也许后续为了适应要动态进行判断。
+	if g.settings.ContextEnabled {
+		sessionText := g.service.GetUserSessionContext()
+		if sessionText != "" {
+			requestText = sessionText + "\n" + requestText
+		}
 	}
 	if len(requestText) >= 4000 {
 		requestText = requestText[:4000]
 	}
 
-	// 4.检查用户发送文本是否包含结束标点符号
+	// 3.检查用户发送文本是否包含结束标点符号
 	punctuation := ",.;!?，。！？、…"
 	runeRequestText := []rune(requestText)
 	lastChar := string(runeRequestText[len(runeRequestText)-1:])
@@ -149,14 +164,14 @@ func (g *GroupMessageHandler) getRequestText() string {
 		requestText = requestText + "？" // 判断最后字符是否加了标点，没有的话加上句号，避免openai自动补齐引起混乱。
 	}
 
-	// 5.返回请求文本
+	// 4.返回请求文本
 	return requestText
 }
 
 // buildReply 构建回复文本
-func (g *GroupMessageHandler) buildReplyText(reply string) string {
+func (g *GroupMessageHandler) buildReplyText(question, reply string) string {
 	// 1.获取@我的用户
-	atText := "@" + g.sender.NickName
+	atText := "@" + g.msg.Sender.NickName
 	textSplit := strings.Split(reply, "\n\n")
 	if len(textSplit) > 1 {
 		trimText := textSplit[0]
@@ -167,9 +182,10 @@ func (g *GroupMessageHandler) buildReplyText(reply string) string {
 		return atText + " 请求得不到任何有意义的回复，请具体提出问题。"
 	}
 
-	// 2.拼接回复,@我的用户，问题，回复
-	replaceText := "@" + g.self.NickName
-	question := strings.TrimSpace(strings.ReplaceAll(g.msg.Content, replaceText, ""))
+	// 2.拼接回复,@我的用户，问题，回复前缀，回复
+	if g.settings.ReplyPrefix != "" {
+		reply = g.settings.ReplyPrefix + "\n" + reply
+	}
 	reply = atText + "\n" + question + "\n --------------------------------\n" + reply
 	reply = strings.Trim(reply, "\n")
 
@@ -2,18 +2,18 @@ package handlers
 
 import (
 	"fmt"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
 	"github.com/coolseven/wechatbot-chatgpt/config"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
-	"github.com/eatmoreapple/openwechat"
+	"github.com/coolseven/wechatbot-chatgpt/service"
 	"github.com/patrickmn/go-cache"
-	"github.com/skip2/go-qrcode"
-	"log"
-	"runtime"
 	"strings"
 	"time"
+	"unicode"
 )
 
-var c = cache.New(config.LoadConfig().SessionTimeout, time.Minute*5)
+// c 会话上下文和人设的缓存, 写入时都会指定过期时间, 因此不在包初始化时读取配置
+var c = cache.New(cache.NoExpiration, time.Minute*5)
 
 // MessageHandlerInterface 消息处理接口
 type MessageHandlerInterface interface {
@@ -21,52 +21,107 @@ type MessageHandlerInterface interface {
 	ReplyText() error
 }
 
-// QrCodeCallBack 登录扫码回调，
-func QrCodeCallBack(uuid string) {
-	if runtime.GOOS == "windows" {
-		// 运行在Windows系统上
-		openwechat.PrintlnQrcodeUrl(uuid)
-	} else {
-		log.Println("login in linux")
-		url := "https://login.weixin.qq.com/l/" + uuid
-		log.Printf("如果二维码无法扫描，请缩小控制台尺寸，或更换命令行工具，缩小二维码像素")
-		q, _ := qrcode.New(url, qrcode.High)
-		fmt.Println(q.ToSmallString(true))
+// identityOf 群或用户的身份, 用于匹配配置中的 profile
+func identityOf(id, nickName string) *config.Identity {
+	return &config.Identity{ID: id, NickName: nickName}
+}
+
+// settingsFor 计算消息所在会话生效的配置, 用户切换过人设时使用该人设
+func settingsFor(msg *channel.Message, userService service.UserServiceInterface) config.Settings {
+	cfg := config.LoadConfig()
+	var groupIdentity *config.Identity
+	if msg.IsGroup() {
+		groupIdentity = identityOf(msg.Conversation.ID, msg.Conversation.Name)
+	}
+	settings := cfg.SettingsFor(groupIdentity, identityOf(msg.Sender.ID, msg.Sender.NickName))
+	// 多账号模式下账号指定的 profile
+	if profiled, ok := msg.Channel.(channel.Profiled); ok {
+		settings = cfg.WithProfile(settings, profiled.Profile())
 	}
+	return cfg.WithPersona(settings, userService.GetUserPersona())
 }
 
-func NewHandler() (msgFunc func(msg *openwechat.Message), err error) {
-	dispatcher := openwechat.NewMessageMatchDispatcher()
+// matchTrigger 按会话的触发模式判断消息是否需要回复, 返回去掉触发前缀后的文本
+func matchTrigger(settings config.Settings, isAt bool, text string) (string, bool) {
+	switch settings.TriggerMode {
+	case config.TriggerAlways:
+		return text, true
+	case config.TriggerPrefix:
+		if !strings.HasPrefix(text, settings.TriggerPrefix) {
+			return "", false
+		}
+		return strings.TrimSpace(strings.TrimPrefix(text, settings.TriggerPrefix)), true
+	default:
+		return text, isAt
+	}
+}
 
-	// 清空会话
-	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return strings.Contains(message.Content, config.LoadConfig().SessionClearToken)
-	}, TokenMessageContextHandler())
+// route 按顺序匹配的消息路由
+type route struct {
+	match   func(msg *channel.Message) bool
+	handler channel.Handler
+}
 
-	// 处理群消息
-	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return message.IsSendByGroup()
-	}, GroupMessageContextHandler())
+// NewHandler 创建消息处理函数, 消息交给第一个匹配的处理器, 与消息平台无关
+func NewHandler() (msgFunc channel.Handler, err error) {
+	routes := []route{
+		// 清空会话
+		{isClearCommand, TokenMessageContextHandler()},
+		// 人设
+		{isPersonaCommand, PersonaMessageContextHandler()},
+		// 用量报告
+		{isUsageCommand, UsageMessageContextHandler()},
+		// 好友申请
+		{func(msg *channel.Message) bool { return msg.Type == channel.TypeFriendAdd }, friendAddHandler},
+		// 处理群消息
+		{(*channel.Message).IsGroup, GroupMessageContextHandler()},
+		// 私聊
+		{func(msg *channel.Message) bool { return true }, UserMessageContextHandler()},
+	}
 
-	// 好友申请
-	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return message.IsFriendAdd()
-	}, func(ctx *openwechat.MessageContext) {
-		msg := ctx.Message
-		if config.LoadConfig().AutoPass {
-			_, err := msg.Agree("")
-			if err != nil {
-				logger.Warning(fmt.Sprintf("add friend agree error : %v", err))
+	// 统计所有收到的消息后再分发
+	return func(msg *channel.Message) {
+		observeMessage(msg)
+		for _, r := range routes {
+			if r.match(msg) {
+				r.handler(msg)
 				return
 			}
 		}
-	})
+	}, nil
+}
 
-	// 私聊
-	// 获取用户消息处理器
-	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return !(strings.Contains(message.Content, config.LoadConfig().SessionClearToken) || message.IsSendByGroup() || message.IsFriendAdd())
-	}, UserMessageContextHandler())
+// parseCommand 消息以口令开头时返回口令后的参数. 群消息先去掉@机器人, 口令后必须是空白或消息结尾
+func parseCommand(msg *channel.Message, command string) ([]string, bool) {
+	if command == "" || !msg.IsText() {
+		return nil, false
+	}
+	content := msg.Content
+	if msg.IsGroup() && msg.Channel != nil && msg.Channel.SelfName() != "" {
+		content = strings.ReplaceAll(content, "@"+msg.Channel.SelfName(), "")
+	}
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, command) {
+		return nil, false
+	}
+	rest := content[len(command):]
+	if rest != "" && strings.TrimLeftFunc(rest, unicode.IsSpace) == rest {
+		return nil, false
+	}
+	return strings.Fields(rest), true
+}
 
-	return dispatcher.AsMessageHandler(), nil
+// isClearCommand 消息是否为清空会话口令
+func isClearCommand(msg *channel.Message) bool {
+	return strings.Contains(msg.Content, config.LoadConfig().SessionClearToken)
+}
+
+// friendAddHandler 按配置自动通过好友申请
+func friendAddHandler(msg *channel.Message) {
+	if !config.LoadConfig().AutoPass {
+		return
+	}
+	if err := msg.AcceptFriend(); err != nil {
+		logger.Warning(fmt.Sprintf("add friend agree error : %v", err))
+	}
 }
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+)
+
+// fakeChannel 只提供机器人昵称的渠道
+type fakeChannel struct {
+	selfName string
+}
+
+func (f fakeChannel) Name() string                                       { return "fake" }
+func (f fakeChannel) SelfName() string                                   { return f.selfName }
+func (f fakeChannel) ReplyText(msg *channel.Message, text string) error  { return nil }
+func (f fakeChannel) ReplyImage(msg *channel.Message, r io.Reader) error { return nil }
+func (f fakeChannel) AcceptFriend(msg *channel.Message) error            { return nil }
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		group    bool
+		msgType  string
+		wantOK   bool
+		wantArgs string
+	}{
+		{name: "command only", content: "/persona", wantOK: true, wantArgs: "[]"},
+		{name: "with args", content: "  /persona translator  ", wantOK: true, wantArgs: "[translator]"},
+		{name: "mentioned in text", content: "what does /persona do?", wantOK: false},
+		{name: "longer word", content: "/personas", wantOK: false},
+		{name: "group with mention", content: "@bot /persona reset", group: true, wantOK: true, wantArgs: "[reset]"},
+		{name: "group mention after command", content: "/persona list @bot", group: true, wantOK: true, wantArgs: "[list]"},
+		{name: "group mentioning command", content: "@bot 请解释 /persona", group: true, wantOK: false},
+		{name: "not text", content: "/persona", msgType: channel.TypePicture, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &channel.Message{
+				Channel:      fakeChannel{selfName: "bot"},
+				Type:         channel.TypeText,
+				Content:      tt.content,
+				Conversation: channel.Conversation{IsGroup: tt.group},
+			}
+			if tt.msgType != "" {
+				msg.Type = tt.msgType
+			}
+			args, ok := parseCommand(msg, "/persona")
+			if ok != tt.wantOK {
+				t.Fatalf("parseCommand(%q) ok = %v, want %v", tt.content, ok, tt.wantOK)
+			}
+			if ok && fmt.Sprint(args) != tt.wantArgs {
+				t.Errorf("parseCommand(%q) args = %v, want %s", tt.content, args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseCommandEmpty(t *testing.T) {
+	msg := &channel.Message{Channel: fakeChannel{}, Type: channel.TypeText, Content: "hello"}
+	if _, ok := parseCommand(msg, ""); ok {
+		t.Error("empty command should never match")
+	}
+}
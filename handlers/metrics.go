@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/metrics"
+	"github.com/coolseven/wechatbot-chatgpt/service"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+)
+
+// 会话类型, 用于指标的 chat 标签
+const (
+	chatGroup   = "group"
+	chatPrivate = "private"
+)
+
+var (
+	messagesReceivedTotal = metrics.NewCounter("wechatbot_messages_received_total",
+		"WeChat messages received by message type and chat kind.", "type", "chat")
+	repliesSentTotal = metrics.NewCounter("wechatbot_replies_sent_total",
+		"Replies sent by reply type and chat kind.", "type", "chat")
+)
+
+func init() {
+	metrics.NewGaugeFunc("wechatbot_sessions", "Conversation contexts that have not expired.", func() float64 {
+		return float64(service.SessionCount(c))
+	})
+}
+
+// chatKind 消息所在会话的类型
+func chatKind(msg *channel.Message) string {
+	if msg.IsGroup() {
+		return chatGroup
+	}
+	return chatPrivate
+}
+
+// observeMessage 统计收到的消息
+func observeMessage(msg *channel.Message) {
+	messagesReceivedTotal.Inc(msg.Type, chatKind(msg))
+}
+
+// replySent 统计发出的回复, replyType 为 text 或 image
+func replySent(replyType, chat string) {
+	stats.IncRepliesSent()
+	repliesSentTotal.Inc(replyType, chat)
+}
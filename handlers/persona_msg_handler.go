@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
+	"github.com/coolseven/wechatbot-chatgpt/service"
+	"strings"
+)
+
+var _ MessageHandlerInterface = (*PersonaMessageHandler)(nil)
+
+// PersonaMessageHandler 人设口令处理器, 查看和切换人设
+type PersonaMessageHandler struct {
+	// 接收到消息
+	msg *channel.Message
+	// 实现的用户业务
+	service service.UserServiceInterface
+	// 会话生效的配置
+	settings config.Settings
+}
+
+// isPersonaCommand 消息是否为人设口令
+func isPersonaCommand(msg *channel.Message) bool {
+	_, ok := parseCommand(msg, config.LoadConfig().PersonaCommand)
+	return ok
+}
+
+func PersonaMessageContextHandler() channel.Handler {
+	return func(msg *channel.Message) {
+		// 获取人设口令处理器
+		handler := NewPersonaMessageHandler(msg)
+
+		// 处理人设口令
+		err := handler.handle()
+		if err != nil {
+			logger.Warning(fmt.Sprintf("handle persona message error: %s", err))
+		}
+	}
+}
+
+// NewPersonaMessageHandler 人设口令处理器
+func NewPersonaMessageHandler(msg *channel.Message) MessageHandlerInterface {
+	userService := service.NewUserService(c, msg.Conversation.ID, msg.Sender)
+	return &PersonaMessageHandler{
+		msg:      msg,
+		service:  userService,
+		settings: settingsFor(msg, userService),
+	}
+}
+
+// handle 处理口令
+func (p *PersonaMessageHandler) handle() error {
+	// 群里只处理@我的口令
+	if p.msg.IsGroup() && !p.msg.IsAt {
+		return nil
+	}
+	return p.ReplyText()
+}
+
+// ReplyText 回复人设列表或切换结果
+func (p *PersonaMessageHandler) ReplyText() error {
+	cfg := config.LoadConfig()
+	args, _ := parseCommand(p.msg, cfg.PersonaCommand)
+
+	var reply string
+	switch {
+	case len(args) == 0 || args[0] == "list":
+		reply = p.listPersonas(cfg)
+	case args[0] == "reset":
+		// 恢复默认人设, 清空上下文
+		p.service.SetUserPersona("")
+		p.service.ClearUserSessionContext()
+		reply = "已恢复默认人设，上下文已经清空。"
+	default:
+		name := args[0]
+		if _, ok := cfg.Personas[name]; !ok {
+			reply = fmt.Sprintf("人设 %s 不存在。\n%s", name, p.listPersonas(cfg))
+			break
+		}
+		// 切换人设, 清空上下文, 避免旧人设的对话影响新人设
+		p.service.SetUserPersona(name)
+		p.service.ClearUserSessionContext()
+		logger.Info(fmt.Sprintf("user %v switch persona to %s", redact.Identity(p.msg.Sender.NickName), name))
+		reply = fmt.Sprintf("已切换为人设 %s，上下文已经清空。", name)
+	}
+
+	if p.msg.IsGroup() {
+		reply = "@" + p.msg.Sender.NickName + "\n" + reply
+	}
+	return p.msg.ReplyText(reply)
+}
+
+// listPersonas 人设列表, 标记当前使用的人设
+func (p *PersonaMessageHandler) listPersonas(cfg *config.Configuration) string {
+	names := cfg.PersonaNames()
+	if len(names) == 0 {
+		return "当前没有可用的人设。"
+	}
+
+	lines := []string{"可用的人设："}
+	for _, name := range names {
+		line := "  " + name
+		if description := cfg.Personas[name].Description; description != "" {
+			line += " - " + description
+		}
+		if name == p.settings.Persona {
+			line += " (当前)"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("发送 \"%s 名称\" 切换人设，\"%s reset\" 恢复默认。", cfg.PersonaCommand, cfg.PersonaCommand))
+	return strings.Join(lines, "\n")
+}
@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"fmt"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
 	"github.com/coolseven/wechatbot-chatgpt/service"
-	"github.com/eatmoreapple/openwechat"
 )
 
 var _ MessageHandlerInterface = (*TokenMessageHandler)(nil)
@@ -12,24 +12,18 @@ var _ MessageHandlerInterface = (*TokenMessageHandler)(nil)
 // TokenMessageHandler 口令消息处理器
 type TokenMessageHandler struct {
 	// 接收到消息
-	msg *openwechat.Message
-	// 发送的用户
-	sender *openwechat.User
+	msg *channel.Message
 	// 实现的用户业务
 	service service.UserServiceInterface
 }
 
-func TokenMessageContextHandler() func(ctx *openwechat.MessageContext) {
-	return func(ctx *openwechat.MessageContext) {
-		msg := ctx.Message
+func TokenMessageContextHandler() channel.Handler {
+	return func(msg *channel.Message) {
 		// 获取口令消息处理器
-		handler, err := NewTokenMessageHandler(msg)
-		if err != nil {
-			logger.Warning(fmt.Sprintf("init token message handler error: %s", err))
-		}
+		handler := NewTokenMessageHandler(msg)
 
-		// 获取口令消息处理器
-		err = handler.handle()
+		// 处理口令消息
+		err := handler.handle()
 		if err != nil {
 			logger.Warning(fmt.Sprintf("handle token message error: %s", err))
 		}
@@ -38,22 +32,11 @@ func TokenMessageContextHandler() func(ctx *openwechat.MessageContext) {
 }
 
 // NewTokenMessageHandler 口令消息处理器
-func NewTokenMessageHandler(msg *openwechat.Message) (MessageHandlerInterface, error) {
-	sender, err := msg.Sender()
-	if err != nil {
-		return nil, err
-	}
-	if msg.IsComeFromGroup() {
-		sender, err = msg.SenderInGroup()
-	}
-	userService := service.NewUserService(c, sender)
-	handler := &TokenMessageHandler{
+func NewTokenMessageHandler(msg *channel.Message) MessageHandlerInterface {
+	return &TokenMessageHandler{
 		msg:     msg,
-		sender:  sender,
-		service: userService,
+		service: service.NewUserService(c, msg.Conversation.ID, msg.Sender),
 	}
-
-	return handler, nil
 }
 
 // handle 处理口令
@@ -66,14 +49,14 @@ func (t *TokenMessageHandler) ReplyText() error {
 	logger.Info("user clear token")
 	t.service.ClearUserSessionContext()
 	var err error
-	if t.msg.IsComeFromGroup() {
-		if !t.msg.IsAt() {
+	if t.msg.IsGroup() {
+		if !t.msg.IsAt {
 			return err
 		}
-		atText := "@" + t.sender.NickName + "上下文已经清空，请问下一个问题。"
-		_, err = t.msg.ReplyText(atText)
+		atText := "@" + t.msg.Sender.NickName + "上下文已经清空，请问下一个问题。"
+		err = t.msg.ReplyText(atText)
 	} else {
-		_, err = t.msg.ReplyText("上下文已经清空，请问下一个问题。")
+		err = t.msg.ReplyText("上下文已经清空，请问下一个问题。")
 	}
 	return err
 }
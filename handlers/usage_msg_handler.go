@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
+	"time"
+)
+
+var _ MessageHandlerInterface = (*UsageMessageHandler)(nil)
+
+// UsageMessageHandler 用量报告口令处理器, 仅管理员可用
+type UsageMessageHandler struct {
+	// 接收到消息
+	msg *channel.Message
+}
+
+// usagePeriodAliases 口令中周期的别名
+var usagePeriodAliases = map[string]string{
+	"daily": config.ReportDaily, "day": config.ReportDaily, "日": config.ReportDaily, "今天": config.ReportDaily,
+	"weekly": config.ReportWeekly, "week": config.ReportWeekly, "周": config.ReportWeekly, "本周": config.ReportWeekly,
+	"monthly": config.ReportMonthly, "month": config.ReportMonthly, "月": config.ReportMonthly, "本月": config.ReportMonthly,
+}
+
+// isUsageCommand 消息是否为用量报告口令
+func isUsageCommand(msg *channel.Message) bool {
+	_, ok := parseCommand(msg, config.LoadConfig().UsageCommand)
+	return ok
+}
+
+func UsageMessageContextHandler() channel.Handler {
+	return func(msg *channel.Message) {
+		// 获取用量报告口令处理器
+		handler := NewUsageMessageHandler(msg)
+
+		// 处理用量报告口令
+		err := handler.handle()
+		if err != nil {
+			logger.Warning(fmt.Sprintf("handle usage message error: %s", err))
+		}
+	}
+}
+
+// NewUsageMessageHandler 用量报告口令处理器
+func NewUsageMessageHandler(msg *channel.Message) MessageHandlerInterface {
+	return &UsageMessageHandler{msg: msg}
+}
+
+// handle 处理口令
+func (u *UsageMessageHandler) handle() error {
+	// 群里只处理@我的口令
+	if u.msg.IsGroup() && !u.msg.IsAt {
+		return nil
+	}
+	return u.ReplyText()
+}
+
+// ReplyText 回复用量报告, 口令格式为 "口令 [daily|weekly|monthly] [last]", last 表示上一个完整周期
+func (u *UsageMessageHandler) ReplyText() error {
+	cfg := config.LoadConfig()
+	args, _ := parseCommand(u.msg, cfg.UsageCommand)
+
+	var reply string
+	period := config.ReportDaily
+	previous := false
+	switch {
+	case !cfg.IsAdmin(identityOf(u.msg.Sender.ID, u.msg.Sender.NickName)):
+		// 微信的用户 id 无法在客户端中查看, 记录下来便于配置 admins
+		logger.Info(fmt.Sprintf("user %q requested usage report but is not in admins", u.msg.Sender.ID))
+		reply = "只有管理员可以查看用量报告。"
+	case len(args) > 0 && usagePeriodAliases[args[0]] == "":
+		reply = fmt.Sprintf("未知的周期 %s，发送 \"%s daily|weekly|monthly [last]\" 查看本周期或上一个周期的用量。", args[0], cfg.UsageCommand)
+	default:
+		if len(args) > 0 {
+			period = usagePeriodAliases[args[0]]
+		}
+		previous = len(args) > 1 && (args[1] == "last" || args[1] == "上")
+		from, to := usage.PeriodRange(period, time.Now(), previous)
+		report, err := usage.BuildReport(period, from, to)
+		if err != nil {
+			reply = fmt.Sprintf("生成用量报告失败: %v", err)
+			break
+		}
+		reply = report.Text(cfg.Currency)
+	}
+
+	if u.msg.IsGroup() {
+		reply = "@" + u.msg.Sender.NickName + "\n" + reply
+	}
+	return u.msg.ReplyText(reply)
+}
@@ -3,11 +3,15 @@ package handlers
 import (
 	"errors"
 	"fmt"
+	"github.com/coolseven/wechatbot-chatgpt/audit"
+	"github.com/coolseven/wechatbot-chatgpt/channel"
 	"github.com/coolseven/wechatbot-chatgpt/config"
 	"github.com/coolseven/wechatbot-chatgpt/gpt"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
 	"github.com/coolseven/wechatbot-chatgpt/service"
-	"github.com/eatmoreapple/openwechat"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
 	"strings"
 )
 
@@ -16,47 +20,48 @@ var _ MessageHandlerInterface = (*UserMessageHandler)(nil)
 // UserMessageHandler 私聊消息处理
 type UserMessageHandler struct {
 	// 接收到消息
-	msg *openwechat.Message
-	// 发送的用户
-	sender *openwechat.User
+	msg *channel.Message
 	// 实现的用户业务
 	service service.UserServiceInterface
+	// 会话生效的配置
+	settings config.Settings
+	// 带会话 id 的日志
+	log *logger.Entry
+	// 审计记录, 处理结束后写入 audit_file
+	record *audit.Record
 }
 
-func UserMessageContextHandler() func(ctx *openwechat.MessageContext) {
-	return func(ctx *openwechat.MessageContext) {
-		msg := ctx.Message
-		handler, err := NewUserMessageHandler(msg)
-		if err != nil {
-			logger.Warning(fmt.Sprintf("init user message handler error: %s", err))
-		}
+func UserMessageContextHandler() channel.Handler {
+	return func(msg *channel.Message) {
+		stats.IncMessagesReceived()
+		// 获取私聊消息处理器
+		handler := NewUserMessageHandler(msg)
 
 		// 处理用户消息
-		err = handler.handle()
+		err := handler.handle()
 		if err != nil {
+			stats.SetLastError(err)
+			stats.IncError(stats.ErrorWechatMessage)
 			logger.Warning(fmt.Sprintf("handle user message error: %s", err))
 		}
 	}
 }
 
 // NewUserMessageHandler 创建私聊处理器
-func NewUserMessageHandler(message *openwechat.Message) (MessageHandlerInterface, error) {
-	sender, err := message.Sender()
-	if err != nil {
-		return nil, err
+func NewUserMessageHandler(msg *channel.Message) MessageHandlerInterface {
+	userService := service.NewUserService(c, msg.Conversation.ID, msg.Sender)
+	return &UserMessageHandler{
+		msg:      msg,
+		service:  userService,
+		settings: settingsFor(msg, userService),
+		log:      logger.WithConversation(redact.Identity(msg.Sender.ID)),
+		record:   newAuditRecord(msg),
 	}
-	userService := service.NewUserService(c, sender)
-	handler := &UserMessageHandler{
-		msg:     message,
-		sender:  sender,
-		service: userService,
-	}
-
-	return handler, nil
 }
 
 // handle 处理消息
-func (h *UserMessageHandler) handle() error {
+func (h *UserMessageHandler) handle() (err error) {
+	defer func() { audit.Write(h.record, err) }()
 	if h.msg.IsText() {
 		return h.ReplyText()
 	}
@@ -65,19 +70,26 @@ func (h *UserMessageHandler) handle() error {
 
 // ReplyText 发送文本消息到群
 func (h *UserMessageHandler) ReplyText() error {
-	logger.Info(fmt.Sprintf("Received User %v Text Msg : %v", h.sender.NickName, h.msg.Content))
+	h.log.Info(fmt.Sprintf("Received User %v Text Msg : %v", redact.Identity(h.msg.Sender.NickName), redact.Body(h.msg.Content)))
 	var (
 		reply string
 		err   error
 	)
-	// 1.获取上下文，如果字符串为空不处理
-	requestText := h.getRequestText()
+	// 1.不满足触发模式的不处理，私聊默认全部处理
+	question, triggered := matchTrigger(h.settings, true, strings.TrimSpace(h.msg.Content))
+	if !triggered {
+		return nil
+	}
+	h.record.Triggered = true
+
+	// 2.获取上下文，如果字符串为空不处理
+	requestText := h.getRequestText(question)
 	if requestText == "" {
-		logger.Info("user message is null")
+		h.log.Info("user message is null")
 		return nil
 	}
-	logger.Info(fmt.Sprintf("h.sender.NickName == %+v", h.sender.NickName))
-	// 2.向GPT发起请求，如果回复文本等于空,不回复
+	h.log.Debug(fmt.Sprintf("h.msg.Sender.NickName == %+v", redact.Identity(h.msg.Sender.NickName)))
+	// 3.向GPT发起请求，如果回复文本等于空,不回复
 
 	imageModeTriggers := []string{
 		"生成图片", "生成一张图片", "生成1张图片",
@@ -92,20 +104,23 @@ func (h *UserMessageHandler) ReplyText() error {
 	imageWanted := false
 	imageCount := 1
 	for _, imageModeTrigger := range imageModeTriggers {
-		if strings.Contains(h.msg.Content, imageModeTrigger) {
+		if !h.settings.ImageEnabled {
+			break
+		}
+		if strings.Contains(question, imageModeTrigger) {
 			imageWanted = true
 			switch imageModeTrigger {
 			case "生成图片", "生成一张图片", "生成1张图片":
 				imageCount = 1
-				imageDescription = strings.TrimPrefix(h.msg.Content, imageModeTrigger)
+				imageDescription = strings.TrimPrefix(question, imageModeTrigger)
 				h.service.SetUserSessionContext(imageDescription, "")
 			case "生成两张图片", "生成2张图片":
 				imageCount = 2
-				imageDescription = strings.TrimPrefix(h.msg.Content, imageModeTrigger)
+				imageDescription = strings.TrimPrefix(question, imageModeTrigger)
 				h.service.SetUserSessionContext(imageDescription, "")
 			case "生成三张图片", "生成3张图片":
 				imageCount = 3
-				imageDescription = strings.TrimPrefix(h.msg.Content, imageModeTrigger)
+				imageDescription = strings.TrimPrefix(question, imageModeTrigger)
 				h.service.SetUserSessionContext(imageDescription, "")
 			case "再来一张", "再来1张":
 				previousImageDescription := h.service.GetUserSessionContext()
@@ -139,58 +154,97 @@ func (h *UserMessageHandler) ReplyText() error {
 	}
 
 	if imageWanted {
-		imageFiles, err := gpt.CreateImageMedia(imageDescription, imageCount)
+		h.record.Model = gpt.ImageModel
+		h.record.Prompt = imageDescription
+		imageFiles, err := gpt.CreateImageMedia(imageDescription, imageCount, h.requester())
 		if err != nil {
 			// 2.1 将GPT请求失败信息输出给用户，省得整天来问又不知道日志在哪里。
 			errMsg := fmt.Sprintf("gpt request error: %v", err)
-			_, err = h.msg.ReplyText(errMsg)
+			h.record.Error = err.Error()
+			h.record.Reply = errMsg
+			err = h.msg.ReplyText(errMsg)
 			if err != nil {
+				stats.IncError(stats.ErrorWechatReply)
 				return errors.New(fmt.Sprintf("response user error: %v ", err))
 			}
 			return err
 		}
 		for _, imageFile := range imageFiles {
 			// 2.设置上下文，回复用户
-			_, err = h.msg.ReplyImage(imageFile)
+			err = h.msg.ReplyImage(imageFile)
 			if err != nil {
-				_, _ = h.msg.ReplyText("[reply image error]: " + err.Error())
+				_ = h.msg.ReplyText("[reply image error]: " + err.Error())
+				stats.IncError(stats.ErrorWechatReply)
 				return errors.New(fmt.Sprintf("response user error: %v ", err))
 			}
+			replySent("image", chatPrivate)
 		}
+		h.record.Reply = fmt.Sprintf("[%d images]", len(imageFiles))
+		h.recordMessage(question, h.record.Reply)
 	} else {
-		reply, err = gpt.Completions(h.getRequestText())
+		reply, err = completeWithAudit(h.msg, h.record, requestText, h.settings, h.requester())
 		if err != nil {
 			// 2.1 将GPT请求失败信息输出给用户，省得整天来问又不知道日志在哪里。
 			errMsg := fmt.Sprintf("gpt request error: %v", err)
-			_, err = h.msg.ReplyText(errMsg)
+			h.record.Error = err.Error()
+			h.record.Reply = errMsg
+			err = h.msg.ReplyText(errMsg)
 			if err != nil {
+				stats.IncError(stats.ErrorWechatReply)
 				return errors.New(fmt.Sprintf("response user error: %v ", err))
 			}
 			return err
 		}
 
 		// 2.设置上下文，回复用户
-		h.service.SetUserSessionContext(requestText, reply)
-		_, err = h.msg.ReplyText(buildUserReply(reply))
+		if h.settings.ContextEnabled {
+			h.service.SetUserSessionContext(requestText, reply)
+		}
+		replyText := buildUserReply(h.settings.ReplyPrefix, reply)
+		h.record.Reply = replyText
+		err = h.msg.ReplyText(replyText)
 		if err != nil {
+			stats.IncError(stats.ErrorWechatReply)
 			return errors.New(fmt.Sprintf("response user error: %v ", err))
 		}
+		replySent("text", chatPrivate)
+		h.recordMessage(question, replyText)
 	}
 
-	// 3.返回错误
+	// 4.返回错误
 	return err
 }
 
-// getRequestText 获取请求接口的文本，要做一些清晰
-func (h *UserMessageHandler) getRequestText() string {
+// requester 用于记录用量
+func (h *UserMessageHandler) requester() usage.Requester {
+	return usage.Requester{UserID: h.msg.Sender.ID, User: h.msg.Sender.NickName}
+}
+
+// recordMessage 记录消息及回复, 用于管理后台展示
+func (h *UserMessageHandler) recordMessage(question, reply string) {
+	stats.RecordMessage(stats.MessageRecord{
+		ConversationID: h.msg.Conversation.ID,
+		Conversation:   h.msg.Conversation.Name,
+		Sender:         h.msg.Sender.NickName,
+		Content:        question,
+		Reply:          reply,
+	}, false)
+}
+
+// getRequestText 获取请求接口的文本，要做一些清晰，question 为去掉触发前缀后的问题
+func (h *UserMessageHandler) getRequestText(question string) string {
 	// 1.去除空格以及换行
-	requestText := strings.TrimSpace(h.msg.Content)
-	requestText = strings.Trim(h.msg.Content, "\n")
+	requestText := strings.TrimSpace(question)
+	if requestText == "" {
+		return ""
+	}
 
 	// 2.获取上下文，拼接在一起，如果字符长度超出4000，截取为4000。（GPT按字符长度算），达芬奇3最大为4068，也许后续为了适应要动态进行判断。
-	sessionText := h.service.GetUserSessionContext()
-	if sessionText != "" {
-		requestText = sessionText + "\n" + requestText
+	if h.settings.ContextEnabled {
+		sessionText := h.service.GetUserSessionContext()
+		if sessionText != "" {
+			requestText = sessionText + "\n" + requestText
+		}
 	}
 	if len(requestText) >= 4000 {
 		requestText = requestText[:4000]
@@ -208,8 +262,8 @@ func (h *UserMessageHandler) getRequestText() string {
 	return requestText
 }
 
-// buildUserReply 构建用户回复
-func buildUserReply(reply string) string {
+// buildUserReply 构建用户回复, prefix 为会话生效的回复前缀
+func buildUserReply(prefix, reply string) string {
 	// 1.去除空格问号以及换行号，如果为空，返回一个默认值提醒用户
 	textSplit := strings.Split(reply, "\n\n")
 	if len(textSplit) > 1 {
@@ -224,7 +278,7 @@ func buildUserReply(reply string) string {
 	}
 
 	// 2.如果用户有配置前缀，加上前缀
-	reply = config.LoadConfig().ReplyPrefix + "\n" + reply
+	reply = prefix + "\n" + reply
 	reply = strings.Trim(reply, "\n")
 
 	// 3.返回拼接好的字符串
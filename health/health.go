@@ -0,0 +1,302 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/alert"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/gpt"
+	"github.com/coolseven/wechatbot-chatgpt/login"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/server"
+	"github.com/coolseven/wechatbot-chatgpt/stats"
+)
+
+const (
+	// syncStaleAfter 在线状态下超过该时长没有成功同步, 认为微信连接已卡死
+	// 微信的同步是长轮询, 正常情况下每 25 秒左右返回一次
+	syncStaleAfter = 3 * time.Minute
+	// openAIFailureWindow 最近一次 openai 调用失败在该时长内, 且之后没有成功调用时, 认为 openai 降级
+	openAIFailureWindow = 10 * time.Minute
+)
+
+// 依赖名称, 用于 checks 和 degraded
+const (
+	DependencyWechat     = "wechat"
+	DependencyWechatSync = "wechat_sync"
+	DependencyOpenAI     = "openai"
+	DependencyApiKeys    = "openai_api_keys"
+)
+
+// Check 单项检查的结果
+type Check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// 是否影响存活检查, 影响存活检查的项失败时 /healthz 返回 503
+	Liveness bool `json:"liveness"`
+	// 是否影响就绪检查, 影响就绪检查的项失败时 /readyz 返回 503
+	Readiness bool   `json:"readiness"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Status /status 返回的服务状态
+type Status struct {
+	// ok: 全部正常, degraded: 部分依赖异常, down: 存活检查失败
+	Status            string     `json:"status"`
+	Login             string     `json:"login"`
+	NickName          string     `json:"nick_name,omitempty"`
+	Version           string     `json:"version"`
+	StartedAt         time.Time  `json:"started_at"`
+	UptimeSeconds     int64      `json:"uptime_seconds"`
+	LastSync          *time.Time `json:"last_sync,omitempty"`
+	LastOpenAISuccess *time.Time `json:"last_openai_success,omitempty"`
+	LastOpenAIFailure *time.Time `json:"last_openai_failure,omitempty"`
+	LastOpenAIError   string     `json:"last_openai_error,omitempty"`
+	ApiKeysAvailable  int        `json:"api_keys_available"`
+	ApiKeysTotal      int        `json:"api_keys_total"`
+	MessagesReceived  uint64     `json:"messages_received"`
+	RepliesSent       uint64     `json:"replies_sent"`
+	LastError         string     `json:"last_error,omitempty"`
+	Reconnects        int        `json:"reconnects"`
+	DowntimeSeconds   int64      `json:"downtime_seconds"`
+	Degraded          []string   `json:"degraded"`
+	Checks            []Check    `json:"checks"`
+
+	// 多账号模式下各个账号的登录状态
+	Accounts []AccountStatus `json:"accounts,omitempty"`
+}
+
+// AccountStatus 一个微信账号的登录状态
+type AccountStatus struct {
+	Account  string     `json:"account"`
+	Login    string     `json:"login"`
+	NickName string     `json:"nick_name,omitempty"`
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	// 掉线后重新登录成功的次数和累计的离线时长
+	Reconnects      int   `json:"reconnects"`
+	DowntimeSeconds int64 `json:"downtime_seconds"`
+}
+
+// Register 在内置 http 服务上注册 /healthz, /readyz 和 /status
+func Register() {
+	server.HandleFunc("/healthz", healthz)
+	server.HandleFunc("/readyz", readyz)
+	server.HandleFunc("/status", status)
+}
+
+// Checks 检查各项依赖的状态
+func Checks() []Check {
+	now := time.Now()
+	var checks []Check
+
+	// 1. 微信登录状态, 等待扫码、登录中和掉线后重新登录中不算存活失败, 避免扫码期间被重启. 未启用个人微信时不检查
+	// 2. 微信同步, 在线但长时间没有同步成功说明连接已卡死
+	if config.LoadConfig().WechatEnabled {
+		checks = append(checks, wechatChecks(now)...)
+	}
+
+	// 3. openai 调用, 只标记降级, 不影响存活和就绪
+	openAI := Check{Name: DependencyOpenAI, OK: true}
+	if failedAt, lastError := stats.LastOpenAIFailure(); now.Sub(failedAt) < openAIFailureWindow && failedAt.After(stats.LastOpenAISuccess()) {
+		openAI.OK = false
+		openAI.Message = lastError
+	}
+	checks = append(checks, openAI)
+
+	// 4. api key 池, 没有可用的 key 时无法回复, 不再就绪
+	available, total := gpt.KeyStatus()
+	checks = append(checks, Check{
+		Name:      DependencyApiKeys,
+		OK:        available == total,
+		Readiness: available == 0,
+		Message:   fmt.Sprintf("%d/%d available", available, total),
+	})
+	return checks
+}
+
+// wechatChecks 各个微信账号的检查项. 多账号模式下单个账号异常只标记降级, 全部账号都离线时才算存活失败
+func wechatChecks(now time.Time) []Check {
+	var checks []Check
+	states := login.All()
+	multiAccount := config.LoadConfig().MultiAccount()
+	online, pending := 0, false
+	for _, state := range states {
+		name := DependencyWechat
+		if state.Account() != "" {
+			name += ":" + state.Account()
+		}
+		loginStatus := state.Status()
+		wechat := Check{Name: name, OK: loginStatus == login.StatusOnline, Liveness: !multiAccount, Readiness: !multiAccount, Message: loginStatus}
+		if loginStatus == login.StatusWaitingScan || loginStatus == login.StatusLoggingIn || loginStatus == login.StatusReconnecting {
+			wechat.Liveness = false
+			pending = true
+		}
+		checks = append(checks, wechat)
+		if loginStatus != login.StatusOnline {
+			continue
+		}
+		online++
+		sync := Check{Name: DependencyWechatSync, OK: true, Liveness: !multiAccount, Readiness: !multiAccount}
+		if state.Account() != "" {
+			sync.Name += ":" + state.Account()
+		}
+		if lastSync := state.LastSync(); now.Sub(lastSync) > syncStaleAfter {
+			sync.OK = false
+			sync.Message = fmt.Sprintf("no successful sync for %s", alert.HumanDuration(now.Sub(lastSync)))
+		}
+		checks = append(checks, sync)
+	}
+	if multiAccount {
+		checks = append(checks, Check{
+			Name:      DependencyWechat,
+			OK:        online > 0,
+			Liveness:  !pending,
+			Readiness: true,
+			Message:   fmt.Sprintf("%d/%d accounts online", online, len(states)),
+		})
+	}
+	return checks
+}
+
+// Current 当前的服务状态
+func Current() Status {
+	checks := Checks()
+	available, total := gpt.KeyStatus()
+	failedAt, lastOpenAIError := stats.LastOpenAIFailure()
+	s := Status{
+		Status:            "ok",
+		Login:             login.StatusLoggingIn,
+		Version:           stats.Version,
+		StartedAt:         stats.StartedAt,
+		UptimeSeconds:     int64(stats.Uptime() / time.Second),
+		LastSync:          timeOrNil(stats.LastSync()),
+		LastOpenAISuccess: timeOrNil(stats.LastOpenAISuccess()),
+		LastOpenAIFailure: timeOrNil(failedAt),
+		LastOpenAIError:   lastOpenAIError,
+		ApiKeysAvailable:  available,
+		ApiKeysTotal:      total,
+		MessagesReceived:  stats.MessagesReceived(),
+		RepliesSent:       stats.RepliesSent(),
+		LastError:         stats.LastError(),
+		Degraded:          []string{},
+		Checks:            checks,
+	}
+	// 单账号模式下只有一个名称为空的账号, 多账号模式下 login 和 nick_name 为第一个账号的状态, reconnects 和 downtime_seconds 为全部账号的合计
+	for i, state := range login.All() {
+		if i == 0 {
+			s.Login, s.NickName = state.Status(), state.NickName()
+		}
+		reconnects, downtime := state.Reconnects()
+		s.Reconnects += reconnects
+		s.DowntimeSeconds += int64(downtime / time.Second)
+		if state.Account() != "" {
+			s.Accounts = append(s.Accounts, AccountStatus{
+				Account:         state.Account(),
+				Login:           state.Status(),
+				NickName:        state.NickName(),
+				LastSync:        timeOrNil(state.LastSync()),
+				Reconnects:      reconnects,
+				DowntimeSeconds: int64(downtime / time.Second),
+			})
+		}
+	}
+	for _, check := range checks {
+		if check.OK {
+			continue
+		}
+		s.Degraded = append(s.Degraded, check.Name)
+		if check.Liveness {
+			s.Status = "down"
+		} else if s.Status == "ok" {
+			s.Status = "degraded"
+		}
+	}
+	return s
+}
+
+// healthz 存活检查, 失败时应重启服务
+func healthz(w http.ResponseWriter, r *http.Request) {
+	probe(w, func(check Check) bool { return check.Liveness })
+}
+
+// readyz 就绪检查, 失败时不应再把请求路由到该实例
+func readyz(w http.ResponseWriter, r *http.Request) {
+	probe(w, func(check Check) bool { return check.Liveness || check.Readiness })
+}
+
+// status 服务状态详情, 始终返回 200. 未携带管理员 token 时去掉昵称和错误信息
+func status(w http.ResponseWriter, r *http.Request) {
+	s := Current()
+	if !authorized(r) {
+		s = s.public()
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// authorized 请求是否通过 Authorization: Bearer 请求头携带了管理员 token
+func authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	return strings.HasPrefix(header, "Bearer ") && config.LoadConfig().ValidAdminToken(strings.TrimPrefix(header, "Bearer "))
+}
+
+// public 去掉昵称、错误信息等不应公开的内容, 只保留状态和计数
+func (s Status) public() Status {
+	s.NickName = ""
+	s.LastOpenAIError = ""
+	s.LastError = ""
+	checks := make([]Check, len(s.Checks))
+	for i, check := range s.Checks {
+		check.Message = ""
+		checks[i] = check
+	}
+	s.Checks = checks
+	if s.Accounts != nil {
+		accounts := make([]AccountStatus, len(s.Accounts))
+		for i, account := range s.Accounts {
+			account.NickName = ""
+			accounts[i] = account
+		}
+		s.Accounts = accounts
+	}
+	return s
+}
+
+// probe 只看 counts 选出的检查项, 全部通过时返回 200, 否则返回 503
+func probe(w http.ResponseWriter, counts func(check Check) bool) {
+	result := struct {
+		Status string  `json:"status"`
+		Checks []Check `json:"checks"`
+	}{Status: "ok", Checks: []Check{}}
+	code := http.StatusOK
+	for _, check := range Checks() {
+		if !counts(check) {
+			continue
+		}
+		result.Checks = append(result.Checks, check)
+		if !check.OK {
+			result.Status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, result)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warning(fmt.Sprintf("write health response error: %v", err))
+	}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
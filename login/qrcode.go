@@ -0,0 +1,180 @@
+package login
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/alert"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/skip2/go-qrcode"
+)
+
+// QrCode 当前待扫描的登录二维码
+type QrCode struct {
+	// 微信登录的 uuid
+	UUID string
+	// 二维码内容, 即登录链接
+	URL string
+	// 二维码图片
+	PNG []byte
+	// 生成时间
+	CreatedAt time.Time
+}
+
+// QrCodeCallBack 登录扫码回调, 在控制台打印二维码, 通过告警渠道发送二维码图片, 并在二维码页面展示
+func (s *State) QrCodeCallBack(uuid string) {
+	url := "https://login.weixin.qq.com/l/" + uuid
+	if runtime.GOOS == "windows" {
+		// 运行在Windows系统上
+		openwechat.PrintlnQrcodeUrl(uuid)
+	} else {
+		log.Println("login in linux")
+		if s.account != "" {
+			log.Printf("请使用账号 %s 扫码登录", s.account)
+		}
+		log.Printf("如果二维码无法扫描，请缩小控制台尺寸，或更换命令行工具，缩小二维码像素")
+		q, _ := qrcode.New(url, qrcode.High)
+		fmt.Println(q.ToSmallString(true))
+	}
+	// 页面地址带有 token, 直接打印到控制台, 不经过会遮盖 token 参数的日志
+	if config.LoadConfig().HttpAddr != "" {
+		log.Printf("也可以打开内置 http 服务的二维码页面: %s", QrCodePagePath())
+	}
+
+	png, err := qrcode.Encode(url, qrcode.Medium, 256)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("encode login qrcode error: %v", err))
+		return
+	}
+	s.lock.Lock()
+	s.current = &QrCode{UUID: uuid, URL: url, PNG: png, CreatedAt: time.Now()}
+	s.lock.Unlock()
+
+	alert.SendImageAsync(alert.EventLoginQrCode, alert.Data{
+		Account:    s.account,
+		LoginURL:   url,
+		QrCodePage: QrCodePageURL(),
+	}, "login-qrcode.png", png)
+}
+
+// Current 当前待扫描的二维码, 已登录或尚未生成时返回 false
+func (s *State) Current() (QrCode, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.current == nil {
+		return QrCode{}, false
+	}
+	return *s.current, true
+}
+
+// pageToken 未配置 admin_token 时访问二维码页面需要的 token, 每次启动随机生成
+var pageToken = newPageToken()
+
+func newPageToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(fmt.Sprintf("generate qrcode page token error: %v", err))
+	}
+	return hex.EncodeToString(token)
+}
+
+// QrCodePagePath 二维码页面的路径. 配置了 admin_token 时在管理后台中, 否则为带随机 token 的 /qrcode
+func QrCodePagePath() string {
+	if config.LoadConfig().AdminToken != "" {
+		return "/admin/qrcode"
+	}
+	return "/qrcode?token=" + pageToken
+}
+
+// QrCodePageURL 二维码页面的外部访问地址, 未配置 public_url 时为空
+func QrCodePageURL() string {
+	publicURL := config.LoadConfig().PublicURL
+	if publicURL == "" {
+		return ""
+	}
+	return strings.TrimRight(publicURL, "/") + QrCodePagePath()
+}
+
+// TokenProtected 校验 token 参数, 用于未配置 admin_token 时的二维码页面
+func TokenProtected(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(pageToken)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+var qrCodePage = template.Must(template.New("qrcode").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="10">
+<title>wechatbot login</title>
+</head>
+<body style="text-align: center; font-family: sans-serif">
+{{range .}}
+<h3>请使用微信扫码登录{{with .Account}}账号 {{.}}{{end}}</h3>
+<img src="qrcode.png?account={{.Account}}&amp;uuid={{.UUID}}{{with .Token}}&amp;token={{.}}{{end}}" alt="login qrcode" width="256" height="256">
+<p>生成于 {{.CreatedAt.Format "2006-01-02 15:04:05"}}，页面每 10 秒自动刷新</p>
+<p><a href="{{.URL}}">{{.URL}}</a></p>
+{{else}}
+<h3>当前无需登录</h3>
+{{end}}
+</body>
+</html>
+`))
+
+// accountQrCode 页面上展示的一个账号的二维码
+type accountQrCode struct {
+	Account string
+	// 访问图片需要的 token, 在管理后台中访问时为空
+	Token string
+	QrCode
+}
+
+// QrCodePage 展示全部账号当前登录二维码的页面
+func QrCodePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	var data []accountQrCode
+	for _, state := range All() {
+		if qr, ok := state.Current(); ok {
+			data = append(data, accountQrCode{Account: state.Account(), Token: r.URL.Query().Get("token"), QrCode: qr})
+		}
+	}
+	if err := qrCodePage.Execute(w, data); err != nil {
+		logger.Warning(fmt.Sprintf("render qrcode page error: %v", err))
+	}
+}
+
+// QrCodeImage 账号当前登录二维码的图片, 账号由 account 参数指定
+func QrCodeImage(w http.ResponseWriter, r *http.Request) {
+	statesLock.RLock()
+	state, ok := states[r.URL.Query().Get("account")]
+	statesLock.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	qr, ok := state.Current()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(qr.PNG)
+}
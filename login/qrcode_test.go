@@ -0,0 +1,34 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenProtected(t *testing.T) {
+	handler := TokenProtected(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{name: "page token", url: "/qrcode?token=" + pageToken, want: http.StatusOK},
+		{name: "missing token", url: "/qrcode", want: http.StatusNotFound},
+		{name: "wrong token", url: "/qrcode?token=0123456789abcdef0123456789abcdef", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.url, w.Code, tt.want)
+			}
+		})
+	}
+	if len(pageToken) != 32 {
+		t.Errorf("page token %q should be 32 hex chars", pageToken)
+	}
+}
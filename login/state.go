@@ -0,0 +1,157 @@
+package login
+
+import (
+	"sync"
+	"time"
+)
+
+// 登录状态
+const (
+	StatusLoggingIn   = "logging_in"
+	StatusWaitingScan = "waiting_scan"
+	StatusOnline      = "online"
+	StatusOffline     = "offline"
+	// 掉线后正在自动重新登录
+	StatusReconnecting = "reconnecting"
+)
+
+// State 一个个人微信账号的登录状态
+type State struct {
+	account string
+
+	lock sync.RWMutex
+	// 待扫描的登录二维码, 已登录或尚未生成时为 nil
+	current *QrCode
+	// 在线状态的检测函数, 登录成功前为 nil
+	alive    func() bool
+	nickName string
+	// 最近一次成功同步的时间
+	lastSync time.Time
+	// 掉线的时间, 重新登录成功后清空
+	offlineSince time.Time
+	// 掉线后重新登录成功的次数和累计的离线时长
+	reconnects int
+	downtime   time.Duration
+	// 不再自动重新登录, 需要重启服务
+	stopped bool
+}
+
+var (
+	statesLock sync.RWMutex
+	states     = make(map[string]*State)
+	// 账号的注册顺序
+	accounts []string
+)
+
+// For 账号的登录状态, 不存在时创建. 单账号模式下账号名称为空
+func For(account string) *State {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+	if state, ok := states[account]; ok {
+		return state
+	}
+	state := &State{account: account}
+	states[account] = state
+	accounts = append(accounts, account)
+	return state
+}
+
+// All 全部账号的登录状态, 按注册顺序
+func All() []*State {
+	statesLock.RLock()
+	defer statesLock.RUnlock()
+	all := make([]*State, 0, len(accounts))
+	for _, account := range accounts {
+		all = append(all, states[account])
+	}
+	return all
+}
+
+// Account 账号名称, 单账号模式下为空
+func (s *State) Account() string {
+	return s.account
+}
+
+// SetOnline 登录成功后记录在线状态的检测函数和当前登录的微信昵称
+func (s *State) SetOnline(aliveFunc func() bool, selfNickName string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.alive = aliveFunc
+	s.nickName = selfNickName
+	s.current = nil
+	s.lastSync = time.Now()
+	if !s.offlineSince.IsZero() {
+		s.reconnects++
+		s.downtime += s.lastSync.Sub(s.offlineSince)
+		s.offlineSince = time.Time{}
+	}
+}
+
+// SetOffline 掉线后记录掉线时间, 之后的状态为重新登录中, 直到再次调用 SetOnline
+func (s *State) SetOffline() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.alive = nil
+	s.offlineSince = time.Now()
+}
+
+// SetStopped 放弃自动重新登录后记录为离线, 不再显示二维码
+func (s *State) SetStopped() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.alive = nil
+	s.current = nil
+	s.stopped = true
+}
+
+// Reconnects 掉线后重新登录成功的次数和累计的离线时长, 包括当前这次尚未恢复的离线时长
+func (s *State) Reconnects() (int, time.Duration) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	downtime := s.downtime
+	if !s.offlineSince.IsZero() {
+		downtime += time.Since(s.offlineSince)
+	}
+	return s.reconnects, downtime
+}
+
+// Status 当前的登录状态
+func (s *State) Status() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	switch {
+	case s.stopped:
+		return StatusOffline
+	case s.current != nil:
+		return StatusWaitingScan
+	case s.alive == nil && !s.offlineSince.IsZero():
+		return StatusReconnecting
+	case s.alive == nil:
+		return StatusLoggingIn
+	case s.alive():
+		return StatusOnline
+	default:
+		return StatusOffline
+	}
+}
+
+// NickName 当前登录的微信昵称
+func (s *State) NickName() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.nickName
+}
+
+// MarkSynced 记录一次成功的同步
+func (s *State) MarkSynced() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastSync = time.Now()
+}
+
+// LastSync 最近一次成功同步的时间
+func (s *State) LastSync() time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.lastSync
+}
@@ -1,9 +1,23 @@
 package main
 
 import (
+	"os"
+
 	"github.com/coolseven/wechatbot-chatgpt/bootstrap"
+	"github.com/coolseven/wechatbot-chatgpt/channel/console"
+	"github.com/coolseven/wechatbot-chatgpt/replay"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		// replay 子命令: 回放审计记录, 不启动机器人
+		case "replay":
+			os.Exit(replay.Run(os.Args[2:]))
+		// console 子命令: 在终端中与机器人对话, 不登录微信
+		case "console":
+			os.Exit(console.Run(os.Args[2:]))
+		}
+	}
 	bootstrap.Run()
 }
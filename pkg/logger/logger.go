@@ -1,40 +1,281 @@
 package logger
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
-var Logger *log.Logger
-var once sync.Once
+// Level 日志级别
+type Level int
 
-func init() {
-	once.Do(func() {
-		Logger = log.New(os.Stdout, "INFO", log.LstdFlags|log.Llongfile)
-	})
+// 日志级别, 低于最低级别的日志不输出
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelInfo:    "info",
+	LevelWarning: "warning",
+	LevelError:   "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel 解析日志级别, 支持 debug, info, warning(warn), error
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected debug, info, warning or error", s)
+}
+
+// 日志格式
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// Fields 日志的结构化字段
+type Fields map[string]interface{}
+
+// ConversationIDField 会话 id 的字段名
+const ConversationIDField = "conversation_id"
+
+// Options 日志配置
+type Options struct {
+	// 最低级别
+	Level Level
+	// 输出格式: console, json
+	Format string
+	// 日志文件, 为空时只输出到标准输出
+	File string
+	// 配置了日志文件时是否同时输出到标准输出
+	Stdout bool
+	// 单个日志文件的最大字节数, 超过后切割, 0 表示不按大小切割
+	MaxSize int64
+	// 按时间切割的周期, 如 24h, 0 表示不按时间切割
+	RotateInterval time.Duration
+	// 保留的历史日志文件数, 0 表示全部保留
+	MaxBackups int
+}
+
+// logger 并发安全的结构化日志, 所有输出共用一把锁
+type logger struct {
+	mu     sync.Mutex
+	level  Level
+	format string
+	out    io.Writer
+	closer io.Closer
+	// 输出前对消息和字符串字段脱敏
+	redactor func(string) string
+}
+
+var std = &logger{level: LevelInfo, format: FormatConsole, out: os.Stdout}
+
+// Configure 按配置重新设置日志的级别, 格式和输出, 可以重复调用
+func Configure(options Options) error {
+	if options.Format != FormatConsole && options.Format != FormatJSON && options.Format != "" {
+		return fmt.Errorf("unknown log format %q, expected console or json", options.Format)
+	}
+	var out io.Writer = os.Stdout
+	var closer io.Closer
+	if options.File != "" {
+		file, err := newRotatingFile(options.File, options.MaxSize, options.RotateInterval, options.MaxBackups)
+		if err != nil {
+			return err
+		}
+		out, closer = file, file
+		if options.Stdout {
+			out = io.MultiWriter(file, os.Stdout)
+		}
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if std.closer != nil {
+		_ = std.closer.Close()
+	}
+	std.level = options.Level
+	std.format = options.Format
+	if std.format == "" {
+		std.format = FormatConsole
+	}
+	std.out = out
+	std.closer = closer
+	return nil
+}
+
+// SetRedactor 设置脱敏函数, 每条日志的消息和字符串字段在输出前都会经过该函数
+func SetRedactor(redactor func(string) string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.redactor = redactor
+}
+
+// Entry 带有结构化字段的日志
+type Entry struct {
+	fields Fields
+}
+
+// WithFields 创建带字段的日志
+func WithFields(fields Fields) *Entry {
+	return (&Entry{}).WithFields(fields)
+}
+
+// WithConversation 创建带会话 id 的日志, 同一会话的日志可以按 conversation_id 过滤
+func WithConversation(id string) *Entry {
+	return WithFields(Fields{ConversationIDField: id})
+}
+
+// WithFields 在已有字段的基础上追加字段
+func (e *Entry) WithFields(fields Fields) *Entry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Entry{fields: merged}
+}
+
+// Debug 调试日志
+func (e *Entry) Debug(args ...interface{}) {
+	std.log(LevelDebug, e.fields, args)
+}
+
+// Info 详情
+func (e *Entry) Info(args ...interface{}) {
+	std.log(LevelInfo, e.fields, args)
+}
+
+// Warning 警告
+func (e *Entry) Warning(args ...interface{}) {
+	std.log(LevelWarning, e.fields, args)
+}
+
+// Error 错误
+func (e *Entry) Error(args ...interface{}) {
+	std.log(LevelError, e.fields, args)
 }
 
 // Info 详情
 func Info(args ...interface{}) {
-	Logger.SetPrefix("[INFO]")
-	Logger.Println(args...)
+	std.log(LevelInfo, nil, args)
 }
 
-// Danger 错误 为什么不命名为 error？避免和 error 类型重名
+// Danger 错误 为什么不命名为 error？避免和 error 类型重名. 只记录日志, 不再退出进程, 需要退出时使用 Fatal
 func Danger(args ...interface{}) {
-	Logger.SetPrefix("[ERROR]")
-	Logger.Fatal(args...)
+	std.log(LevelError, nil, args)
+}
+
+// Fatal 记录错误日志后退出进程
+func Fatal(args ...interface{}) {
+	std.log(LevelError, nil, args)
+	os.Exit(1)
 }
 
 // Warning 警告
 func Warning(args ...interface{}) {
-	Logger.SetPrefix("[WARNING]")
-	Logger.Println(args...)
+	std.log(LevelWarning, nil, args)
 }
 
 // DeBug debug
 func DeBug(args ...interface{}) {
-	Logger.SetPrefix("[DeBug]")
-	Logger.Println(args...)
+	std.log(LevelDebug, nil, args)
+}
+
+// callerDepth log 到调用方的栈深度
+const callerDepth = 2
+
+func (l *logger) log(level Level, fields Fields, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+
+	now := time.Now()
+	message := strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+	if l.redactor != nil {
+		message = l.redactor(message)
+		fields = l.redactFields(fields)
+	}
+	caller := ""
+	if _, file, line, ok := runtime.Caller(callerDepth); ok {
+		caller = fmt.Sprintf("%s:%d", filepath.Join(filepath.Base(filepath.Dir(file)), filepath.Base(file)), line)
+	}
+
+	var line []byte
+	if l.format == FormatJSON {
+		record := make(map[string]interface{}, len(fields)+4)
+		for k, v := range fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			record[k] = v
+		}
+		record["time"] = now.Format(time.RFC3339Nano)
+		record["level"] = level.String()
+		record["caller"] = caller
+		record["msg"] = message
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			encoded, _ = json.Marshal(map[string]string{"time": now.Format(time.RFC3339Nano), "level": level.String(), "caller": caller, "msg": message})
+		}
+		line = append(encoded, '\n')
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s [%s] %s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), caller, message)
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%v", k, fields[k])
+		}
+		b.WriteByte('\n')
+		line = []byte(b.String())
+	}
+	_, _ = l.out.Write(line)
+}
+
+// redactFields 对字符串和 error 类型的字段脱敏
+func (l *logger) redactFields(fields Fields) Fields {
+	if len(fields) == 0 {
+		return fields
+	}
+	redacted := make(Fields, len(fields))
+	for k, v := range fields {
+		switch value := v.(type) {
+		case string:
+			v = l.redactor(value)
+		case error:
+			v = l.redactor(value.Error())
+		}
+		redacted[k] = v
+	}
+	return redacted
 }
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerOutput(t *testing.T) {
+	errorsTotal := NewCounter("test_errors_total", "Errors by class.", "class")
+	errorsTotal.Inc("openai_timeout")
+	errorsTotal.Add(1, "openai_timeout")
+	errorsTotal.Inc(`quote"d`)
+	NewCounter("test_images_total", "Generated images.")
+	NewGaugeFunc("test_sessions", "Active sessions.", func() float64 { return 3 })
+	duration := NewHistogram("test_request_duration_seconds", "Request duration.\nIn seconds.", []float64{1, 0.1}, "model")
+	for _, v := range []float64{0.0625, 0.5, 2} {
+		duration.Observe(v, "a")
+	}
+
+	const want = `# HELP test_errors_total Errors by class.
+# TYPE test_errors_total counter
+test_errors_total{class="openai_timeout"} 2
+test_errors_total{class="quote\"d"} 1
+# HELP test_images_total Generated images.
+# TYPE test_images_total counter
+test_images_total 0
+# HELP test_request_duration_seconds Request duration.\nIn seconds.
+# TYPE test_request_duration_seconds histogram
+test_request_duration_seconds_bucket{model="a",le="0.1"} 1
+test_request_duration_seconds_bucket{model="a",le="1"} 2
+test_request_duration_seconds_bucket{model="a",le="+Inf"} 3
+test_request_duration_seconds_sum{model="a"} 2.5625
+test_request_duration_seconds_count{model="a"} 3
+# HELP test_sessions Active sessions.
+# TYPE test_sessions gauge
+test_sessions 3
+`
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got, _ := ioutil.ReadAll(rec.Body)
+	if string(got) != want {
+		t.Errorf("metrics output:\n%s\nwant:\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DingTalk 钉钉群自定义机器人, see https://open.dingtalk.com/document/robots/custom-robot-access
+type DingTalk struct {
+	name       string
+	webhookURL string
+	secret     string
+}
+
+var _ Notifier = (*DingTalk)(nil)
+
+// NewDingTalk webhookURL 为带 access_token 的完整地址, secret 为加签密钥, 未开启加签时为空
+func NewDingTalk(name, webhookURL, secret string) *DingTalk {
+	return &DingTalk{name: name, webhookURL: webhookURL, secret: secret}
+}
+
+func (d *DingTalk) Name() string {
+	return d.name
+}
+
+func (d *DingTalk) Notify(ctx context.Context, message string) error {
+	body := map[string]interface{}{
+		"msgtype": "text",
+		"text": map[string]string{
+			"content": message,
+		},
+	}
+	respModel := struct {
+		Errcode int    `json:"errcode"`
+		Errmsg  string `json:"errmsg"`
+	}{}
+	if err := postJSON(ctx, d.signedURL(time.Now()), nil, body, &respModel); err != nil {
+		return err
+	}
+	if respModel.Errcode != 0 {
+		return fmt.Errorf("dingtalk-notify-err, errcode:%v, errmsg:%v", respModel.Errcode, respModel.Errmsg)
+	}
+	return nil
+}
+
+// signedURL 加签: 以 secret 为密钥对 "timestamp\nsecret" 做 HmacSHA256, 再 base64 和 urlencode
+func (d *DingTalk) signedURL(now time.Time) string {
+	if d.secret == "" {
+		return d.webhookURL
+	}
+	timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write([]byte(timestamp + "\n" + d.secret))
+	sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	separator := "?"
+	if strings.Contains(d.webhookURL, "?") {
+		separator = "&"
+	}
+	return d.webhookURL + separator + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+}
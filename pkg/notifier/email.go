@@ -0,0 +1,177 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email 通过 smtp 发送告警邮件
+type Email struct {
+	name     string
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+	subject  string
+}
+
+var _ Notifier = (*Email)(nil)
+var _ ImageNotifier = (*Email)(nil)
+
+func NewEmail(name string, c Config) *Email {
+	e := &Email{
+		name:     name,
+		host:     c.SMTPHost,
+		port:     c.SMTPPort,
+		username: c.Username,
+		password: c.Password,
+		from:     c.From,
+		to:       c.To,
+		subject:  c.Subject,
+	}
+	if e.port == 0 {
+		e.port = 465
+	}
+	if e.from == "" {
+		e.from = e.username
+	}
+	if e.subject == "" {
+		e.subject = "wechatbot alert"
+	}
+	return e
+}
+
+func (e *Email) Name() string {
+	return e.name
+}
+
+func (e *Email) Notify(ctx context.Context, message string) error {
+	var body bytes.Buffer
+	e.writeHeaders(&body, "text/plain; charset=UTF-8")
+	body.WriteString("\r\n")
+	body.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+	return e.send(ctx, body.Bytes())
+}
+
+// NotifyImage 发送带图片附件的邮件
+func (e *Email) NotifyImage(ctx context.Context, caption string, filename string, image []byte) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	e.writeHeaders(&body, "multipart/mixed; boundary="+writer.Boundary())
+	body.WriteString("\r\n")
+
+	textPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=UTF-8"},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = textPart.Write([]byte(strings.ReplaceAll(caption, "\n", "\r\n"))); err != nil {
+		return err
+	}
+
+	imagePart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.TypeByExtension(filepath.Ext(filename))},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+	})
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(image)
+	for len(encoded) > 76 {
+		if _, err = imagePart.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	if _, err = imagePart.Write([]byte(encoded + "\r\n")); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return e.send(ctx, body.Bytes())
+}
+
+func (e *Email) writeHeaders(body *bytes.Buffer, contentType string) {
+	fmt.Fprintf(body, "From: %s\r\n", e.from)
+	fmt.Fprintf(body, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", e.subject))
+	fmt.Fprintf(body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(body, "Content-Type: %s\r\n", contentType)
+}
+
+// send 465 端口使用 TLS 直连, 其他端口在服务器支持时使用 STARTTLS
+func (e *Email) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if e.port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: e.host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if e.port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+				return err
+			}
+		}
+	}
+	if e.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Feishu 飞书/Lark 群自定义机器人, see https://open.feishu.cn/document/client-docs/bot-v3/add-custom-bot
+type Feishu struct {
+	name       string
+	webhookURL string
+	secret     string
+}
+
+var _ Notifier = (*Feishu)(nil)
+
+// NewFeishu secret 为签名校验的密钥, 未开启签名校验时为空
+func NewFeishu(name, webhookURL, secret string) *Feishu {
+	return &Feishu{name: name, webhookURL: webhookURL, secret: secret}
+}
+
+func (f *Feishu) Name() string {
+	return f.name
+}
+
+func (f *Feishu) Notify(ctx context.Context, message string) error {
+	body := map[string]interface{}{
+		"msg_type": "text",
+		"content": map[string]string{
+			"text": message,
+		},
+	}
+	if f.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		body["timestamp"] = timestamp
+		body["sign"] = feishuSign(timestamp, f.secret)
+	}
+
+	respModel := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
+	if err := postJSON(ctx, f.webhookURL, nil, body, &respModel); err != nil {
+		return err
+	}
+	if respModel.Code != 0 {
+		return fmt.Errorf("feishu-notify-err, code:%v, msg:%v", respModel.Code, respModel.Msg)
+	}
+	return nil
+}
+
+// feishuSign 签名: 以 "timestamp\nsecret" 为密钥对空字符串做 HmacSHA256, 再 base64
+func feishuSign(timestamp, secret string) string {
+	mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,183 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 告警渠道类型
+const (
+	TypeWeCom    = "wecom"
+	TypeDingTalk = "dingtalk"
+	TypeFeishu   = "feishu"
+	TypeTelegram = "telegram"
+	TypeEmail    = "email"
+	TypeWebhook  = "webhook"
+)
+
+// Notifier 告警渠道
+type Notifier interface {
+	// Name 渠道名称, 用于日志和错误信息
+	Name() string
+	// Notify 发送一条文本告警
+	Notify(ctx context.Context, message string) error
+}
+
+// ImageNotifier 支持发送图片的告警渠道, 如登录二维码
+type ImageNotifier interface {
+	// NotifyImage 发送图片, caption 为图片说明, filename 为附件文件名
+	NotifyImage(ctx context.Context, caption string, filename string, image []byte) error
+}
+
+// Config 一个告警渠道的配置, 不同类型使用不同的字段
+type Config struct {
+	// 渠道类型: wecom, dingtalk, feishu, telegram, email, webhook
+	Type string `json:"type"`
+	// 渠道名称, 默认与类型相同
+	Name string `json:"name"`
+	// wecom: 群机器人 webhook 的 key
+	Key string `json:"key"`
+	// wecom: 告警时要@的成员 userid 和手机号, "@all" 表示所有人
+	MentionedList       []string `json:"mentioned_list"`
+	MentionedMobileList []string `json:"mentioned_mobile_list"`
+	// dingtalk, feishu, webhook: webhook 地址; telegram: 可选的 api 地址, 默认 https://api.telegram.org
+	URL string `json:"url"`
+	// dingtalk, feishu: 加签密钥, 未开启加签时留空
+	Secret string `json:"secret"`
+	// telegram: bot token
+	BotToken string `json:"bot_token"`
+	// telegram: 接收告警的 chat id
+	ChatID string `json:"chat_id"`
+	// email: smtp 服务器地址和端口, 465 端口使用 TLS 直连, 其他端口使用 STARTTLS
+	SMTPHost string `json:"smtp_host"`
+	SMTPPort int    `json:"smtp_port"`
+	// email: smtp 登录账号和密码
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// email: 发件人, 默认为 username
+	From string `json:"from"`
+	// email: 收件人
+	To []string `json:"to"`
+	// email: 邮件标题
+	Subject string `json:"subject"`
+	// webhook: 额外的请求头, 如鉴权信息
+	Headers map[string]string `json:"headers"`
+}
+
+// Validate 校验渠道配置
+func (c Config) Validate() error {
+	var missing []string
+	require := func(field, value string) {
+		if strings.TrimSpace(value) == "" {
+			missing = append(missing, field)
+		}
+	}
+	switch c.Type {
+	case TypeWeCom:
+		require("key", c.Key)
+	case TypeDingTalk, TypeFeishu, TypeWebhook:
+		require("url", c.URL)
+	case TypeTelegram:
+		require("bot_token", c.BotToken)
+		require("chat_id", c.ChatID)
+	case TypeEmail:
+		require("smtp_host", c.SMTPHost)
+		if len(c.To) == 0 {
+			missing = append(missing, "to")
+		}
+		if c.From == "" {
+			require("from", c.Username)
+		}
+	default:
+		return fmt.Errorf("unknown notifier type %q, expected one of: wecom, dingtalk, feishu, telegram, email, webhook", c.Type)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s notifier requires %s", c.Type, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// New 按配置创建告警渠道, 多个渠道同时发送
+func New(configs []Config) Multi {
+	var notifiers []Notifier
+	for _, c := range configs {
+		name := c.Name
+		if name == "" {
+			name = c.Type
+		}
+		switch c.Type {
+		case TypeWeCom:
+			notifiers = append(notifiers, NewWeCom(name, c.Key, c.MentionedList, c.MentionedMobileList))
+		case TypeDingTalk:
+			notifiers = append(notifiers, NewDingTalk(name, c.URL, c.Secret))
+		case TypeFeishu:
+			notifiers = append(notifiers, NewFeishu(name, c.URL, c.Secret))
+		case TypeTelegram:
+			notifiers = append(notifiers, NewTelegram(name, c.URL, c.BotToken, c.ChatID))
+		case TypeEmail:
+			notifiers = append(notifiers, NewEmail(name, c))
+		case TypeWebhook:
+			notifiers = append(notifiers, NewWebhook(name, c.URL, c.Headers))
+		}
+	}
+	return Multi(notifiers)
+}
+
+// Multi 同时向多个渠道发送告警
+type Multi []Notifier
+
+var _ Notifier = Multi(nil)
+var _ ImageNotifier = Multi(nil)
+
+func (m Multi) Name() string {
+	names := make([]string, 0, len(m))
+	for _, n := range m {
+		names = append(names, n.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Notify 并发发送到所有渠道, 返回所有失败渠道的错误
+func (m Multi) Notify(ctx context.Context, message string) error {
+	return m.fanOut(func(n Notifier) error {
+		return n.Notify(ctx, message)
+	})
+}
+
+// NotifyImage 并发发送图片到所有渠道, 不支持图片的渠道只发送 caption
+func (m Multi) NotifyImage(ctx context.Context, caption string, filename string, image []byte) error {
+	return m.fanOut(func(n Notifier) error {
+		if imageNotifier, ok := n.(ImageNotifier); ok {
+			return imageNotifier.NotifyImage(ctx, caption, filename, image)
+		}
+		return n.Notify(ctx, caption)
+	})
+}
+
+func (m Multi) fanOut(send func(n Notifier) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	for _, n := range m {
+		wg.Add(1)
+		go func(n Notifier) {
+			defer wg.Done()
+			if err := send(n); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", n.Name(), err))
+				mu.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.New("notify failed, " + strings.Join(errs, "; "))
+	}
+	return nil
+}
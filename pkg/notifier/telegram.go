@@ -0,0 +1,76 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Telegram 通过 Telegram Bot API 发送告警, see https://core.telegram.org/bots/api#sendmessage
+type Telegram struct {
+	name     string
+	apiURL   string
+	botToken string
+	chatID   string
+}
+
+var _ Notifier = (*Telegram)(nil)
+var _ ImageNotifier = (*Telegram)(nil)
+
+// NewTelegram apiURL 为空时使用 https://api.telegram.org
+func NewTelegram(name, apiURL, botToken, chatID string) *Telegram {
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	return &Telegram{
+		name:     name,
+		apiURL:   strings.TrimRight(apiURL, "/"),
+		botToken: botToken,
+		chatID:   chatID,
+	}
+}
+
+func (t *Telegram) Name() string {
+	return t.name
+}
+
+func (t *Telegram) Notify(ctx context.Context, message string) error {
+	body := map[string]interface{}{
+		"chat_id": t.chatID,
+		"text":    message,
+	}
+	respModel := struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}{}
+	if err := postJSON(ctx, t.methodURL("sendMessage"), nil, body, &respModel); err != nil {
+		return err
+	}
+	if !respModel.Ok {
+		return fmt.Errorf("telegram-notify-err, description:%v", respModel.Description)
+	}
+	return nil
+}
+
+// NotifyImage 通过 sendPhoto 发送图片, caption 作为图片说明
+func (t *Telegram) NotifyImage(ctx context.Context, caption string, filename string, image []byte) error {
+	fields := map[string]string{
+		"chat_id": t.chatID,
+		"caption": caption,
+	}
+	respModel := struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}{}
+	if err := postMultipart(ctx, t.methodURL("sendPhoto"), fields, "photo", filename, image, &respModel); err != nil {
+		return err
+	}
+	if !respModel.Ok {
+		return fmt.Errorf("telegram-notify-err, description:%v", respModel.Description)
+	}
+	return nil
+}
+
+func (t *Telegram) methodURL(method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", t.apiURL, t.botToken, method)
+}
@@ -0,0 +1,46 @@
+package notifier
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+)
+
+// Webhook 通用 JSON webhook, 请求体为 {"source": "wechatbot", "text": "...", "timestamp": 1670000000},
+// 发送图片时额外带上 "image_name" 和 base64 编码的 "image_base64"
+type Webhook struct {
+	name    string
+	url     string
+	headers map[string]string
+}
+
+var _ Notifier = (*Webhook)(nil)
+var _ ImageNotifier = (*Webhook)(nil)
+
+func NewWebhook(name, url string, headers map[string]string) *Webhook {
+	return &Webhook{name: name, url: url, headers: headers}
+}
+
+func (w *Webhook) Name() string {
+	return w.name
+}
+
+func (w *Webhook) Notify(ctx context.Context, message string) error {
+	body := map[string]interface{}{
+		"source":    "wechatbot",
+		"text":      message,
+		"timestamp": time.Now().Unix(),
+	}
+	return postJSON(ctx, w.url, w.headers, body, nil)
+}
+
+func (w *Webhook) NotifyImage(ctx context.Context, caption string, filename string, image []byte) error {
+	body := map[string]interface{}{
+		"source":       "wechatbot",
+		"text":         caption,
+		"timestamp":    time.Now().Unix(),
+		"image_name":   filename,
+		"image_base64": base64.StdEncoding.EncodeToString(image),
+	}
+	return postJSON(ctx, w.url, w.headers, body, nil)
+}
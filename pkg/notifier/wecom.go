@@ -0,0 +1,44 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/wechat_notify_http_client"
+)
+
+// WeCom 企业微信群机器人
+type WeCom struct {
+	name                string
+	client              *wechat_notify_http_client.WechatNotifyHttpClient
+	mentionedList       []string
+	mentionedMobileList []string
+}
+
+var _ Notifier = (*WeCom)(nil)
+var _ ImageNotifier = (*WeCom)(nil)
+
+// NewWeCom mentionedList 和 mentionedMobileList 为告警时要@的成员
+func NewWeCom(name, key string, mentionedList []string, mentionedMobileList []string) *WeCom {
+	return &WeCom{
+		name:                name,
+		client:              wechat_notify_http_client.NewWechatNotifyHttpClient(key),
+		mentionedList:       mentionedList,
+		mentionedMobileList: mentionedMobileList,
+	}
+}
+
+func (w *WeCom) Name() string {
+	return w.name
+}
+
+func (w *WeCom) Notify(ctx context.Context, message string) error {
+	return w.client.SendNotifyAsText(ctx, message, w.mentionedList, w.mentionedMobileList)
+}
+
+// NotifyImage 先发送带@的文本说明, 再发送图片
+func (w *WeCom) NotifyImage(ctx context.Context, caption string, filename string, image []byte) error {
+	if err := w.Notify(ctx, caption); err != nil {
+		return err
+	}
+	return w.client.SendNotifyAsImage(ctx, image)
+}
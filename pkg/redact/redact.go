@@ -0,0 +1,141 @@
+// Package redact 日志脱敏, 按隐私级别遮盖密钥, 手机号, 身份证号, 以及对用户身份做哈希, 丢弃消息正文
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 隐私级别, 级别越高脱敏越多
+const (
+	// LevelBasic 只遮盖密钥
+	LevelBasic = "basic"
+	// LevelStandard 遮盖密钥, 手机号和身份证号
+	LevelStandard = "standard"
+	// LevelStrict 在 standard 的基础上, 对用户昵称和 id 做哈希, 并丢弃消息正文
+	LevelStrict = "strict"
+)
+
+// Levels 支持的隐私级别
+var Levels = []string{LevelBasic, LevelStandard, LevelStrict}
+
+var (
+	// openai 的 api key
+	apiKeyPattern = regexp.MustCompile(`sk-[A-Za-z0-9_\-]{16,}`)
+	// url 和表单中的敏感参数, 如企业微信 webhook 的 key, 钉钉的 access_token
+	queryPattern = regexp.MustCompile(`(?i)\b(key|access_token|token|secret|sign|password|bot_token)=([^&\s"']+)`)
+	// json 中的敏感字段, 如企业微信 gettoken 接口返回的 access_token
+	jsonFieldPattern = regexp.MustCompile(`(?i)"(access_token|token|secret|password|bot_token)"(\s*:\s*)"([^"]+)"`)
+	// Authorization 请求头
+	authorizationPattern = regexp.MustCompile(`(?i)(authorization:\s*(?:bearer|basic)\s+)(\S+)`)
+	// telegram bot token, 如 123456:ABC-DEF
+	telegramTokenPattern = regexp.MustCompile(`\b\d{6,}:[A-Za-z0-9_\-]{30,}\b`)
+	// 中国大陆手机号
+	phonePattern = regexp.MustCompile(`(^|\D)(\+?86[- ]?)?(1[3-9]\d)(\d{4})(\d{4})(\D|$)`)
+	// 18 位身份证号
+	idNumberPattern = regexp.MustCompile(`(^|\D)(\d{6})(\d{8})(\d{3}[\dXx])(\D|$)`)
+)
+
+var (
+	lock    sync.RWMutex
+	level   = LevelStandard
+	secrets []string
+)
+
+// Configure 设置隐私级别和需要遮盖的已知密钥, 如配置中的 api key 和 webhook key
+func Configure(privacyLevel string, knownSecrets []string) error {
+	if !IsLevel(privacyLevel) {
+		return fmt.Errorf("unknown privacy level %q, expected basic, standard or strict", privacyLevel)
+	}
+	var cleaned []string
+	for _, secret := range knownSecrets {
+		// 过短的值遮盖时误伤太多
+		if len(strings.TrimSpace(secret)) >= 6 {
+			cleaned = append(cleaned, strings.TrimSpace(secret))
+		}
+	}
+	// 先替换长的, 避免短密钥是长密钥的一部分时替换不完整
+	sort.Slice(cleaned, func(i, j int) bool { return len(cleaned[i]) > len(cleaned[j]) })
+
+	lock.Lock()
+	defer lock.Unlock()
+	level = privacyLevel
+	secrets = cleaned
+	return nil
+}
+
+// IsLevel 是否为支持的隐私级别
+func IsLevel(privacyLevel string) bool {
+	for _, l := range Levels {
+		if privacyLevel == l {
+			return true
+		}
+	}
+	return false
+}
+
+// Level 当前的隐私级别
+func Level() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return level
+}
+
+// Text 遮盖文本中的密钥, standard 及以上级别同时遮盖手机号和身份证号
+func Text(s string) string {
+	lock.RLock()
+	currentLevel, knownSecrets := level, secrets
+	lock.RUnlock()
+
+	for _, secret := range knownSecrets {
+		s = strings.ReplaceAll(s, secret, mask(secret))
+	}
+	s = apiKeyPattern.ReplaceAllStringFunc(s, mask)
+	s = telegramTokenPattern.ReplaceAllStringFunc(s, mask)
+	s = queryPattern.ReplaceAllStringFunc(s, func(match string) string {
+		parts := queryPattern.FindStringSubmatch(match)
+		return parts[1] + "=" + mask(parts[2])
+	})
+	s = jsonFieldPattern.ReplaceAllStringFunc(s, func(match string) string {
+		parts := jsonFieldPattern.FindStringSubmatch(match)
+		return `"` + parts[1] + `"` + parts[2] + `"` + mask(parts[3]) + `"`
+	})
+	s = authorizationPattern.ReplaceAllString(s, "${1}******")
+	if currentLevel == LevelBasic {
+		return s
+	}
+	s = phonePattern.ReplaceAllString(s, "${1}${2}${3}****${5}${6}")
+	s = idNumberPattern.ReplaceAllString(s, "${1}${2}********${4}${5}")
+	return s
+}
+
+// Identity 用户昵称或 id, strict 级别时返回哈希值, 同一身份的哈希值不变, 便于关联日志
+func Identity(s string) string {
+	if s == "" || Level() != LevelStrict {
+		return s
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "u_" + hex.EncodeToString(sum[:])[:12]
+}
+
+// Body 消息正文, strict 级别时只保留长度, 其他级别按 Text 脱敏
+func Body(s string) string {
+	if Level() == LevelStrict {
+		return fmt.Sprintf("[%d chars redacted]", len([]rune(s)))
+	}
+	return Text(s)
+}
+
+// mask 保留前 3 位和后 4 位, 与 --print-config 中密钥的打码方式一致
+func mask(secret string) string {
+	runes := []rune(secret)
+	if len(runes) <= 8 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:3]) + strings.Repeat("*", len(runes)-7) + string(runes[len(runes)-4:])
+}
@@ -0,0 +1,99 @@
+package redact
+
+import "testing"
+
+// withLevel 在测试期间切换隐私级别和已知密钥, 结束后恢复为默认的 standard
+func withLevel(t *testing.T, privacyLevel string, knownSecrets []string) {
+	t.Helper()
+	if err := Configure(privacyLevel, knownSecrets); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = Configure(LevelStandard, nil) })
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name  string
+		level string
+		in    string
+		want  string
+	}{
+		{name: "api key", level: LevelBasic, in: "api key sk-abcdefghijklmnop1234 used", want: "api key sk-****************1234 used"},
+		{name: "short sk prefix", level: LevelBasic, in: "ask-me sk-short", want: "ask-me sk-short"},
+		{name: "webhook key", level: LevelBasic, in: "POST https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=ffc3b820-e941-4166", want: "POST https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=ffc***********4166"},
+		{name: "access_token query", level: LevelBasic, in: "/robot/send?access_token=abcdef123456&timestamp=1", want: "/robot/send?access_token=abc*****3456&timestamp=1"},
+		{name: "short query value", level: LevelBasic, in: "?token=abc", want: "?token=***"},
+		{name: "other query param", level: LevelBasic, in: "?monkey_id=12345678901&keyword=hello", want: "?monkey_id=12345678901&keyword=hello"},
+		{name: "json field", level: LevelBasic, in: `{"access_token": "abcdefghijkl", "expires_in": 7200}`, want: `{"access_token": "abc*****ijkl", "expires_in": 7200}`},
+		{name: "other json field", level: LevelBasic, in: `{"content":"my token is here","msgtype":"text"}`, want: `{"content":"my token is here","msgtype":"text"}`},
+		{name: "authorization header", level: LevelBasic, in: "Authorization: Bearer abc.def.ghi", want: "Authorization: Bearer ******"},
+		{name: "authorization word", level: LevelBasic, in: "authorization failed", want: "authorization failed"},
+		{name: "telegram token", level: LevelBasic, in: "bot 123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw", want: "bot 123*************************************Dsaw"},
+		{name: "phone kept at basic", level: LevelBasic, in: "call 13812345678", want: "call 13812345678"},
+		{name: "phone", level: LevelStandard, in: "call 13812345678 now", want: "call 138****5678 now"},
+		{name: "phone with country code", level: LevelStandard, in: "+86 13812345678", want: "+86 138****5678"},
+		{name: "phone in longer number", level: LevelStandard, in: "order 2138123456789", want: "order 2138123456789"},
+		{name: "not a mobile prefix", level: LevelStandard, in: "12812345678", want: "12812345678"},
+		{name: "id number", level: LevelStandard, in: "id 11010519491231002X.", want: "id 110105********002X."},
+		{name: "id number kept at basic", level: LevelBasic, in: "id 11010519491231002X", want: "id 11010519491231002X"},
+		{name: "19 digits", level: LevelStandard, in: "1101051949123100234", want: "1101051949123100234"},
+		{name: "timestamp", level: LevelStrict, in: "at 1700000000000", want: "at 1700000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withLevel(t, tt.level, nil)
+			if got := Text(tt.in); got != tt.want {
+				t.Errorf("Text(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextKnownSecrets(t *testing.T) {
+	withLevel(t, LevelBasic, []string{"abc", "app-secret-value", "secret-value"})
+	if got, want := Text("secret app-secret-value and secret-value, abc"), "secret app*********alue and sec*****alue, abc"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	withLevel(t, LevelStandard, nil)
+	if got := Identity("telegram:42"); got != "telegram:42" {
+		t.Errorf("standard Identity() = %q, want unchanged", got)
+	}
+
+	withLevel(t, LevelStrict, nil)
+	hashed := Identity("telegram:42")
+	if len(hashed) != len("u_")+12 || hashed[:2] != "u_" {
+		t.Errorf("strict Identity() = %q, want u_ and 12 hex chars", hashed)
+	}
+	if again := Identity("telegram:42"); again != hashed {
+		t.Errorf("strict Identity() not stable: %q != %q", again, hashed)
+	}
+	if other := Identity("telegram:43"); other == hashed {
+		t.Errorf("strict Identity() of different ids are both %q", other)
+	}
+	if got := Identity(""); got != "" {
+		t.Errorf("strict Identity(\"\") = %q, want empty", got)
+	}
+}
+
+func TestBody(t *testing.T) {
+	withLevel(t, LevelStandard, nil)
+	if got, want := Body("我的手机 13812345678"), "我的手机 138****5678"; got != want {
+		t.Errorf("standard Body() = %q, want %q", got, want)
+	}
+	withLevel(t, LevelStrict, nil)
+	if got, want := Body("我的手机 13812345678"), "[16 chars redacted]"; got != want {
+		t.Errorf("strict Body() = %q, want %q", got, want)
+	}
+}
+
+func TestConfigureUnknownLevel(t *testing.T) {
+	if err := Configure("paranoid", nil); err == nil {
+		t.Error("Configure(\"paranoid\") error = nil, want error")
+	}
+	if Level() != LevelStandard {
+		t.Errorf("Level() = %q after failed Configure, want standard", Level())
+	}
+}
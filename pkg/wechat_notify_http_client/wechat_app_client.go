@@ -0,0 +1,218 @@
+package wechat_notify_http_client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+)
+
+// accessTokenRefreshBefore access_token 在过期前多久刷新
+const accessTokenRefreshBefore = 5 * time.Minute
+
+// maxTextBytes 应用文本消息内容的最大字节数, 超过时分多条发送
+const maxTextBytes = 2048
+
+// access_token 失效的错误码, 收到时刷新 access_token 后重试一次
+var accessTokenErrcodes = map[int]bool{40014: true, 42001: true, 40001: true}
+
+// WechatAppHttpClient 企业微信自建应用的接口, 负责 access_token 的缓存和刷新, see https://developer.work.weixin.qq.com/document/path/90664
+type WechatAppHttpClient struct {
+	WechatNotifyHttpClient
+	corpID  string
+	secret  string
+	agentID int64
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+func NewWechatAppHttpClient(corpID string, agentID int64, secret string) *WechatAppHttpClient {
+	return &WechatAppHttpClient{
+		WechatNotifyHttpClient: *NewWechatNotifyHttpClient(""),
+		corpID:                 corpID,
+		secret:                 secret,
+		agentID:                agentID,
+	}
+}
+
+// appResponse 应用接口的公共响应
+type appResponse struct {
+	Errcode int    `json:"errcode"`
+	Errmsg  string `json:"errmsg"`
+}
+
+func (r appResponse) err() error {
+	if r.Errcode != 0 {
+		return fmt.Errorf("wechat-app-err, errcode:%d, err-msg:%v", r.Errcode, r.Errmsg)
+	}
+	return nil
+}
+
+// AccessToken 获取 access_token, 缓存到过期前 5 分钟
+func (c *WechatAppHttpClient) AccessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken != "" && time.Now().Before(c.expiresAt) {
+		return c.accessToken, nil
+	}
+
+	resp, err := c.do(ctx, GET, "/cgi-bin/gettoken", map[string]interface{}{"corpid": c.corpID, "corpsecret": c.secret})
+	if err != nil {
+		return "", err
+	}
+	respModel := struct {
+		appResponse
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err = c.parseResponse(resp, &respModel); err != nil {
+		return "", err
+	}
+	if err = respModel.err(); err != nil {
+		return "", err
+	}
+	c.accessToken = respModel.AccessToken
+	c.expiresAt = time.Now().Add(time.Duration(respModel.ExpiresIn)*time.Second - accessTokenRefreshBefore)
+	return c.accessToken, nil
+}
+
+// invalidate access_token 失效时清空缓存, 下次调用重新获取
+func (c *WechatAppHttpClient) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accessToken = ""
+}
+
+// withAccessToken 带 access_token 调用接口, access_token 失效时刷新后重试一次
+func (c *WechatAppHttpClient) withAccessToken(ctx context.Context, call func(accessToken string) (appResponse, error)) error {
+	for attempt := 0; ; attempt++ {
+		accessToken, err := c.AccessToken(ctx)
+		if err != nil {
+			return err
+		}
+		respModel, err := call(accessToken)
+		if err != nil {
+			return err
+		}
+		if accessTokenErrcodes[respModel.Errcode] && attempt == 0 {
+			logger.Info(fmt.Sprintf("WechatAppHttpClient - access_token 失效, 刷新后重试, errcode: %d", respModel.Errcode))
+			c.invalidate()
+			continue
+		}
+		return respModel.err()
+	}
+}
+
+// SendText 发送文本消息给成员, 超过 2048 字节时按行拆分为多条
+func (c *WechatAppHttpClient) SendText(ctx context.Context, toUser, content string) error {
+	for _, chunk := range splitText(content, maxTextBytes) {
+		err := c.sendMessage(ctx, map[string]interface{}{
+			"touser":  toUser,
+			"msgtype": "text",
+			"agentid": c.agentID,
+			"text":    map[string]string{"content": chunk},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendImage 上传图片并发送给成员
+func (c *WechatAppHttpClient) SendImage(ctx context.Context, toUser string, image []byte) error {
+	mediaID, err := c.UploadImage(ctx, image)
+	if err != nil {
+		return err
+	}
+	return c.sendMessage(ctx, map[string]interface{}{
+		"touser":  toUser,
+		"msgtype": "image",
+		"agentid": c.agentID,
+		"image":   map[string]string{"media_id": mediaID},
+	})
+}
+
+// sendMessage 调用应用消息接口, see https://developer.work.weixin.qq.com/document/path/90236
+func (c *WechatAppHttpClient) sendMessage(ctx context.Context, data map[string]interface{}) error {
+	return c.withAccessToken(ctx, func(accessToken string) (appResponse, error) {
+		var respModel appResponse
+		resp, err := c.do(ctx, POST, "/cgi-bin/message/send?access_token="+accessToken, data)
+		if err != nil {
+			return respModel, err
+		}
+		err = c.parseResponse(resp, &respModel)
+		return respModel, err
+	})
+}
+
+// UploadImage 上传临时图片素材, 返回 3 天内有效的 media_id
+func (c *WechatAppHttpClient) UploadImage(ctx context.Context, image []byte) (string, error) {
+	var mediaID string
+	err := c.withAccessToken(ctx, func(accessToken string) (appResponse, error) {
+		respModel := struct {
+			appResponse
+			MediaID string `json:"media_id"`
+		}{}
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("media", "image.png")
+		if err != nil {
+			return respModel.appResponse, err
+		}
+		if _, err = part.Write(image); err != nil {
+			return respModel.appResponse, err
+		}
+		if err = writer.Close(); err != nil {
+			return respModel.appResponse, err
+		}
+		req, err := http.NewRequest(POST, c.endpoint+"/cgi-bin/media/upload?type=image&access_token="+accessToken, &body)
+		if err != nil {
+			return respModel.appResponse, err
+		}
+		if ctx != nil {
+			req = req.WithContext(ctx)
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		resp, err := c.client.Do(req)
+		logger.Info(fmt.Sprintf("WechatAppHttpClient - 调用企业微信上传图片接口结束, size: %d, err: %v", len(image), err))
+		if err != nil {
+			return respModel.appResponse, err
+		}
+		err = c.parseResponse(resp, &respModel)
+		mediaID = respModel.MediaID
+		return respModel.appResponse, err
+	})
+	return mediaID, err
+}
+
+// splitText 把文本拆分为不超过 maxBytes 字节的多段, 尽量在换行处拆分, 不拆开多字节字符
+func splitText(text string, maxBytes int) []string {
+	var chunks []string
+	for len(text) > maxBytes {
+		cut := maxBytes
+		// 回退到字符边界
+		for cut > 0 && !isRuneStart(text[cut]) {
+			cut--
+		}
+		if newline := bytes.LastIndexByte([]byte(text[:cut]), '\n'); newline > 0 {
+			cut = newline + 1
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	return append(chunks, text)
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
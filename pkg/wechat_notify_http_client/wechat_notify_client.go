@@ -1,13 +1,19 @@
 package wechat_notify_http_client
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
 	"github.com/coolseven/wechatbot-chatgpt/pkg/util"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httputil"
 	"strings"
@@ -43,7 +49,7 @@ func (c WechatNotifyHttpClient) do(ctx context.Context, method, path string, par
 		return nil, err
 	}
 	if ctx != nil {
-		req.WithContext(ctx)
+		req = req.WithContext(ctx)
 	}
 
 	query := req.URL.Query()
@@ -63,7 +69,8 @@ func (c WechatNotifyHttpClient) do(ctx context.Context, method, path string, par
 
 	resp, err := c.client.Do(req)
 
-	reqStr, _ := httputil.DumpRequest(req, true)
+	// 请求地址中带有 webhook 的 key, 只记录脱敏后的地址和请求体
+	reqStr := req.Method + " " + redact.Text(req.URL.String())
 	respStr := []byte("<nil>")
 	if err != nil {
 		respStr = []byte(err.Error()) // 初始值
@@ -72,7 +79,7 @@ func (c WechatNotifyHttpClient) do(ctx context.Context, method, path string, par
 		respStr, _ = httputil.DumpResponse(resp, true)
 	}
 
-	logger.Info(fmt.Sprintf("WechatNotifyHttpClient - 调用企业微信通知接口结束 \r\nrequest:\n%s\r\n%s\r\nresponse:\r\n%s", reqStr, jsonBodyString, respStr))
+	logger.Info(fmt.Sprintf("WechatNotifyHttpClient - 调用企业微信通知接口结束 \r\nrequest:\n%s\r\n%s\r\nresponse:\r\n%s", reqStr, requestSummary(params, jsonBodyString), respStr))
 
 	if err != nil {
 		return nil, err
@@ -81,6 +88,14 @@ func (c WechatNotifyHttpClient) do(ctx context.Context, method, path string, par
 	return resp, nil
 }
 
+// requestSummary 日志中记录的请求体. 图片消息的 base64 有几百 KB, 只记录消息类型和大小, 其他消息脱敏后记录
+func requestSummary(params map[string]interface{}, body []byte) string {
+	if msgType, _ := params["msgtype"].(string); msgType == "image" {
+		return fmt.Sprintf("msgtype: %s, %d bytes", msgType, len(body))
+	}
+	return redact.Text(string(body))
+}
+
 func (c WechatNotifyHttpClient) parseResponse(resp *http.Response, expectedResponseStruct interface{}) error {
 	defer resp.Body.Close()
 
@@ -105,14 +120,136 @@ func (c WechatNotifyHttpClient) parseResponse(resp *http.Response, expectedRespo
 
 // SendNotifyAsPlainText 发送普通消息
 func (c WechatNotifyHttpClient) SendNotifyAsPlainText(ctx context.Context, message string) error {
-	data := map[string]interface{}{
+	return c.SendNotifyAsText(ctx, message, nil, nil)
+}
+
+// SendNotifyAsText 发送文本消息, mentionedList 为要@的成员 userid, mentionedMobileList 为要@的成员手机号, "@all" 表示所有人
+func (c WechatNotifyHttpClient) SendNotifyAsText(ctx context.Context, message string, mentionedList []string, mentionedMobileList []string) error {
+	text := map[string]interface{}{
+		"content": message,
+	}
+	if len(mentionedList) > 0 {
+		text["mentioned_list"] = mentionedList
+	}
+	if len(mentionedMobileList) > 0 {
+		text["mentioned_mobile_list"] = mentionedMobileList
+	}
+	return c.send(ctx, map[string]interface{}{
 		"msgtype": "text",
-		"text": map[string]string{
-			"content": message,
+		"text":    text,
+	})
+}
+
+// SendNotifyAsMarkdown 发送 markdown 消息, 支持企业微信的 markdown 子集, 如 <font color="warning">
+func (c WechatNotifyHttpClient) SendNotifyAsMarkdown(ctx context.Context, content string) error {
+	return c.send(ctx, map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"content": content,
+		},
+	})
+}
+
+// SendNotifyAsImage 发送图片消息, 图片为 jpg 或 png, 编码前最大 2M
+func (c WechatNotifyHttpClient) SendNotifyAsImage(ctx context.Context, image []byte) error {
+	sum := md5.Sum(image)
+	return c.send(ctx, map[string]interface{}{
+		"msgtype": "image",
+		"image": map[string]string{
+			"base64": base64.StdEncoding.EncodeToString(image),
+			"md5":    hex.EncodeToString(sum[:]),
+		},
+	})
+}
+
+// NewsArticle 图文消息中的一篇文章
+type NewsArticle struct {
+	// 标题
+	Title string `json:"title"`
+	// 描述
+	Description string `json:"description,omitempty"`
+	// 点击后跳转的链接
+	URL string `json:"url"`
+	// 图片链接
+	PicURL string `json:"picurl,omitempty"`
+}
+
+// SendNotifyAsNews 发送图文消息, 支持 1 到 8 篇文章
+func (c WechatNotifyHttpClient) SendNotifyAsNews(ctx context.Context, articles []NewsArticle) error {
+	return c.send(ctx, map[string]interface{}{
+		"msgtype": "news",
+		"news": map[string]interface{}{
+			"articles": articles,
 		},
+	})
+}
+
+// SendNotifyAsFile 发送文件消息, mediaID 由 UploadMedia 获取
+func (c WechatNotifyHttpClient) SendNotifyAsFile(ctx context.Context, mediaID string) error {
+	return c.send(ctx, map[string]interface{}{
+		"msgtype": "file",
+		"file": map[string]string{
+			"media_id": mediaID,
+		},
+	})
+}
+
+// SendFile 上传文件并发送文件消息
+func (c WechatNotifyHttpClient) SendFile(ctx context.Context, filename string, content []byte) error {
+	mediaID, err := c.UploadMedia(ctx, filename, content)
+	if err != nil {
+		return err
+	}
+	return c.SendNotifyAsFile(ctx, mediaID)
+}
+
+// UploadMedia 通过群机器人的 upload_media 接口上传文件, 返回 3 天内有效的 media_id, 文件大小在 5B 到 20M 之间
+func (c WechatNotifyHttpClient) UploadMedia(ctx context.Context, filename string, content []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("media", filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(content); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	targetUrl := fmt.Sprintf("%s/cgi-bin/webhook/upload_media?key=%s&type=file", c.endpoint, c.wechatWorkSendKey)
+	req, err := http.NewRequest(POST, targetUrl, &body)
+	if err != nil {
+		return "", err
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
 	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.client.Do(req)
+	logger.Info(fmt.Sprintf("WechatNotifyHttpClient - 调用企业微信上传文件接口结束, filename: %s, size: %d, err: %v", filename, len(content), err))
+	if err != nil {
+		return "", err
+	}
+
+	respModel := struct {
+		Errcode int    `json:"errcode"`
+		Errmsg  string `json:"errmsg"`
+		MediaID string `json:"media_id"`
+	}{}
+	if err = c.parseResponse(resp, &respModel); err != nil {
+		return "", err
+	}
+	if respModel.Errcode != 0 {
+		return "", fmt.Errorf("wechat-notify-err, err-msg:%v", respModel.Errmsg)
+	}
+	return respModel.MediaID, nil
+}
 
-	//resp, err := c.do(ctx, POST, "/cgi-bin/webhook/send?key=ffc3b820-e941-4166-a581-722446196a90", data)
+// send 调用群机器人的 webhook 发送消息, see https://developer.work.weixin.qq.com/document/path/91770
+func (c WechatNotifyHttpClient) send(ctx context.Context, data map[string]interface{}) error {
 	path := fmt.Sprintf("/cgi-bin/webhook/send?key=%s", c.wechatWorkSendKey)
 	resp, err := c.do(ctx, POST, path, data)
 	if err != nil {
@@ -0,0 +1,283 @@
+// Package replay 回放审计记录中的 prompt, 使用其他模型或人设重新请求, 并与记录中的回复对比
+package replay
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/audit"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/gpt"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/usage"
+)
+
+// requester 回放请求的用量记在该用户名下
+var requester = usage.Requester{User: "replay"}
+
+// options replay 子命令的参数
+type options struct {
+	file         string
+	model        string
+	persona      string
+	conversation string
+	since        string
+	limit        int
+	json         bool
+	verbose      bool
+}
+
+// Result 一条记录的回放结果, --json 时每行输出一条
+type Result struct {
+	Time           time.Time `json:"time"`
+	ConversationID string    `json:"conversation_id"`
+	Prompt         string    `json:"prompt"`
+	RecordedModel  string    `json:"recorded_model"`
+	ReplayModel    string    `json:"replay_model"`
+	ReplayPersona  string    `json:"replay_persona,omitempty"`
+	Recorded       string    `json:"recorded"`
+	Replay         string    `json:"replay"`
+	Changed        bool      `json:"changed"`
+	LatencyMs      int64     `json:"latency_ms"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Run 执行 replay 子命令, 返回进程退出码.
+// args 中 -- 之前为 replay 的参数, 之后为机器人本身的配置参数, 如 --config
+func Run(args []string) int {
+	replayArgs, configArgs := config.SplitSubcommandArgs(args)
+	opts, err := parseOptions(replayArgs)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	config.SetArgs(configArgs)
+	cfg := config.LoadConfig()
+	if err := cfg.ConfigureLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !opts.verbose {
+		// 回放结果输出到标准输出, 只保留警告以上的日志, 避免混在一起
+		logOptions := cfg.LogOptions()
+		if logOptions.Level < logger.LevelWarning {
+			logOptions.Level = logger.LevelWarning
+		}
+		if err := logger.Configure(logOptions); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if opts.persona != "" {
+		if _, ok := cfg.Personas[opts.persona]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown persona %q, available: %s\n", opts.persona, strings.Join(cfg.PersonaNames(), ", "))
+			return 2
+		}
+	}
+	if opts.file == "" {
+		opts.file = cfg.AuditFile
+	}
+	if opts.file == "" {
+		fmt.Fprintln(os.Stderr, "no audit file, set audit_file in config or pass --file")
+		return 2
+	}
+
+	records, err := audit.Load(opts.file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load audit file error: %v\n", err)
+		return 1
+	}
+	records, err = filter(records, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(os.Stderr, "no recorded prompts to replay")
+		return 0
+	}
+
+	var identical, changed, failed int
+	for i, record := range records {
+		result := replay(cfg, record, opts)
+		switch {
+		case result.Error != "":
+			failed++
+		case result.Changed:
+			changed++
+		default:
+			identical++
+		}
+		if opts.json {
+			line, _ := json.Marshal(result)
+			fmt.Println(string(line))
+			continue
+		}
+		printResult(os.Stdout, i+1, len(records), result)
+	}
+	fmt.Fprintf(os.Stderr, "replayed %d records: %d identical, %d changed, %d errors\n", len(records), identical, changed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func parseOptions(args []string) (options, error) {
+	var opts options
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.StringVar(&opts.file, "file", "", "audit file to replay, defaults to audit_file in config")
+	fs.StringVar(&opts.model, "model", "", "model to replay with, defaults to the recorded model or the persona's model")
+	fs.StringVar(&opts.persona, "persona", "", "persona to replay with, replaces the recorded system prompt")
+	fs.StringVar(&opts.conversation, "conversation", "", "only replay records of this conversation id")
+	fs.StringVar(&opts.since, "since", "", "only replay records after this time, a duration like 24h or a date like 2006-01-02")
+	fs.IntVar(&opts.limit, "limit", 20, "replay at most this many of the latest records, 0 for all")
+	fs.BoolVar(&opts.json, "json", false, "print one JSON result per line instead of a diff")
+	fs.BoolVar(&opts.verbose, "verbose", false, "keep the configured log level instead of warnings only")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: wechatbot replay [flags] [-- config flags]")
+		fmt.Fprintln(fs.Output(), "Re-run recorded prompts from the audit file and diff the answers.")
+		fs.PrintDefaults()
+	}
+	return opts, fs.Parse(args)
+}
+
+// filter 只保留有 prompt 的文本补全记录, 并按参数过滤, 保留最新的 limit 条
+func filter(records []audit.Record, opts options) ([]audit.Record, error) {
+	var since time.Time
+	if opts.since != "" {
+		if d, err := time.ParseDuration(opts.since); err == nil {
+			since = time.Now().Add(-d)
+		} else if t, err := time.ParseInLocation("2006-01-02", opts.since, time.Local); err == nil {
+			since = t
+		} else {
+			return nil, fmt.Errorf("invalid --since %q, expected a duration like 24h or a date like 2006-01-02", opts.since)
+		}
+	}
+
+	var kept []audit.Record
+	for _, record := range records {
+		if record.Prompt == "" || record.Redacted || record.Model == gpt.ImageModel {
+			continue
+		}
+		if opts.conversation != "" && record.ConversationID != opts.conversation {
+			continue
+		}
+		if !since.IsZero() && record.Time.Before(since) {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	if opts.limit > 0 && len(kept) > opts.limit {
+		kept = kept[len(kept)-opts.limit:]
+	}
+	return kept, nil
+}
+
+// replay 用记录中的参数重新请求, 参数按 --persona, --model 覆盖
+func replay(cfg *config.Configuration, record audit.Record, opts options) Result {
+	settings := config.Settings{
+		Profile:      record.Profile,
+		Persona:      record.Persona,
+		SystemPrompt: record.SystemPrompt,
+		Model:        record.Model,
+		Temperature:  record.Temperature,
+		MaxTokens:    record.MaxTokens,
+	}
+	if opts.persona != "" {
+		settings = cfg.WithPersona(settings, opts.persona)
+	}
+	if opts.model != "" {
+		settings.Model = opts.model
+	}
+
+	result := Result{
+		Time:           record.Time,
+		ConversationID: record.ConversationID,
+		Prompt:         record.Prompt,
+		RecordedModel:  record.Model,
+		ReplayModel:    settings.Model,
+		ReplayPersona:  settings.Persona,
+		Recorded:       strings.TrimSpace(record.Response),
+	}
+	completion, err := gpt.Complete(record.Prompt, settings, requester)
+	result.LatencyMs = completion.Latency.Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Replay = strings.TrimSpace(completion.Text)
+	result.Changed = result.Replay != result.Recorded
+	return result
+}
+
+func printResult(w io.Writer, index, total int, result Result) {
+	fmt.Fprintf(w, "=== [%d/%d] %s conversation %s\n", index, total, result.Time.Local().Format("2006-01-02 15:04:05"), result.ConversationID)
+	fmt.Fprintf(w, "prompt: %s\n", result.Prompt)
+	if result.Error != "" {
+		fmt.Fprintf(w, "error: %s\n\n", result.Error)
+		return
+	}
+	if !result.Changed {
+		fmt.Fprintf(w, "identical (%s -> %s, %dms)\n\n", result.RecordedModel, result.ReplayModel, result.LatencyMs)
+		return
+	}
+	fmt.Fprintf(w, "--- recorded (%s)\n", result.RecordedModel)
+	fmt.Fprintf(w, "+++ replay (%s, %dms)\n", result.ReplayModel, result.LatencyMs)
+	for _, line := range diffLines(result.Recorded, result.Replay) {
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+}
+
+// diffLines 按行对比两段文本, 基于最长公共子序列, 相同行以空格开头, 删除以 - 开头, 新增以 + 开头
+func diffLines(a, b string) []string {
+	left, right := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// lcs[i][j] 为 left[i:] 与 right[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(left)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(right)+1)
+	}
+	for i := len(left) - 1; i >= 0; i-- {
+		for j := len(right) - 1; j >= 0; j-- {
+			if left[i] == right[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(left) && j < len(right) {
+		switch {
+		case left[i] == right[j]:
+			lines = append(lines, "  "+left[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "- "+left[i])
+			i++
+		default:
+			lines = append(lines, "+ "+right[j])
+			j++
+		}
+	}
+	for ; i < len(left); i++ {
+		lines = append(lines, "- "+left[i])
+	}
+	for ; j < len(right); j++ {
+		lines = append(lines, "+ "+right[j])
+	}
+	return lines
+}
@@ -0,0 +1,78 @@
+package replay
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/audit"
+	"github.com/coolseven/wechatbot-chatgpt/gpt"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{name: "identical", a: "a\nb", b: "a\nb", want: []string{"  a", "  b"}},
+		{name: "empty", a: "", b: "", want: []string{"  "}},
+		{name: "changed line", a: "a\nb\nc", b: "a\nx\nc", want: []string{"  a", "- b", "+ x", "  c"}},
+		{name: "inserted line", a: "a\nc", b: "a\nb\nc", want: []string{"  a", "+ b", "  c"}},
+		{name: "removed tail", a: "a\nb", b: "a", want: []string{"  a", "- b"}},
+		{name: "added tail", a: "a", b: "a\nb\nc", want: []string{"  a", "+ b", "+ c"}},
+		{name: "swapped", a: "a\nb", b: "b\na", want: []string{"- a", "  b", "+ a"}},
+		{name: "nothing in common", a: "a", b: "b", want: []string{"- a", "+ b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	now := time.Now()
+	records := []audit.Record{
+		{ConversationID: "c1", Prompt: "old", Model: "text-davinci-003", Time: now.Add(-72 * time.Hour)},
+		{ConversationID: "c1", Prompt: "", Model: "text-davinci-003", Time: now.Add(-3 * time.Hour)},
+		{ConversationID: "c2", Prompt: "image", Model: gpt.ImageModel, Time: now.Add(-3 * time.Hour)},
+		{ConversationID: "c2", Prompt: "[5 chars redacted]", Model: "text-davinci-003", Time: now.Add(-3 * time.Hour), Redacted: true},
+		{ConversationID: "c2", Prompt: "three hours", Model: "text-davinci-003", Time: now.Add(-3 * time.Hour)},
+		{ConversationID: "c1", Prompt: "one hour", Model: "text-davinci-003", Time: now.Add(-time.Hour)},
+		{ConversationID: "c2", Prompt: "just now", Model: "text-davinci-003", Time: now},
+	}
+
+	tests := []struct {
+		name    string
+		opts    options
+		want    []string
+		wantErr bool
+	}{
+		{name: "all replayable", want: []string{"old", "three hours", "one hour", "just now"}},
+		{name: "limit keeps latest", opts: options{limit: 2}, want: []string{"one hour", "just now"}},
+		{name: "limit larger than records", opts: options{limit: 10}, want: []string{"old", "three hours", "one hour", "just now"}},
+		{name: "since duration", opts: options{since: "2h"}, want: []string{"one hour", "just now"}},
+		{name: "since date", opts: options{since: now.Add(48 * time.Hour).Format("2006-01-02")}},
+		{name: "conversation", opts: options{conversation: "c1"}, want: []string{"old", "one hour"}},
+		{name: "conversation and limit", opts: options{conversation: "c2", limit: 1}, want: []string{"just now"}},
+		{name: "invalid since", opts: options{since: "yesterday"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, err := filter(records, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, record := range kept {
+				got = append(got, record.Prompt)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter() prompts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
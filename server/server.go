@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+)
+
+// mux 内置 http 服务的路由, 各模块在启动前注册自己的页面和接口
+var mux = http.NewServeMux()
+
+// Handle 注册路由
+func Handle(pattern string, handler http.Handler) {
+	mux.Handle(pattern, handler)
+}
+
+// HandleFunc 注册路由
+func HandleFunc(pattern string, handler func(w http.ResponseWriter, r *http.Request)) {
+	mux.HandleFunc(pattern, handler)
+}
+
+// Start 在后台启动内置 http 服务, addr 为空时不启动
+func Start(addr string) {
+	if addr == "" {
+		return
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.Info(fmt.Sprintf("http server listening on %s", addr))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Warning(fmt.Sprintf("http server error: %v", err))
+		}
+	}()
+}
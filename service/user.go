@@ -1,9 +1,10 @@
 package service
 
 import (
+	"github.com/coolseven/wechatbot-chatgpt/channel"
 	"github.com/coolseven/wechatbot-chatgpt/config"
-	"github.com/eatmoreapple/openwechat"
 	"github.com/patrickmn/go-cache"
+	"strings"
 	"time"
 )
 
@@ -12,6 +13,8 @@ type UserServiceInterface interface {
 	GetUserSessionContext() string
 	SetUserSessionContext(question, reply string)
 	ClearUserSessionContext()
+	GetUserPersona() string
+	SetUserPersona(name string)
 }
 
 var _ UserServiceInterface = (*UserService)(nil)
@@ -21,26 +24,29 @@ type UserService struct {
 	// 缓存
 	cache *cache.Cache
 	// 用户
-	user *openwechat.User
+	user channel.Sender
+	// 消息所在的会话 id, 人设按会话和用户区分
+	conversationID string
 }
 
 // NewUserService 创建新的业务层
-func NewUserService(cache *cache.Cache, user *openwechat.User) UserServiceInterface {
+func NewUserService(cache *cache.Cache, conversationID string, user channel.Sender) UserServiceInterface {
 	return &UserService{
-		cache: cache,
-		user:  user,
+		cache:          cache,
+		user:           user,
+		conversationID: conversationID,
 	}
 }
 
 // ClearUserSessionContext 清空GTP上下文，接收文本中包含`我要问下一个问题`，并且Unicode 字符数量不超过20就清空
 func (s *UserService) ClearUserSessionContext() {
-	s.cache.Delete(s.user.ID())
+	s.cache.Delete(s.user.ID)
 }
 
 // GetUserSessionContext 获取用户会话上下文文本
 func (s *UserService) GetUserSessionContext() string {
 	// 1.获取上次会话信息，如果没有直接返回空字符串
-	sessionContext, ok := s.cache.Get(s.user.ID())
+	sessionContext, ok := s.cache.Get(s.user.ID)
 	if !ok {
 		return ""
 	}
@@ -48,7 +54,7 @@ func (s *UserService) GetUserSessionContext() string {
 	// 2.如果字符长度超过等于4000，强制清空会话（超过GPT会报错）。
 	contextText := sessionContext.(string)
 	if len(contextText) >= 4000 {
-		s.cache.Delete(s.user.ID())
+		s.cache.Delete(s.user.ID)
 	}
 
 	// 3.返回上文
@@ -58,5 +64,45 @@ func (s *UserService) GetUserSessionContext() string {
 // SetUserSessionContext 设置用户会话上下文文本，question用户提问内容，GTP回复内容
 func (s *UserService) SetUserSessionContext(question, reply string) {
 	value := question + "\n" + reply
-	s.cache.Set(s.user.ID(), value, time.Second*config.LoadConfig().SessionTimeout)
+	s.cache.Set(s.user.ID, value, config.LoadConfig().SessionTimeout.Duration)
+}
+
+// GetUserPersona 获取用户切换的人设名称, 未切换时返回空字符串
+func (s *UserService) GetUserPersona() string {
+	persona, ok := s.cache.Get(s.personaKey())
+	if !ok {
+		return ""
+	}
+	return persona.(string)
+}
+
+// SetUserPersona 设置用户在当前会话中的人设, name 为空时恢复默认人设. 人设不随会话超时, personaExpiration 后恢复默认
+func (s *UserService) SetUserPersona(name string) {
+	if name == "" {
+		s.cache.Delete(s.personaKey())
+		return
+	}
+	s.cache.Set(s.personaKey(), name, personaExpiration)
+}
+
+// personaKey 人设按会话和用户区分, 群里切换的人设不影响该用户的私聊和群里的其他人
+func (s *UserService) personaKey() string {
+	return s.conversationID + "|" + s.user.ID + personaKeySuffix
+}
+
+// personaExpiration 切换的人设的有效期
+const personaExpiration = 7 * 24 * time.Hour
+
+// personaKeySuffix 人设缓存 key 的后缀, 区分会话上下文和人设
+const personaKeySuffix = ":persona"
+
+// SessionCount 缓存中未过期的会话上下文数量
+func SessionCount(cache *cache.Cache) int {
+	count := 0
+	for key := range cache.Items() {
+		if !strings.HasSuffix(key, personaKeySuffix) {
+			count++
+		}
+	}
+	return count
 }
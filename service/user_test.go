@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/channel"
+	"github.com/patrickmn/go-cache"
+)
+
+func TestPersonaScopedByConversation(t *testing.T) {
+	c := cache.New(cache.NoExpiration, time.Minute)
+	alice := channel.Sender{ID: "alice", NickName: "Alice"}
+	bob := channel.Sender{ID: "bob", NickName: "Bob"}
+
+	NewUserService(c, "group-1", alice).SetUserPersona("translator")
+
+	if got := NewUserService(c, "group-1", alice).GetUserPersona(); got != "translator" {
+		t.Errorf("persona in the same conversation = %q, want translator", got)
+	}
+	if got := NewUserService(c, "alice", alice).GetUserPersona(); got != "" {
+		t.Errorf("persona in private chat = %q, want default", got)
+	}
+	if got := NewUserService(c, "group-1", bob).GetUserPersona(); got != "" {
+		t.Errorf("persona of another member = %q, want default", got)
+	}
+
+	_, expiration, ok := c.GetWithExpiration("group-1|alice" + personaKeySuffix)
+	if !ok || expiration.IsZero() {
+		t.Errorf("persona should expire, got expiration %v", expiration)
+	}
+	if SessionCount(c) != 0 {
+		t.Errorf("SessionCount = %d, personas are not sessions", SessionCount(c))
+	}
+
+	NewUserService(c, "group-1", alice).SetUserPersona("")
+	if got := NewUserService(c, "group-1", alice).GetUserPersona(); got != "" {
+		t.Errorf("persona after reset = %q, want default", got)
+	}
+}
@@ -0,0 +1,39 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/pkg/metrics"
+)
+
+var errorsTotal = metrics.NewCounter("wechatbot_errors_total", "Errors by class.", "class")
+
+func init() {
+	metrics.NewGaugeFunc("wechatbot_uptime_seconds", "Seconds since the service started.", func() float64 {
+		return Uptime().Seconds()
+	})
+	metrics.NewGaugeFunc("wechatbot_start_time_seconds", "Unix time when the service started.", func() float64 {
+		return float64(StartedAt.UnixNano()) / float64(time.Second)
+	})
+}
+
+// 错误分类, 用于 wechatbot_errors_total 的 class 标签
+const (
+	ErrorOpenAIRateLimited  = "openai_rate_limited"
+	ErrorOpenAIQuota        = "openai_quota_exhausted"
+	ErrorOpenAIUnauthorized = "openai_unauthorized"
+	ErrorOpenAIBadRequest   = "openai_bad_request"
+	ErrorOpenAIServer       = "openai_server_error"
+	ErrorOpenAITimeout      = "openai_timeout"
+	ErrorOpenAIOther        = "openai_other"
+	ErrorNoApiKey           = "openai_no_api_key"
+	ErrorWechatReply        = "wechat_reply"
+	ErrorWechatMessage      = "wechat_message"
+	ErrorImageDecode        = "image_decode"
+	ErrorMessagePanic       = "message_panic"
+)
+
+// IncError 按分类统计错误
+func IncError(class string) {
+	errorsTotal.Inc(class)
+}
@@ -0,0 +1,275 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version 版本号, 编译时通过 -ldflags "-X github.com/coolseven/wechatbot-chatgpt/stats.Version=v1.0.0" 注入
+var Version = "dev"
+
+// StartedAt 服务启动时间
+var StartedAt = time.Now()
+
+var (
+	messagesReceived uint64
+	repliesSent      uint64
+
+	lastErrorLock sync.RWMutex
+	lastError     string
+
+	checkpointsLock   sync.RWMutex
+	lastSync          time.Time
+	lastOpenAISuccess time.Time
+	lastOpenAIFailure time.Time
+	lastOpenAIError   string
+)
+
+// Uptime 服务已运行的时长
+func Uptime() time.Duration {
+	return time.Since(StartedAt)
+}
+
+// IncMessagesReceived 收到的消息数加一
+func IncMessagesReceived() {
+	atomic.AddUint64(&messagesReceived, 1)
+}
+
+// MessagesReceived 收到的消息数
+func MessagesReceived() uint64 {
+	return atomic.LoadUint64(&messagesReceived)
+}
+
+// IncRepliesSent 发出的回复数加一
+func IncRepliesSent() {
+	atomic.AddUint64(&repliesSent, 1)
+}
+
+// RepliesSent 发出的回复数
+func RepliesSent() uint64 {
+	return atomic.LoadUint64(&repliesSent)
+}
+
+// SetLastError 记录最近一次错误
+func SetLastError(err error) {
+	if err == nil {
+		return
+	}
+	lastErrorLock.Lock()
+	defer lastErrorLock.Unlock()
+	lastError = err.Error()
+}
+
+// LastError 最近一次错误, 没有错误时为空字符串
+func LastError() string {
+	lastErrorLock.RLock()
+	defer lastErrorLock.RUnlock()
+	return lastError
+}
+
+// MarkSynced 记录一次成功的微信消息同步(心跳)
+func MarkSynced() {
+	checkpointsLock.Lock()
+	defer checkpointsLock.Unlock()
+	lastSync = time.Now()
+}
+
+// LastSync 最近一次成功同步的时间, 从未同步时为零值
+func LastSync() time.Time {
+	checkpointsLock.RLock()
+	defer checkpointsLock.RUnlock()
+	return lastSync
+}
+
+// MarkOpenAI 记录一次 openai 调用的结果
+func MarkOpenAI(err error) {
+	checkpointsLock.Lock()
+	defer checkpointsLock.Unlock()
+	if err == nil {
+		lastOpenAISuccess = time.Now()
+		return
+	}
+	lastOpenAIFailure = time.Now()
+	lastOpenAIError = err.Error()
+}
+
+// LastOpenAISuccess 最近一次成功调用 openai 的时间, 从未成功时为零值
+func LastOpenAISuccess() time.Time {
+	checkpointsLock.RLock()
+	defer checkpointsLock.RUnlock()
+	return lastOpenAISuccess
+}
+
+// LastOpenAIFailure 最近一次调用 openai 失败的时间和错误, 从未失败时为零值
+func LastOpenAIFailure() (time.Time, string) {
+	checkpointsLock.RLock()
+	defer checkpointsLock.RUnlock()
+	return lastOpenAIFailure, lastOpenAIError
+}
+
+// recentMessagesLimit 保留的最近消息条数
+const recentMessagesLimit = 100
+
+const (
+	// conversationsLimit 保留的会话数量上限, 超过时先清理不活跃的会话, 仍然超过时去掉最久没有活跃的会话
+	conversationsLimit = 1000
+	// conversationIdleTimeout 超过该时长没有消息的会话可以被清理
+	conversationIdleTimeout = 24 * time.Hour
+)
+
+// MessageRecord 一条消息及回复, 用于管理后台展示
+type MessageRecord struct {
+	Time time.Time
+	// 会话 id, 私聊为用户 id, 群聊为群 id
+	ConversationID string
+	// 会话名称, 私聊为用户昵称, 群聊为群昵称
+	Conversation string
+	// 发送者昵称
+	Sender  string
+	Content string
+	Reply   string
+}
+
+// Conversation 会话的活跃情况
+type Conversation struct {
+	ID   string
+	Name string
+	// 是否为群聊
+	IsGroup    bool
+	Messages   uint64
+	LastActive time.Time
+}
+
+// TokenUsage 某个模型的 token 用量
+type TokenUsage struct {
+	Requests         uint64
+	PromptTokens     uint64
+	CompletionTokens uint64
+	Images           uint64
+}
+
+var (
+	recordsLock    sync.RWMutex
+	recentMessages []MessageRecord
+	conversations  = map[string]*Conversation{}
+	usage          = map[string]*TokenUsage{}
+	hourlyMessages = map[int64]uint64{}
+)
+
+// RecordMessage 记录一条消息及回复, 同时更新会话的活跃情况
+func RecordMessage(record MessageRecord, isGroup bool) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	recentMessages = append(recentMessages, record)
+	if len(recentMessages) > recentMessagesLimit {
+		recentMessages = recentMessages[len(recentMessages)-recentMessagesLimit:]
+	}
+
+	conversation, ok := conversations[record.ConversationID]
+	if !ok {
+		if len(conversations) >= conversationsLimit {
+			evictConversations(record.Time)
+		}
+		conversation = &Conversation{ID: record.ConversationID, IsGroup: isGroup}
+		conversations[record.ConversationID] = conversation
+	}
+	conversation.Name = record.Conversation
+	conversation.Messages++
+	conversation.LastActive = record.Time
+
+	hour := record.Time.Truncate(time.Hour).Unix()
+	hourlyMessages[hour]++
+	for bucket := range hourlyMessages {
+		if bucket < hour-int64(24*time.Hour/time.Second) {
+			delete(hourlyMessages, bucket)
+		}
+	}
+}
+
+// evictConversations 清理 now 之前超过 conversationIdleTimeout 没有活跃的会话, 仍然达到上限时去掉最久没有活跃的会话
+func evictConversations(now time.Time) {
+	var oldest *Conversation
+	for id, conversation := range conversations {
+		if now.Sub(conversation.LastActive) > conversationIdleTimeout {
+			delete(conversations, id)
+			continue
+		}
+		if oldest == nil || conversation.LastActive.Before(oldest.LastActive) {
+			oldest = conversation
+		}
+	}
+	if len(conversations) >= conversationsLimit && oldest != nil {
+		delete(conversations, oldest.ID)
+	}
+}
+
+// RecentMessages 最近的消息, 最新的在前
+func RecentMessages() []MessageRecord {
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+	records := make([]MessageRecord, 0, len(recentMessages))
+	for i := len(recentMessages) - 1; i >= 0; i-- {
+		records = append(records, recentMessages[i])
+	}
+	return records
+}
+
+// ActiveConversations since 之后活跃过的会话, 最近活跃的在前
+func ActiveConversations(since time.Time) []Conversation {
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+	var result []Conversation
+	for _, conversation := range conversations {
+		if conversation.LastActive.After(since) {
+			result = append(result, *conversation)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastActive.After(result[j].LastActive)
+	})
+	return result
+}
+
+// HourlyMessages 最近 24 小时每小时的消息数, 按时间先后排列
+func HourlyMessages() []uint64 {
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+	now := time.Now().Truncate(time.Hour)
+	counts := make([]uint64, 24)
+	for i := range counts {
+		counts[i] = hourlyMessages[now.Add(time.Duration(i-23)*time.Hour).Unix()]
+	}
+	return counts
+}
+
+// AddUsage 累加模型的用量
+func AddUsage(model string, promptTokens, completionTokens, images int) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+	modelUsage, ok := usage[model]
+	if !ok {
+		modelUsage = &TokenUsage{}
+		usage[model] = modelUsage
+	}
+	modelUsage.Requests++
+	modelUsage.PromptTokens += uint64(promptTokens)
+	modelUsage.CompletionTokens += uint64(completionTokens)
+	modelUsage.Images += uint64(images)
+}
+
+// Usage 各模型的累计用量
+func Usage() map[string]TokenUsage {
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+	result := make(map[string]TokenUsage, len(usage))
+	for model, modelUsage := range usage {
+		result[model] = *modelUsage
+	}
+	return result
+}
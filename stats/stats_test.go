@@ -0,0 +1,31 @@
+package stats
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConversationsBounded(t *testing.T) {
+	now := time.Now()
+	// 一个不活跃的会话和足够多的活跃会话, 不活跃的先被清理, 之后按最久没有活跃的淘汰
+	RecordMessage(MessageRecord{ConversationID: "idle", Time: now.Add(-2 * conversationIdleTimeout)}, false)
+	for i := 0; i < conversationsLimit+10; i++ {
+		RecordMessage(MessageRecord{ConversationID: strconv.Itoa(i), Time: now.Add(time.Duration(i) * time.Second)}, false)
+	}
+
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+	if len(conversations) > conversationsLimit {
+		t.Errorf("conversations = %d, want at most %d", len(conversations), conversationsLimit)
+	}
+	if _, ok := conversations["idle"]; ok {
+		t.Error("idle conversation should be evicted")
+	}
+	if _, ok := conversations["0"]; ok {
+		t.Error("least recently active conversation should be evicted")
+	}
+	if _, ok := conversations[strconv.Itoa(conversationsLimit+9)]; !ok {
+		t.Error("latest conversation should be kept")
+	}
+}
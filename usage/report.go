@@ -0,0 +1,162 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/config"
+)
+
+// reportTopN 报告中按用户和按群各列出的条数
+const reportTopN = 10
+
+// Summary 一组记录的汇总
+type Summary struct {
+	Name             string
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	Images           int
+	Cost             float64
+}
+
+func (s *Summary) add(record Record) {
+	s.Requests++
+	s.PromptTokens += record.PromptTokens
+	s.CompletionTokens += record.CompletionTokens
+	s.Images += record.Images
+	s.Cost += record.Cost
+}
+
+// Report 一个周期内的用量报告
+type Report struct {
+	Period  string
+	From    time.Time
+	To      time.Time
+	Total   Summary
+	ByModel []Summary
+	ByUser  []Summary
+	ByGroup []Summary
+}
+
+// PeriodRange 周期的起止时间, previous 为 true 时返回上一个完整周期, 否则返回本周期开始到 now
+// 周从周一开始
+func PeriodRange(period string, now time.Time, previous bool) (time.Time, time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var start, previousStart time.Time
+	switch period {
+	case config.ReportWeekly:
+		start = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		previousStart = start.AddDate(0, 0, -7)
+	case config.ReportMonthly:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		previousStart = start.AddDate(0, -1, 0)
+	default:
+		start = today
+		previousStart = today.AddDate(0, 0, -1)
+	}
+	if previous {
+		return previousStart, start
+	}
+	return start, now
+}
+
+// BuildReport 汇总 [from, to) 之间的用量
+func BuildReport(period string, from, to time.Time) (Report, error) {
+	records, err := Load(from, to)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Period: period, From: from, To: to}
+	byModel := map[string]*Summary{}
+	byUser := map[string]*Summary{}
+	byGroup := map[string]*Summary{}
+	for _, record := range records {
+		report.Total.add(record)
+		summarize(byModel, record.Model, record)
+		summarize(byUser, record.User, record)
+		if record.Group != "" {
+			summarize(byGroup, record.Group, record)
+		}
+	}
+	report.ByModel = sortSummaries(byModel)
+	report.ByUser = sortSummaries(byUser)
+	report.ByGroup = sortSummaries(byGroup)
+	return report, nil
+}
+
+func summarize(summaries map[string]*Summary, name string, record Record) {
+	if name == "" {
+		name = "(unknown)"
+	}
+	summary, ok := summaries[name]
+	if !ok {
+		summary = &Summary{Name: name}
+		summaries[name] = summary
+	}
+	summary.add(record)
+}
+
+// sortSummaries 按费用从高到低排序, 费用相同时按请求数
+func sortSummaries(summaries map[string]*Summary) []Summary {
+	result := make([]Summary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Cost != result[j].Cost {
+			return result[i].Cost > result[j].Cost
+		}
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+var periodNames = map[string]string{
+	config.ReportDaily:   "日报",
+	config.ReportWeekly:  "周报",
+	config.ReportMonthly: "月报",
+}
+
+// Text 报告的文本形式, 用于聊天回复和告警渠道
+func (r Report) Text(currency string) string {
+	lines := []string{
+		fmt.Sprintf("用量%s %s ~ %s", periodNames[r.Period], r.From.Format("2006-01-02 15:04"), r.To.Format("2006-01-02 15:04")),
+		"合计: " + r.Total.line(currency),
+	}
+	if r.Total.Requests == 0 {
+		return strings.Join(lines, "\n")
+	}
+	lines = append(lines, section("按模型", r.ByModel, currency)...)
+	lines = append(lines, section("按用户", r.ByUser, currency)...)
+	if len(r.ByGroup) > 0 {
+		lines = append(lines, section("按群", r.ByGroup, currency)...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func section(title string, summaries []Summary, currency string) []string {
+	if len(summaries) > reportTopN {
+		title = fmt.Sprintf("%s(前 %d)", title, reportTopN)
+		summaries = summaries[:reportTopN]
+	}
+	lines := []string{title + ":"}
+	for _, summary := range summaries {
+		lines = append(lines, "  "+summary.Name+": "+summary.line(currency))
+	}
+	return lines
+}
+
+func (s Summary) line(currency string) string {
+	line := fmt.Sprintf("%d 次, prompt %d tokens, completion %d tokens", s.Requests, s.PromptTokens, s.CompletionTokens)
+	if s.Images > 0 {
+		line += fmt.Sprintf(", 图片 %d 张", s.Images)
+	}
+	return line + fmt.Sprintf(", 费用 %.4f %s", s.Cost, currency)
+}
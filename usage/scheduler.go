@@ -0,0 +1,60 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/alert"
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+)
+
+// StartScheduler 在后台按 usage_reports 定时通过告警渠道发送上一个周期的用量报告.
+// 日报每天发送, 周报在周一发送, 月报在每月 1 日发送, 发送时间为 usage_report_time
+func StartScheduler() {
+	if len(config.LoadConfig().UsageReports) == 0 {
+		return
+	}
+	go func() {
+		for {
+			next := nextReportTime(time.Now(), config.LoadConfig().UsageReportTime)
+			time.Sleep(time.Until(next))
+			sendScheduledReports(next)
+		}
+	}()
+}
+
+// nextReportTime now 之后的下一个发送时间
+func nextReportTime(now time.Time, reportTime string) time.Time {
+	clock, err := time.Parse("15:04", reportTime)
+	if err != nil {
+		clock, _ = time.Parse("15:04", "09:00")
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func sendScheduledReports(now time.Time) {
+	cfg := config.LoadConfig()
+	for _, period := range cfg.UsageReports {
+		if period == config.ReportWeekly && now.Weekday() != time.Monday {
+			continue
+		}
+		if period == config.ReportMonthly && now.Day() != 1 {
+			continue
+		}
+		from, to := PeriodRange(period, now, true)
+		report, err := BuildReport(period, from, to)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("build %s usage report error: %v", period, err))
+			continue
+		}
+		if err := alert.Send(context.Background(), alert.EventUsageReport, alert.Data{Report: report.Text(cfg.Currency)}); err != nil {
+			logger.Warning(fmt.Sprintf("send %s usage report error: %v", period, err))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coolseven/wechatbot-chatgpt/config"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/logger"
+	"github.com/coolseven/wechatbot-chatgpt/pkg/redact"
+)
+
+// Requester 发起请求的用户和群, 私聊时群为空
+type Requester struct {
+	UserID  string
+	User    string
+	GroupID string
+	Group   string
+}
+
+// Record 一次 openai 请求的用量, 追加写入 usage_file, 每行一条
+type Record struct {
+	Time             time.Time `json:"time"`
+	UserID           string    `json:"user_id,omitempty"`
+	User             string    `json:"user,omitempty"`
+	GroupID          string    `json:"group_id,omitempty"`
+	Group            string    `json:"group,omitempty"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Images           int       `json:"images,omitempty"`
+	ImageSize        string    `json:"image_size,omitempty"`
+	// 按记录时的价格表计算的费用
+	Cost float64 `json:"cost"`
+}
+
+var fileLock sync.Mutex
+
+// Add 计算费用并追加一条用量记录, 未配置 usage_file 时不记录
+func Add(requester Requester, record Record) {
+	cfg := config.LoadConfig()
+	if cfg.UsageFile == "" {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	// strict 级别下与审计记录一样只记录昵称和 id 的哈希值, 同一用户的哈希值不变, 仍然可以按用户统计
+	record.UserID = redact.Identity(requester.UserID)
+	record.User = redact.Identity(requester.User)
+	record.GroupID = redact.Identity(requester.GroupID)
+	record.Group = redact.Identity(requester.Group)
+	record.Cost = Cost(cfg.Prices, record)
+
+	if err := appendRecord(cfg.UsageFile, record); err != nil {
+		logger.Warning(fmt.Sprintf("write usage record error: %v", err))
+	}
+}
+
+// Cost 按价格表计算一条记录的费用, 价格表中没有的模型费用为 0
+func Cost(prices map[string]config.Price, record Record) float64 {
+	price, ok := prices[record.Model]
+	if !ok {
+		return 0
+	}
+	cost := float64(record.PromptTokens)/1000*price.Prompt + float64(record.CompletionTokens)/1000*price.Completion
+	if record.Images > 0 {
+		cost += float64(record.Images) * price.Images[record.ImageSize]
+	}
+	return cost
+}
+
+func appendRecord(path string, record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Load 读取 [from, to) 之间的用量记录, 无法解析的行会被跳过
+func Load(from, to time.Time) ([]Record, error) {
+	path := config.LoadConfig().UsageFile
+	if path == "" {
+		return nil, fmt.Errorf("usage accounting is disabled, set usage_file to enable it")
+	}
+
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	skipped := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			skipped++
+			continue
+		}
+		if record.Time.Before(from) || !record.Time.Before(to) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if skipped > 0 {
+		logger.Warning(fmt.Sprintf("skipped %d malformed lines in usage file %s", skipped, path))
+	}
+	return records, scanner.Err()
+}